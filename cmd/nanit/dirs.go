@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -17,32 +19,47 @@ func ensureDataDirectories() app.DataDirectories {
 		log.Fatal().Str("path", relDataDir).Err(filePathErr).Msg("Unable to retrieve absolute file path")
 	}
 
-	// Create base data directory if it does not exist
-	if _, err := os.Stat(absDataDir); os.IsNotExist(err) {
-		log.Warn().Str("dir", absDataDir).Msg("Data directory does not exist, creating")
-		mkdirErr := os.MkdirAll(absDataDir, 0755)
-		if mkdirErr != nil {
-			log.Fatal().Str("path", absDataDir).Err(mkdirErr).Msg("Unable to create a directory")
-		}
+	dirs := app.DataDirectories{
+		BaseDir:  absDataDir,
+		VideoDir: filepath.Join(absDataDir, "video"),
+		LogDir:   filepath.Join(absDataDir, "log"),
 	}
 
 	// Create data dir skeleton
-	for _, subdirName := range []string{"video", "log"} {
-		absSubdir := filepath.Join(absDataDir, subdirName)
-
-		if _, err := os.Stat(absSubdir); os.IsNotExist(err) {
-			mkdirErr := os.Mkdir(absSubdir, 0755)
-			if mkdirErr != nil {
-				log.Fatal().Str("path", absDataDir).Err(mkdirErr).Msg("Unable to create a directory")
-			} else {
-				log.Info().Str("dir", absSubdir).Msgf("Directory created ./%v", subdirName)
-			}
+	ensureWritableDir(dirs.BaseDir, "data")
+	ensureWritableDir(dirs.VideoDir, "video")
+	ensureWritableDir(dirs.LogDir, "log")
+
+	return dirs
+}
+
+// ensureWritableDir - creates the directory if it does not exist and verifies that files can be written to it
+// Note: fails right on startup instead of in the middle of the run (ie. read-only or foreign-owned Docker volume)
+func ensureWritableDir(dir string, purpose string) {
+	if err := checkWritableDir(dir); err != nil {
+		log.Fatal().Str("dir", dir).Err(err).Msgf("The %v directory is not usable, check that the volume is mounted and writeable by uid %v", purpose, os.Getuid())
+	}
+}
+
+func checkWritableDir(dir string) error {
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("unable to create the directory: %w", err)
 		}
+
+		log.Info().Str("dir", dir).Msg("Directory created")
+	} else if err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%v is not a directory", dir)
 	}
 
-	return app.DataDirectories{
-		BaseDir:  absDataDir,
-		VideoDir: filepath.Join(absDataDir, "video"),
-		LogDir:   filepath.Join(absDataDir, "log"),
+	f, err := ioutil.TempFile(dir, ".write-test-*")
+	if err != nil {
+		return fmt.Errorf("directory is not writeable: %w", err)
 	}
+
+	f.Close()
+	return os.Remove(f.Name())
 }
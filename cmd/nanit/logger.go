@@ -1,7 +1,10 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -9,23 +12,96 @@ import (
 	"gitlab.com/adam.stanek/nanit/pkg/utils"
 )
 
+// logComponents - packages with their own logger (see NANIT_LOG_LEVELS)
+var logComponents = []string{"app", "client", "history", "homekit", "mqtt", "notify", "rtmpserver", "session", "state"}
+
 // Set log level after env. initialization
 func setLogLevel() {
+	logLevel, componentLevels, err := getLogLevels()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid log level configuration")
+	}
+
+	applyLogLevels(logLevel, componentLevels)
+}
+
+// reloadLogLevel - re-reads the log levels from .env file, keeps the current ones if they are not valid
+func reloadLogLevel() {
+	if err := utils.ReloadDotEnvFile(); err != nil {
+		log.Error().Err(err).Msg("Unable to reload .env file")
+		return
+	}
+
+	logLevel, componentLevels, err := getLogLevels()
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid log level configuration, keeping the current one")
+		return
+	}
+
+	applyLogLevels(logLevel, componentLevels)
+}
+
+func getLogLevels() (zerolog.Level, map[string]zerolog.Level, error) {
 	// Try to read log level from env. variable
 	logLevelStr := utils.EnvVarStr("NANIT_LOG_LEVEL", "info")
 	logLevel, _ := zerolog.ParseLevel(logLevelStr)
 	if logLevel == zerolog.NoLevel {
-		log.Fatal().Str("value", logLevelStr).Msg("Unknown log level specified")
+		return logLevel, nil, fmt.Errorf("unknown log level %q", logLevelStr)
 	}
 
+	// Component specific levels, ie. client=debug,mqtt=warn
+	componentLevels := make(map[string]zerolog.Level)
+	for _, item := range utils.EnvVarList("NANIT_LOG_LEVELS", nil) {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || !isLogComponent(strings.TrimSpace(parts[0])) {
+			return logLevel, nil, fmt.Errorf("invalid component log level %q, expected {component}={level} where component is one of %v", item, logComponents)
+		}
+
+		level, _ := zerolog.ParseLevel(strings.TrimSpace(parts[1]))
+		if level == zerolog.NoLevel {
+			return logLevel, nil, fmt.Errorf("unknown log level in %q", item)
+		}
+
+		componentLevels[strings.TrimSpace(parts[0])] = level
+	}
+
+	return logLevel, componentLevels, nil
+}
+
+func applyLogLevels(logLevel zerolog.Level, componentLevels map[string]zerolog.Level) {
 	log.Info().Msgf("Setting log level to %v", logLevel)
-	zerolog.SetGlobalLevel(logLevel)
+	if len(componentLevels) > 0 {
+		log.Info().Msgf("Setting component log levels to %v", formatComponentLevels(componentLevels))
+	}
+
+	utils.SetLogLevels(logLevel, componentLevels)
 }
 
 // Set logger for application bootstrap
 func initLogger() {
 	// Initial log level, overridden later by setLogLevel
-	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	utils.SetLogLevels(zerolog.InfoLevel, nil)
 	consoleWriter := zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC822}
-	log.Logger = log.Output(consoleWriter)
+	utils.SetLogOutput(consoleWriter)
+	log.Logger = log.Output(consoleWriter).Hook(utils.LogLevelHook(""))
+}
+
+func isLogComponent(component string) bool {
+	for _, c := range logComponents {
+		if c == component {
+			return true
+		}
+	}
+
+	return false
+}
+
+func formatComponentLevels(componentLevels map[string]zerolog.Level) string {
+	var items []string
+	for component, level := range componentLevels {
+		items = append(items, component+"="+level.String())
+	}
+
+	sort.Strings(items)
+	return strings.Join(items, ",")
 }
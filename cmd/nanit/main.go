@@ -1,30 +1,94 @@
 package main
 
 import (
+	"flag"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"regexp"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"gitlab.com/adam.stanek/nanit/pkg/app"
+	"gitlab.com/adam.stanek/nanit/pkg/client"
+	"gitlab.com/adam.stanek/nanit/pkg/history"
+	"gitlab.com/adam.stanek/nanit/pkg/homekit"
 	"gitlab.com/adam.stanek/nanit/pkg/mqtt"
+	"gitlab.com/adam.stanek/nanit/pkg/notify"
+	"gitlab.com/adam.stanek/nanit/pkg/rtmpserver"
 	"gitlab.com/adam.stanek/nanit/pkg/utils"
 )
 
 func main() {
+	once := flag.Bool("once", false, "Capture a single snapshot of the stream and exit")
+	onceBabyUID := flag.String("baby", "", "Baby UID for the -once mode (optional if the account has a single baby)")
+	onceOutput := flag.String("output", "snapshot.jpg", "Output file for the -once mode")
+	configFile := flag.String("config", "", "Config file (.yaml, .toml, .json or .env), environment variables take precedence")
+	flag.Parse()
+
 	initLogger()
 	logAppVersion()
 	utils.LoadDotEnvFile()
+	if *configFile != "" {
+		utils.LoadConfigFile(*configFile)
+	}
+
 	setLogLevel()
 
+	if caFile := utils.EnvVarStr("NANIT_TLS_CA_FILE", ""); caFile != "" {
+		if err := client.TrustOnlyCAFile(caFile); err != nil {
+			log.Fatal().Err(err).Msg("Unable to load CA file")
+		}
+
+		log.Info().Str("path", caFile).Msg("Trusting only CAs from the file")
+	}
+
 	opts := app.Opts{
 		NanitCredentials: app.NanitCredentials{
 			Email:    utils.EnvVarReqStr("NANIT_EMAIL"),
 			Password: utils.EnvVarReqStr("NANIT_PASSWORD"),
+
+			TokenLifetime:      utils.EnvVarDuration("NANIT_TOKEN_LIFETIME", client.AuthTokenTimelife),
+			TokenRefreshMargin: utils.EnvVarDuration("NANIT_TOKEN_REFRESH_MARGIN", time.Minute),
+			ClockSkewTolerance: utils.EnvVarDuration("NANIT_CLOCK_SKEW_TOLERANCE", 5*time.Minute),
+			ProxyURL:           utils.EnvVarStr("NANIT_PROXY_URL", ""),
 		},
 		SessionFile:     utils.EnvVarStr("NANIT_SESSION_FILE", ""),
 		DataDirectories: ensureDataDirectories(),
-		HTTPEnabled:     false,
+		HTTPEnabled:     utils.EnvVarBool("NANIT_HTTP_ENABLED", false),
+		HTTPAdminToken:  utils.EnvVarStr("NANIT_HTTP_ADMIN_TOKEN", ""),
+		BuildInfo:       getBuildInfo(),
+
+		ShutdownTimeout:         utils.EnvVarDuration("NANIT_SHUTDOWN_TIMEOUT", 30*time.Second),
+		WebsocketMaxFailures:    utils.EnvVarInt("NANIT_WEBSOCKET_MAX_FAILURES", 0),
+		WebsocketConnectTimeout: utils.EnvVarDuration("NANIT_WEBSOCKET_CONNECT_TIMEOUT", 2*time.Minute),
+		SharedCameras:           utils.EnvVarStr("NANIT_SHARED_CAMERAS", app.SharedCameras_Share),
+		ResumeThreshold:         utils.EnvVarDuration("NANIT_RESUME_THRESHOLD", 30*time.Second),
+		NoBabies:                utils.EnvVarStr("NANIT_NO_BABIES", app.NoBabies_Retry),
+		NoBabiesRetryInterval:   utils.EnvVarDuration("NANIT_NO_BABIES_RETRY_INTERVAL", 5*time.Minute),
+
+		CaptureSidecars:       utils.EnvVarBool("NANIT_CAPTURE_SIDECARS_ENABLED", false),
+		LogStateTransitions:   utils.EnvVarBool("NANIT_LOG_STATE_TRANSITIONS", false),
+		HTTPDashboard:         utils.EnvVarBool("NANIT_HTTP_DASHBOARD_ENABLED", false),
+		HTTPSnapshotCacheTTL:  utils.EnvVarDuration("NANIT_HTTP_SNAPSHOT_CACHE_TTL", 5*time.Second),
+		HTTPSnapshotMaxWidth:  utils.EnvVarInt("NANIT_HTTP_SNAPSHOT_MAX_WIDTH", 1920),
+		HTTPSnapshotMaxHeight: utils.EnvVarInt("NANIT_HTTP_SNAPSHOT_MAX_HEIGHT", 1080),
+
+		Sensors: app.SensorOpts{
+			RefreshInterval: utils.EnvVarDuration("NANIT_SENSOR_REFRESH_INTERVAL", 5*time.Minute),
+			StaleTimeout:    utils.EnvVarDuration("NANIT_SENSOR_STALE_TIMEOUT", 15*time.Minute),
+			TemperatureRange: app.SensorRange{
+				Min: utils.EnvVarFloat("NANIT_SENSOR_TEMPERATURE_MIN", -10),
+				Max: utils.EnvVarFloat("NANIT_SENSOR_TEMPERATURE_MAX", 50),
+			},
+			HumidityRange: app.SensorRange{
+				Min: utils.EnvVarFloat("NANIT_SENSOR_HUMIDITY_MIN", 0),
+				Max: utils.EnvVarFloat("NANIT_SENSOR_HUMIDITY_MAX", 100),
+			},
+			PublishUnchanged: utils.EnvVarBool("NANIT_SENSOR_PUBLISH_UNCHANGED", false),
+		},
 	}
 
 	if utils.EnvVarBool("NANIT_RTMP_ENABLED", true) {
@@ -37,28 +101,241 @@ func main() {
 		opts.RTMP = &app.RTMPOpts{
 			ListenAddr: m[1],
 			PublicAddr: publicAddr,
+			Probe: rtmpserver.ProbeOpts{
+				MinFrames: utils.EnvVarInt("NANIT_RTMP_PROBE_MIN_FRAMES", 10),
+				Duration:  utils.EnvVarDuration("NANIT_RTMP_PROBE_DURATION", 1*time.Second),
+
+				UnhealthyDebounce: utils.EnvVarDuration("NANIT_RTMP_UNHEALTHY_DEBOUNCE", 10*time.Second),
+			},
+			StartupGrace: utils.EnvVarDuration("NANIT_RTMP_STARTUP_GRACE", 30*time.Second),
+		}
+	}
+
+	if utils.EnvVarBool("NANIT_TIMELAPSE_ENABLED", false) {
+		opts.Timelapse = &app.TimelapseOpts{
+			Interval:           utils.EnvVarDuration("NANIT_TIMELAPSE_INTERVAL", 5*time.Minute),
+			Dir:                utils.EnvVarStr("NANIT_TIMELAPSE_DIR", filepath.Join(opts.DataDirectories.BaseDir, "timelapse")),
+			Retention:          utils.EnvVarDuration("NANIT_TIMELAPSE_RETENTION", 7*24*time.Hour),
+			MinStreamAge:       utils.EnvVarDuration("NANIT_TIMELAPSE_MIN_STREAM_AGE", 10*time.Second),
+			MinStreamAgeByBaby: utils.EnvVarDurationMap("NANIT_TIMELAPSE_MIN_STREAM_AGE_PER_BABY"),
+			FilenameFormat:     utils.EnvVarStr("NANIT_TIMELAPSE_FILENAME_FORMAT", ""),
+		}
+	}
+
+	if utils.EnvVarBool("NANIT_HOMEKIT_ENABLED", false) {
+		opts.HomeKit = &homekit.Opts{
+			Pin:        utils.EnvVarReqStr("NANIT_HOMEKIT_PIN"),
+			Port:       utils.EnvVarStr("NANIT_HOMEKIT_PORT", ""),
+			StorageDir: utils.EnvVarStr("NANIT_HOMEKIT_STORAGE_DIR", filepath.Join(opts.DataDirectories.BaseDir, "homekit")),
+		}
+	}
+
+	if utils.EnvVarBool("NANIT_HISTORY_ENABLED", false) {
+		opts.History = &history.Opts{
+			Dir:       utils.EnvVarStr("NANIT_HISTORY_DIR", filepath.Join(opts.DataDirectories.BaseDir, "history")),
+			Retention: utils.EnvVarDuration("NANIT_HISTORY_RETENTION", 30*24*time.Hour),
+		}
+	}
+
+	if utils.EnvVarBool("NANIT_CAM_LOGS_ENABLED", false) {
+		opts.CamLogs = &app.CamLogsOpts{
+			ReceiverURL: utils.EnvVarStr("NANIT_CAM_LOGS_RECEIVER_URL", ""),
+		}
+	}
+
+	if utils.EnvVarBool("NANIT_PREVIEW_ENABLED", false) {
+		opts.Preview = &app.PreviewOpts{
+			Interval: utils.EnvVarDuration("NANIT_PREVIEW_INTERVAL", time.Minute),
+			Width:    utils.EnvVarInt("NANIT_PREVIEW_WIDTH", 640),
+		}
+	}
+
+	if utils.EnvVarBool("NANIT_REWIND_ENABLED", false) {
+		opts.Rewind = &app.RewindOpts{
+			Duration:        utils.EnvVarDuration("NANIT_REWIND_DURATION", time.Minute),
+			SegmentDuration: utils.EnvVarDuration("NANIT_REWIND_SEGMENT_DURATION", 2*time.Second),
+		}
+	}
+
+	if utils.EnvVarBool("NANIT_PRESENCE_ENABLED", false) {
+		opts.Presence = &app.PresenceOpts{
+			ActiveWindow:       utils.EnvVarDuration("NANIT_PRESENCE_ACTIVE_WINDOW", time.Minute),
+			AwakeWindow:        utils.EnvVarDuration("NANIT_PRESENCE_AWAKE_WINDOW", 10*time.Minute),
+			SleepRequiresNight: utils.EnvVarBool("NANIT_PRESENCE_SLEEP_REQUIRES_NIGHT", false),
 		}
 	}
 
 	if utils.EnvVarBool("NANIT_MQTT_ENABLED", false) {
 		opts.MQTT = &mqtt.Opts{
-			BrokerURL:   utils.EnvVarReqStr("NANIT_MQTT_BROKER_URL"),
-			ClientID:    utils.EnvVarStr("NANIT_MQTT_CLIENT_ID", "nanit"),
-			Username:    utils.EnvVarStr("NANIT_MQTT_USERNAME", ""),
-			Password:    utils.EnvVarStr("NANIT_MQTT_PASSWORD", ""),
-			TopicPrefix: utils.EnvVarStr("NANIT_MQTT_PREFIX", "nanit"),
+			BrokerURL:    utils.EnvVarReqStr("NANIT_MQTT_BROKER_URL"),
+			ClientID:     utils.EnvVarStr("NANIT_MQTT_CLIENT_ID", ""),
+			CleanSession: utils.EnvVarBool("NANIT_MQTT_CLEAN_SESSION", false),
+			Username:     utils.EnvVarStr("NANIT_MQTT_USERNAME", ""),
+			Password:     utils.EnvVarStr("NANIT_MQTT_PASSWORD", ""),
+			TopicPrefix:  utils.EnvVarStr("NANIT_MQTT_PREFIX", "nanit"),
+
+			TemperatureUnit: utils.EnvVarStr("NANIT_MQTT_TEMPERATURE_UNIT", mqtt.TemperatureUnit_Celsius),
+			Discovery:       utils.EnvVarBool("NANIT_MQTT_DISCOVERY_ENABLED", false),
+			DiscoveryPrefix: utils.EnvVarStr("NANIT_MQTT_DISCOVERY_PREFIX", "homeassistant"),
+			SoftwareVersion: getBuildInfo().String(),
+			Publish:         getMQTTPublishOpts(),
+			Commands:        utils.EnvVarBool("NANIT_MQTT_COMMANDS_ENABLED", false),
+
+			MaxReconnectInterval: utils.EnvVarDuration("NANIT_MQTT_MAX_RECONNECT_INTERVAL", time.Minute),
+		}
+
+		for _, eventType := range utils.EnvVarList("NANIT_MQTT_LAST_EVENT_TYPES", nil) {
+			opts.MQTT.LastEventTypes = append(opts.MQTT.LastEventTypes, notify.EventType(eventType))
+		}
+
+		if utils.EnvVarBool("NANIT_MQTT_SPARKPLUG_ENABLED", false) {
+			opts.MQTT.Sparkplug = &mqtt.SparkplugOpts{
+				GroupID:    utils.EnvVarStr("NANIT_MQTT_SPARKPLUG_GROUP_ID", "nanit"),
+				EdgeNodeID: utils.EnvVarStr("NANIT_MQTT_SPARKPLUG_EDGE_NODE_ID", "nanit"),
+			}
+		}
+	}
+
+	notifyOpts := &notify.Opts{
+		MinInterval:      utils.EnvVarDuration("NANIT_NOTIFY_MIN_INTERVAL", 10*time.Minute),
+		Aggregate:        utils.EnvVarBool("NANIT_NOTIFY_AGGREGATE", false),
+		RecoveryDebounce: utils.EnvVarDuration("NANIT_NOTIFY_RECOVERY_DEBOUNCE", time.Minute),
+		Thresholds: notify.Thresholds{
+			TemperatureMin: utils.EnvVarOptFloat("NANIT_NOTIFY_TEMPERATURE_MIN"),
+			TemperatureMax: utils.EnvVarOptFloat("NANIT_NOTIFY_TEMPERATURE_MAX"),
+			HumidityMin:    utils.EnvVarOptFloat("NANIT_NOTIFY_HUMIDITY_MIN"),
+			HumidityMax:    utils.EnvVarOptFloat("NANIT_NOTIFY_HUMIDITY_MAX"),
+		},
+	}
+
+	// Thresholds and debounce are shared with the MQTT last event
+	if opts.MQTT != nil {
+		opts.MQTT.EventThresholds = notifyOpts.Thresholds
+		opts.MQTT.EventRecoveryDebounce = notifyOpts.RecoveryDebounce
+	}
+
+	for _, eventType := range utils.EnvVarList("NANIT_NOTIFY_EVENTS", []string{string(notify.EventStreamDown)}) {
+		notifyOpts.Events = append(notifyOpts.Events, notify.EventType(eventType))
+	}
+
+	for eventType, minInterval := range utils.EnvVarDurationMap("NANIT_NOTIFY_MIN_INTERVAL_PER_EVENT") {
+		if notifyOpts.MinIntervalByEvent == nil {
+			notifyOpts.MinIntervalByEvent = make(map[notify.EventType]time.Duration)
 		}
+
+		notifyOpts.MinIntervalByEvent[notify.EventType(eventType)] = minInterval
+	}
+
+	for _, eventType := range utils.EnvVarList("NANIT_NOTIFY_SNAPSHOT_EVENTS", nil) {
+		notifyOpts.SnapshotEvents = append(notifyOpts.SnapshotEvents, notify.EventType(eventType))
+	}
+
+	var quietHoursErr error
+	if notifyOpts.QuietHours, quietHoursErr = notify.ParseQuietWindows(utils.EnvVarStr("NANIT_NOTIFY_QUIET_HOURS", "")); quietHoursErr != nil {
+		log.Fatal().Err(quietHoursErr).Msg("Invalid NANIT_NOTIFY_QUIET_HOURS")
+	}
+
+	if notifyOpts.QuietHoursByBaby, quietHoursErr = notify.ParseQuietWindowsByBaby(utils.EnvVarStr("NANIT_NOTIFY_QUIET_HOURS_PER_BABY", "")); quietHoursErr != nil {
+		log.Fatal().Err(quietHoursErr).Msg("Invalid NANIT_NOTIFY_QUIET_HOURS_PER_BABY")
+	}
+
+	if timezone := utils.EnvVarStr("NANIT_NOTIFY_TIMEZONE", ""); timezone != "" {
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid NANIT_NOTIFY_TIMEZONE")
+		}
+
+		notifyOpts.Timezone = location
+	}
+
+	// Quiet hours apply to the MQTT last event as well
+	if opts.MQTT != nil {
+		opts.MQTT.EventQuietHours = notifyOpts.GetQuietHours()
+	}
+
+	if utils.EnvVarBool("NANIT_NTFY_ENABLED", false) {
+		notifyOpts.Ntfy = &notify.NtfyOpts{
+			ServerURL: utils.EnvVarStr("NANIT_NTFY_SERVER_URL", "https://ntfy.sh"),
+			Topic:     utils.EnvVarReqStr("NANIT_NTFY_TOPIC"),
+			Token:     utils.EnvVarStr("NANIT_NTFY_TOKEN", ""),
+		}
+	}
+
+	if utils.EnvVarBool("NANIT_PUSHOVER_ENABLED", false) {
+		notifyOpts.Pushover = &notify.PushoverOpts{
+			AppToken: utils.EnvVarReqStr("NANIT_PUSHOVER_APP_TOKEN"),
+			UserKey:  utils.EnvVarReqStr("NANIT_PUSHOVER_USER_KEY"),
+		}
+	}
+
+	if utils.EnvVarBool("NANIT_TELEGRAM_ENABLED", false) {
+		notifyOpts.Telegram = &notify.TelegramOpts{
+			BotToken: utils.EnvVarReqStr("NANIT_TELEGRAM_BOT_TOKEN"),
+			ChatID:   utils.EnvVarReqStr("NANIT_TELEGRAM_CHAT_ID"),
+		}
+	}
+
+	if utils.EnvVarBool("NANIT_DISCORD_ENABLED", false) {
+		notifyOpts.Discord = &notify.DiscordOpts{
+			WebhookURL: utils.EnvVarReqStr("NANIT_DISCORD_WEBHOOK_URL"),
+		}
+	}
+
+	if notifyOpts.HasSinks() {
+		opts.Notifications = notifyOpts
+	}
+
+	if err := opts.Validate(); err != nil {
+		log.Fatal().Err(err).Msg("Invalid configuration")
+	}
+
+	if opts.Timelapse != nil {
+		ensureWritableDir(opts.Timelapse.Dir, "timelapse")
+	}
+
+	if opts.HomeKit != nil {
+		ensureWritableDir(opts.HomeKit.StorageDir, "HomeKit storage")
+	}
+
+	if opts.History != nil {
+		ensureWritableDir(opts.History.Dir, "history")
 	}
 
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt)
 
+	if *once {
+		runOnce(opts, *onceBabyUID, *onceOutput, interrupt)
+		return
+	}
+
+	// Log levels can be changed without restart by editing .env file and sending SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			log.Info().Msg("Received SIGHUP, reloading log levels")
+			reloadLogLevel()
+		}
+	}()
+
 	instance := app.NewApp(opts)
 
 	runner := utils.RunWithGracefulCancel(instance.Run)
 
-	<-interrupt
-	log.Warn().Msg("Received interrupt signal, terminating")
+	finishedC := make(chan struct{})
+	go func() {
+		runner.Wait()
+		close(finishedC)
+	}()
+
+	select {
+	case <-interrupt:
+		log.Warn().Msg("Received interrupt signal, terminating")
+	case <-finishedC:
+		// App has already cleaned up after itself
+		log.Fatal().Err(instance.FatalError()).Msg("Terminating, the process should be restarted by its supervisor")
+	}
 
 	waitForCleanup := make(chan struct{}, 1)
 
@@ -67,11 +344,70 @@ func main() {
 		close(waitForCleanup)
 	}()
 
+	var shutdownTimeoutC <-chan time.Time
+	if opts.ShutdownTimeout > 0 {
+		shutdownTimeoutC = time.After(opts.ShutdownTimeout)
+	}
+
 	select {
 	case <-interrupt:
 		log.Fatal().Msg("Received another interrupt signal, forcing termination without clean up")
+	case <-shutdownTimeoutC:
+		log.Fatal().Dur("timeout", opts.ShutdownTimeout).Msg("Clean up did not finish in time, forcing termination")
 	case <-waitForCleanup:
 		log.Info().Msg("Clean exit")
 		return
 	}
 }
+
+// getMQTTPublishOpts - reads NANIT_MQTT_{CATEGORY}_QOS and NANIT_MQTT_{CATEGORY}_RETAIN for every topic category
+func getMQTTPublishOpts() map[mqtt.TopicCategory]mqtt.PublishOpts {
+	publishOpts := make(map[mqtt.TopicCategory]mqtt.PublishOpts)
+	for _, category := range mqtt.TopicCategories {
+		defaults := mqtt.DefaultPublishOpts[category]
+		varPrefix := "NANIT_MQTT_" + strings.ToUpper(string(category))
+
+		publishOpts[category] = mqtt.PublishOpts{
+			QoS:      byte(utils.EnvVarInt(varPrefix+"_QOS", int(defaults.QoS))),
+			Retained: utils.EnvVarBool(varPrefix+"_RETAIN", defaults.Retained),
+		}
+	}
+
+	return publishOpts
+}
+
+func runOnce(opts app.Opts, babyUID string, outputFile string, interrupt chan os.Signal) {
+	// Snapshot mode does not need any of the integrations
+	opts.MQTT = nil
+	opts.Notifications = nil
+	opts.HomeKit = nil
+	opts.History = nil
+	opts.HTTPEnabled = false
+	opts.CamLogs = nil
+
+	absOutputFile, filePathErr := filepath.Abs(outputFile)
+	if filePathErr != nil {
+		log.Fatal().Str("path", outputFile).Err(filePathErr).Msg("Unable to retrieve absolute file path")
+	}
+
+	instance := app.NewApp(opts)
+
+	var err error
+	runner := utils.RunWithGracefulCancel(func(ctx utils.GracefulContext) {
+		err = instance.RunOnce(ctx, babyUID, absOutputFile)
+	})
+
+	go func() {
+		<-interrupt
+		log.Warn().Msg("Received interrupt signal, terminating")
+		runner.Cancel()
+	}()
+
+	runner.Wait()
+
+	if err != nil {
+		log.Fatal().Err(err).Msg("Unable to capture snapshot")
+	}
+
+	log.Info().Str("file", absOutputFile).Msg("Snapshot captured")
+}
@@ -1,14 +1,40 @@
 package main
 
-import "github.com/rs/zerolog/log"
+import (
+	"runtime"
+
+	"github.com/rs/zerolog/log"
+	"gitlab.com/adam.stanek/nanit/pkg/app"
+)
+
+// Version - Injected on CI (from CI_COMMIT_TAG)
+var Version = "dev"
 
 // GitCommit - Injected on CI (from CI_COMMIT_SHORT_SHA)
 var GitCommit string
 
+// BuildDate - Injected on CI (RFC 3339)
+var BuildDate string
+
+func getBuildInfo() app.BuildInfo {
+	return app.BuildInfo{
+		Version:   Version,
+		GitCommit: GitCommit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+}
+
 func logAppVersion() {
-	initMsg := log.Info()
-	if GitCommit != "" {
-		initMsg.Str("gitversion", GitCommit)
+	buildInfo := getBuildInfo()
+
+	initMsg := log.Info().Str("version", buildInfo.Version).Str("go_version", buildInfo.GoVersion)
+	if buildInfo.GitCommit != "" {
+		initMsg.Str("gitversion", buildInfo.GitCommit)
+	}
+
+	if buildInfo.BuildDate != "" {
+		initMsg.Str("build_date", buildInfo.BuildDate)
 	}
 
 	initMsg.Msg("Application started")
@@ -3,8 +3,11 @@ module gitlab.com/adam.stanek/nanit
 go 1.14
 
 require (
+	github.com/BurntSushi/toml v0.3.1
+	github.com/brutella/hc v1.2.5
 	github.com/eclipse/paho.mqtt.golang v1.3.0
 	github.com/golang/protobuf v1.4.3
+	github.com/gorilla/websocket v1.4.2
 	github.com/joho/godotenv v1.3.0
 	github.com/notedit/rtmp v0.0.2
 	github.com/rs/zerolog v1.20.0
@@ -15,4 +18,5 @@ require (
 	github.com/yutopp/go-amf0 v0.0.0-20180803120851-48851794bb1f // indirect
 	github.com/yutopp/go-flv v0.2.0
 	google.golang.org/protobuf v1.25.0
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
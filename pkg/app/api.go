@@ -0,0 +1,525 @@
+package app
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+	"gitlab.com/adam.stanek/nanit/pkg/client"
+)
+
+type websocketStatusPayload struct {
+	IsConnected     bool       `json:"is_connected"`
+	UptimeSeconds   float64    `json:"uptime_seconds"`
+	ConnectedSince  *time.Time `json:"connected_since"`
+	LastReconnectAt *time.Time `json:"last_reconnect_at"`
+	ReconnectCount  int        `json:"reconnect_count"`
+}
+
+type sensorDataStatusPayload struct {
+	ReceivedAt              time.Time  `json:"received_at"`
+	CameraTime              *time.Time `json:"camera_time"`
+	CameraClockDriftSeconds *float64   `json:"camera_clock_drift_seconds"`
+}
+
+type babyStatusPayload struct {
+	UID        string                   `json:"uid"`
+	Name       string                   `json:"name"`
+	CameraUID  string                   `json:"camera_uid"`
+	State      map[string]interface{}   `json:"state"`
+	Websocket  *websocketStatusPayload  `json:"websocket,omitempty"`
+	SensorData *sensorDataStatusPayload `json:"sensor_data,omitempty"`
+
+	// StreamState - unknown, alive or unhealthy
+	StreamState string `json:"stream_state"`
+
+	Capabilities baby.Capabilities `json:"capabilities"`
+
+	// Present only if there are multiple cameras paired with the baby (primary one included)
+	Cameras []cameraStatusPayload `json:"cameras,omitempty"`
+}
+
+type cameraStatusPayload struct {
+	CameraUID  string                   `json:"camera_uid"`
+	StateKey   string                   `json:"state_key"`
+	State      map[string]interface{}   `json:"state"`
+	Websocket  *websocketStatusPayload  `json:"websocket,omitempty"`
+	SensorData *sensorDataStatusPayload `json:"sensor_data,omitempty"`
+
+	// StreamState - unknown, alive or unhealthy
+	StreamState string `json:"stream_state"`
+
+	Capabilities baby.Capabilities `json:"capabilities"`
+}
+
+// reconnectAwaitTimeout - how long to wait for the new connection before responding
+const reconnectAwaitTimeout = 10 * time.Second
+
+func (app *App) registerAPIHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/api/babies/", app.handleAPIBaby)
+	mux.HandleFunc("/api/overview", app.handleAPIOverview)
+	mux.HandleFunc("/api/sensors/", app.handleAPISensors)
+	mux.HandleFunc("/api/auth/reauthorize", app.handleAPIReauthorize)
+	mux.HandleFunc("/api/notifications/cooldowns", app.handleAPINotificationCooldowns)
+	mux.HandleFunc("/healthz", app.handleHealthz)
+	mux.HandleFunc("/metrics", app.handleMetrics)
+	mux.HandleFunc("/version", app.handleVersion)
+}
+
+// uidRegexp - format of baby UIDs as issued by Nanit
+var uidRegexp = regexp.MustCompile("^[A-Za-z0-9_-]{1,64}$")
+
+// /api/babies/{uid}[/{action}]
+func (app *App) handleAPIBaby(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.SplitN(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/babies/"), "/"), "/", 2)
+
+	if !uidRegexp.MatchString(pathParts[0]) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid baby UID"})
+		return
+	}
+
+	babyInfo, found := app.findBaby(pathParts[0])
+	if !found {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Unknown baby"})
+		return
+	}
+
+	action := ""
+	if len(pathParts) > 1 {
+		action = pathParts[1]
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, app.getBabyStatus(babyInfo))
+	case action == "preview" && r.Method == http.MethodGet:
+		app.handleAPIBabyPreview(w, babyInfo)
+	case action == "snapshot" && r.Method == http.MethodGet:
+		app.handleAPIBabySnapshot(w, r, babyInfo)
+	case action == "camlogs" && r.Method == http.MethodGet:
+		app.handleAPIBabyCamLogs(w, babyInfo)
+	case action == "history" && r.Method == http.MethodGet:
+		app.handleAPIBabyHistory(w, r, babyInfo)
+	case action == "rewind" && r.Method == http.MethodGet:
+		app.handleAPIBabyRewind(w, r, babyInfo)
+	case action == "stream" && r.Method == http.MethodGet:
+		app.handleAPIBabyStream(w, babyInfo)
+	case action == "reconnect" && r.Method == http.MethodPost:
+		app.withAdminAuth(w, r, func() { app.handleAPIBabyReconnect(w, r, babyInfo) })
+	case action == "night_light" && r.Method == http.MethodPost:
+		app.withAdminAuth(w, r, func() { app.handleAPIBabyNightLight(w, r, babyInfo) })
+	case action == "" || action == "preview" || action == "snapshot" || action == "camlogs" || action == "history" || action == "rewind" || action == "stream" || action == "reconnect" || action == "night_light":
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Unknown action"})
+	}
+}
+
+// handleMQTTCommand - executes command received on {prefix}/babies/{uid}/command
+// Note: reconnect also stops and re-requests the stream of the cam
+// Note: reauthorize is applied to all the babies, their websockets reconnect with the new token
+func (app *App) handleMQTTCommand(babyUID string, command string) error {
+	switch command {
+	case "night_light_on", "night_light_off":
+		return app.setNightLight(babyUID, command == "night_light_on")
+	case "reauthorize":
+		_, err := app.reauthorize()
+		return err
+	case "reconnect":
+		ws := app.getWebsocketManager(babyUID)
+		if ws == nil {
+			return errors.New("no websocket connection manager for baby")
+		} else if !ws.Reconnect() {
+			return errors.New("websocket is not connected, reconnect is already pending")
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q (supported commands: reconnect, reauthorize, night_light_on, night_light_off)", command)
+	}
+}
+
+// POST /api/babies/{uid}/reconnect[?wait=true]
+// Responds right away with 202, with wait=true the response is held until the new connection is established (up to reconnectAwaitTimeout)
+func (app *App) handleAPIBabyReconnect(w http.ResponseWriter, r *http.Request, babyInfo baby.Baby) {
+	wait := false
+	if value := r.URL.Query().Get("wait"); value != "" {
+		var err error
+		if wait, err = strconv.ParseBool(value); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid wait flag, expected true or false"})
+			return
+		}
+	}
+
+	ws := app.getWebsocketManager(babyInfo.UID)
+	if ws == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "No websocket connection manager for baby"})
+		return
+	}
+
+	// Read before the reconnect, it might finish before we get to await it
+	reconnectCount := ws.GetStats().ReconnectCount
+	if !ws.Reconnect() {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "Websocket is not connected, reconnect is already pending"})
+		return
+	}
+
+	if !wait {
+		writeJSON(w, http.StatusAccepted, app.getBabyStatus(babyInfo))
+		return
+	}
+
+	// Await the new connection
+	deadline := time.Now().Add(reconnectAwaitTimeout)
+	for time.Now().Before(deadline) {
+		if stats := ws.GetStats(); stats.IsConnected && stats.ReconnectCount > reconnectCount {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	writeJSON(w, http.StatusOK, app.getBabyStatus(babyInfo))
+}
+
+// POST /api/babies/{uid}/night_light?on={true|false}
+func (app *App) handleAPIBabyNightLight(w http.ResponseWriter, r *http.Request, babyInfo baby.Baby) {
+	on, err := strconv.ParseBool(r.URL.Query().Get("on"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid on parameter, expected true or false"})
+		return
+	}
+
+	if err := app.setNightLight(babyInfo.UID, on); err != nil {
+		status := http.StatusServiceUnavailable
+		if errors.Is(err, errNightLightUnsupported) {
+			status = http.StatusNotImplemented
+		}
+
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]bool{"night_light": on})
+}
+
+// GET /api/babies/{uid}/preview
+func (app *App) handleAPIBabyPreview(w http.ResponseWriter, babyInfo baby.Baby) {
+	preview, found := app.getPreview(babyInfo.UID)
+	if !found {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "No preview available"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Last-Modified", preview.CapturedAt.UTC().Format(http.TimeFormat))
+	w.Write(preview.Data)
+}
+
+// GET /api/babies/{uid}/snapshot[?width={px}&height={px}]
+func (app *App) handleAPIBabySnapshot(w http.ResponseWriter, r *http.Request, babyInfo baby.Baby) {
+	if app.Opts.RTMP == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Snapshots require RTMP server to be enabled"})
+		return
+	}
+
+	size, err := parseSnapshotSize(r.URL.Query(), app.Opts.HTTPSnapshotMaxWidth, app.Opts.HTTPSnapshotMaxHeight)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	data, capturedAt, err := app.snapshotCache.get(babyInfo.UID+"/"+size.String(), func() ([]byte, error) {
+		return app.captureHTTPSnapshot(babyInfo.UID, size)
+	})
+
+	if err != nil {
+		log.Warn().Str("baby_uid", babyInfo.UID).Err(err).Msg("Unable to capture snapshot")
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Last-Modified", capturedAt.UTC().Format(http.TimeFormat))
+	w.Write(data)
+}
+
+// GET /api/babies/{uid}/camlogs
+func (app *App) handleAPIBabyCamLogs(w http.ResponseWriter, babyInfo baby.Baby) {
+	logs, found := app.getCamLogs(babyInfo.UID)
+	if !found {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "No cam logs received yet"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, logs)
+}
+
+// GET /api/babies/{uid}/history[?key={key}&from={RFC3339}&to={RFC3339}]
+// Returns records of the last 24 hours by default
+func (app *App) handleAPIBabyHistory(w http.ResponseWriter, r *http.Request, babyInfo baby.Baby) {
+	if app.History == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "History is not enabled"})
+		return
+	}
+
+	query := r.URL.Query()
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
+
+	for name, value := range map[string]*time.Time{"from": &from, "to": &to} {
+		if str := query.Get(name); str != "" {
+			parsed, err := time.Parse(time.RFC3339, str)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid %v, expected RFC 3339 time (ie. 2006-01-02T15:04:05Z)", name)})
+				return
+			}
+
+			*value = parsed
+		}
+	}
+
+	if from.After(to) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid time range, from is after to"})
+		return
+	}
+
+	records, err := app.History.Query(babyInfo.UID, query.Get("key"), from, to)
+	if err != nil {
+		log.Error().Str("baby_uid", babyInfo.UID).Err(err).Msg("Unable to query history")
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Unable to query history"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"records": records})
+}
+
+// withAdminAuth - runs handler only if request carries valid admin token (admin endpoints are disabled without token)
+func (app *App) withAdminAuth(w http.ResponseWriter, r *http.Request, handler func()) {
+	if app.Opts.HTTPAdminToken == "" {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "Admin endpoints are disabled"})
+		return
+	}
+
+	expected := "Bearer " + app.Opts.HTTPAdminToken
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Invalid admin token"})
+		return
+	}
+
+	handler()
+}
+
+// GET /metrics (Prometheus text format)
+func (app *App) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	type sample struct {
+		babyUID string
+		value   float64
+	}
+
+	var connected, uptime, reconnects, lastReconnect, sensorDataReceived, cameraClockDrift []sample
+
+	for _, babyInfo := range app.SessionStore.Session.Babies {
+		for _, cameraUID := range babyInfo.GetCameraUIDs() {
+			stateKey := babyInfo.GetStateKey(cameraUID)
+
+			if times, ok := app.getSensorDataTimes(stateKey); ok {
+				sensorDataReceived = append(sensorDataReceived, sample{stateKey, float64(times.ReceivedAt.Unix())})
+				if !times.CameraTime.IsZero() {
+					cameraClockDrift = append(cameraClockDrift, sample{stateKey, times.Drift.Seconds()})
+				}
+			}
+
+			ws := app.getWebsocketManager(stateKey)
+			if ws == nil {
+				continue
+			}
+
+			stats := ws.GetStats()
+
+			isConnected := 0.0
+			if stats.IsConnected {
+				isConnected = 1
+			}
+
+			connected = append(connected, sample{stateKey, isConnected})
+			uptime = append(uptime, sample{stateKey, stats.Uptime().Seconds()})
+			reconnects = append(reconnects, sample{stateKey, float64(stats.ReconnectCount)})
+
+			if !stats.LastReconnectAt.IsZero() {
+				lastReconnect = append(lastReconnect, sample{stateKey, float64(stats.LastReconnectAt.Unix())})
+			}
+		}
+	}
+
+	writeMetric := func(name string, metricType string, help string, samples []sample) {
+		fmt.Fprintf(w, "# HELP %v %v\n", name, help)
+		fmt.Fprintf(w, "# TYPE %v %v\n", name, metricType)
+		for _, s := range samples {
+			fmt.Fprintf(w, "%v{baby_uid=%q} %v\n", name, s.babyUID, s.value)
+		}
+	}
+
+	isPaused := 0
+	if app.isSensorProcessingPaused() {
+		isPaused = 1
+	}
+
+	fmt.Fprintf(w, "# HELP nanit_sensor_processing_paused Whether the received sensor data are dropped (paused by admin)\n")
+	fmt.Fprintf(w, "# TYPE nanit_sensor_processing_paused gauge\n")
+	fmt.Fprintf(w, "nanit_sensor_processing_paused %v\n", isPaused)
+
+	if app.RTMPServer != nil {
+		isRunning := 0
+		if app.RTMPServer.IsRunning() {
+			isRunning = 1
+		}
+
+		fmt.Fprintf(w, "# HELP nanit_rtmp_server_up Whether the local RTMP server is accepting connections\n")
+		fmt.Fprintf(w, "# TYPE nanit_rtmp_server_up gauge\n")
+		fmt.Fprintf(w, "nanit_rtmp_server_up %v\n", isRunning)
+		if done, err := app.getRTMPPublicAddrCheck(); done {
+			isReachable := 0
+			if err == nil {
+				isReachable = 1
+			}
+
+			fmt.Fprintf(w, "# HELP nanit_rtmp_public_addr_reachable Whether the RTMP server was reachable under the public address on startup\n")
+			fmt.Fprintf(w, "# TYPE nanit_rtmp_public_addr_reachable gauge\n")
+			fmt.Fprintf(w, "nanit_rtmp_public_addr_reachable %v\n", isReachable)
+		}
+	}
+
+	writeMetric("nanit_websocket_connected", "gauge", "Whether the websocket connection to the cam is established", connected)
+	writeMetric("nanit_websocket_uptime_seconds", "gauge", "Duration of the current websocket connection", uptime)
+	writeMetric("nanit_websocket_reconnects_total", "counter", "Number of websocket reconnections since the application start", reconnects)
+	writeMetric("nanit_websocket_last_reconnect_timestamp_seconds", "gauge", "Unix time of the last websocket reconnection", lastReconnect)
+	writeMetric("nanit_sensor_data_last_received_timestamp_seconds", "gauge", "Unix time (local clock) of the last received sensor data", sensorDataReceived)
+	writeMetric("nanit_camera_clock_drift_seconds", "gauge", "Difference between the newest timestamp reported by the cam and the local time of receipt", cameraClockDrift)
+}
+
+func (app *App) findBaby(babyUID string) (baby.Baby, bool) {
+	for _, babyInfo := range app.SessionStore.Session.Babies {
+		if babyInfo.UID == babyUID {
+			return babyInfo, true
+		}
+	}
+
+	return baby.Baby{}, false
+}
+
+func (app *App) getBabyStatus(babyInfo baby.Baby) babyStatusPayload {
+	// Read once, so that the payload reflects a single snapshot of the state
+	state := app.BabyStateManager.GetBabyState(babyInfo.UID)
+
+	payload := babyStatusPayload{
+		UID:       babyInfo.UID,
+		Name:      babyInfo.Name,
+		CameraUID: babyInfo.CameraUID,
+		State:     state.AsMap(false),
+
+		StreamState:  getStreamStateName(state.GetStreamState()),
+		Capabilities: app.getCapabilities(babyInfo.UID),
+	}
+
+	if ws := app.getWebsocketManager(babyInfo.UID); ws != nil {
+		payload.Websocket = newWebsocketStatusPayload(ws.GetStats())
+	}
+
+	if times, ok := app.getSensorDataTimes(babyInfo.UID); ok {
+		payload.SensorData = newSensorDataStatusPayload(times)
+	}
+
+	if cameraUIDs := babyInfo.GetCameraUIDs(); len(cameraUIDs) > 1 {
+		for _, cameraUID := range cameraUIDs {
+			payload.Cameras = append(payload.Cameras, app.getCameraStatus(babyInfo, cameraUID))
+		}
+	}
+
+	return payload
+}
+
+func (app *App) getCameraStatus(babyInfo baby.Baby, cameraUID string) cameraStatusPayload {
+	stateKey := babyInfo.GetStateKey(cameraUID)
+	state := app.BabyStateManager.GetBabyState(stateKey)
+
+	payload := cameraStatusPayload{
+		CameraUID: cameraUID,
+		StateKey:  stateKey,
+		State:     state.AsMap(false),
+
+		StreamState:  getStreamStateName(state.GetStreamState()),
+		Capabilities: app.getCapabilities(stateKey),
+	}
+
+	if ws := app.getWebsocketManager(stateKey); ws != nil {
+		payload.Websocket = newWebsocketStatusPayload(ws.GetStats())
+	}
+
+	if times, ok := app.getSensorDataTimes(stateKey); ok {
+		payload.SensorData = newSensorDataStatusPayload(times)
+	}
+
+	return payload
+}
+
+func getStreamStateName(streamState baby.StreamState) string {
+	switch streamState {
+	case baby.StreamState_Alive:
+		return "alive"
+	case baby.StreamState_Unhealthy:
+		return "unhealthy"
+	default:
+		return "unknown"
+	}
+}
+
+func newWebsocketStatusPayload(stats client.WebsocketStats) *websocketStatusPayload {
+	payload := &websocketStatusPayload{
+		IsConnected:    stats.IsConnected,
+		UptimeSeconds:  stats.Uptime().Seconds(),
+		ReconnectCount: stats.ReconnectCount,
+	}
+
+	if !stats.ConnectedSince.IsZero() {
+		payload.ConnectedSince = &stats.ConnectedSince
+	}
+
+	if !stats.LastReconnectAt.IsZero() {
+		payload.LastReconnectAt = &stats.LastReconnectAt
+	}
+
+	return payload
+}
+
+func newSensorDataStatusPayload(times sensorDataTimes) *sensorDataStatusPayload {
+	payload := &sensorDataStatusPayload{ReceivedAt: times.ReceivedAt}
+
+	if !times.CameraTime.IsZero() {
+		drift := times.Drift.Seconds()
+		payload.CameraTime = &times.CameraTime
+		payload.CameraClockDriftSeconds = &drift
+	}
+
+	return payload
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Error().Err(err).Msg("Unable to encode JSON response")
+	}
+}
@@ -1,12 +1,19 @@
 package app
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"gitlab.com/adam.stanek/nanit/pkg/baby"
 	"gitlab.com/adam.stanek/nanit/pkg/client"
+	"gitlab.com/adam.stanek/nanit/pkg/history"
+	"gitlab.com/adam.stanek/nanit/pkg/homekit"
 	"gitlab.com/adam.stanek/nanit/pkg/mqtt"
+	"gitlab.com/adam.stanek/nanit/pkg/notify"
 	"gitlab.com/adam.stanek/nanit/pkg/rtmpserver"
 	"gitlab.com/adam.stanek/nanit/pkg/session"
 	"gitlab.com/adam.stanek/nanit/pkg/utils"
@@ -19,6 +26,42 @@ type App struct {
 	BabyStateManager *baby.StateManager
 	RestClient       *client.NanitClient
 	MQTTConnection   *mqtt.Connection
+	RTMPServer       *rtmpserver.Server
+	Notifier         *notify.Notifier
+	HomeKitBridge    *homekit.Bridge
+	History          *history.Store
+
+	websocketManagersMu sync.RWMutex
+	websocketManagers   map[string]*client.WebsocketConnectionManager
+	websocketConns      map[string]*client.WebsocketConnection
+
+	// sharedCameraOwners - state keys of the cameras shared with another baby => state key holding the connection
+	sharedCameraOwners map[string]string
+
+	previewsMu sync.RWMutex
+	previews   map[string]previewImage
+
+	snapshotCache *snapshotCache
+
+	sensorDataTimesMu sync.RWMutex
+	sensorDataTimes   map[string]sensorDataTimes
+
+	startedAt time.Time
+
+	// sensorProcessingPaused - see setSensorProcessingPaused (accessed atomically)
+	sensorProcessingPaused int32
+
+	// fatalC - fatal condition which should terminate the whole app (see failFatal)
+	fatalC   chan error
+	fatalErr error
+
+	camLogsMu        sync.Mutex
+	camLogsRequested map[string]bool
+	camLogs          map[string]camLogs
+
+	rtmpCheckMu   sync.RWMutex
+	rtmpCheckDone bool
+	rtmpCheckErr  error
 }
 
 // NewApp - constructor
@@ -33,11 +76,46 @@ func NewApp(opts Opts) *App {
 			Email:        opts.NanitCredentials.Email,
 			Password:     opts.NanitCredentials.Password,
 			SessionStore: sessionStore,
+
+			TokenLifetime:      opts.NanitCredentials.TokenLifetime,
+			TokenRefreshMargin: opts.NanitCredentials.TokenRefreshMargin,
+			ClockSkewTolerance: opts.NanitCredentials.ClockSkewTolerance,
+			ProxyURL:           opts.NanitCredentials.ProxyURL,
 		},
+		websocketManagers: make(map[string]*client.WebsocketConnectionManager),
+		websocketConns:    make(map[string]*client.WebsocketConnection),
+		previews:          make(map[string]previewImage),
+		snapshotCache:     newSnapshotCache(opts.HTTPSnapshotCacheTTL),
+		sensorDataTimes:   make(map[string]sensorDataTimes),
+		startedAt:         time.Now(),
+		fatalC:            make(chan error, 1),
+		camLogsRequested:  make(map[string]bool),
+		camLogs:           make(map[string]camLogs),
 	}
 
 	if opts.MQTT != nil {
 		instance.MQTTConnection = mqtt.NewConnection(*opts.MQTT)
+		instance.MQTTConnection.CommandHandler = instance.handleMQTTCommand
+		instance.MQTTConnection.CapabilitiesProvider = instance.getCapabilities
+	}
+
+	if opts.Notifications != nil && opts.Notifications.HasSinks() {
+		instance.Notifier = notify.NewNotifier(*opts.Notifications)
+		if opts.RTMP != nil {
+			instance.Notifier.SnapshotProvider = instance.captureNotificationSnapshot
+		}
+	}
+
+	if opts.HomeKit != nil {
+		instance.HomeKitBridge = homekit.NewBridge(*opts.HomeKit)
+	}
+
+	if opts.History != nil {
+		instance.History = history.NewStore(*opts.History)
+	}
+
+	if opts.RTMP != nil {
+		instance.RTMPServer = rtmpserver.NewServer(opts.RTMP.ListenAddr, opts.RTMP.Probe, instance.BabyStateManager, instance.isKnownStreamKey)
 	}
 
 	return instance
@@ -49,60 +127,259 @@ func (app *App) Run(ctx utils.GracefulContext) {
 	app.RestClient.MaybeAuthorize(false)
 
 	// Fetches babies info if they are not present in session
-	app.RestClient.EnsureBabies()
+	if len(app.RestClient.EnsureBabies()) == 0 && !app.waitForBabies(ctx) {
+		return
+	}
+
+	if app.Opts.SharedCameras != SharedCameras_Separate {
+		app.sharedCameraOwners = getSharedCameraOwners(app.SessionStore.Session.Babies)
+	}
+
+	// Components are not run as children of ctx so that they can be stopped in order (see shutdown)
+	var consumers, producers, streamServers []utils.GracefulRunner
 
 	// RTMP
-	if app.Opts.RTMP != nil {
-		go rtmpserver.StartRTMPServer(app.Opts.RTMP.ListenAddr, app.BabyStateManager)
+	if app.RTMPServer != nil {
+		streamServers = append(streamServers, utils.RunWithGracefulCancel(func(childCtx utils.GracefulContext) {
+			app.RTMPServer.Run(childCtx)
+		}))
+		producers = append(producers, utils.RunWithGracefulCancel(func(childCtx utils.GracefulContext) {
+			app.runRTMPPublicAddrCheck(childCtx)
+		}))
 	}
 
 	// MQTT
 	if app.MQTTConnection != nil {
-		ctx.RunAsChild(func(childCtx utils.GracefulContext) {
-			app.MQTTConnection.Run(app.BabyStateManager, childCtx)
-		})
+		consumers = append(consumers, utils.RunWithGracefulCancel(func(childCtx utils.GracefulContext) {
+			if err := app.MQTTConnection.Run(app.BabyStateManager, app.SessionStore.Session.Babies, childCtx); err != nil {
+				app.failFatal(err)
+			}
+		}))
+	}
+
+	// Notifications
+	if app.Notifier != nil {
+		consumers = append(consumers, utils.RunWithGracefulCancel(func(childCtx utils.GracefulContext) {
+			app.Notifier.Run(app.BabyStateManager, app.SessionStore.Session.Babies, childCtx)
+		}))
+	}
+
+	// HomeKit
+	if app.HomeKitBridge != nil {
+		consumers = append(consumers, utils.RunWithGracefulCancel(func(childCtx utils.GracefulContext) {
+			app.HomeKitBridge.Run(app.BabyStateManager, app.SessionStore.Session.Babies, childCtx)
+		}))
+	}
+
+	// History
+	if app.History != nil {
+		consumers = append(consumers, utils.RunWithGracefulCancel(func(childCtx utils.GracefulContext) {
+			app.History.Run(app.BabyStateManager, childCtx)
+		}))
+	}
+
+	// Presence derived from the alerts
+	if app.Opts.Presence != nil {
+		consumers = append(consumers, utils.RunWithGracefulCancel(func(childCtx utils.GracefulContext) {
+			app.runPresence(childCtx)
+		}))
+	}
+
+	// Structured state transition events
+	if app.Opts.LogStateTransitions {
+		consumers = append(consumers, utils.RunWithGracefulCancel(func(childCtx utils.GracefulContext) {
+			app.runStateEventsLog(childCtx)
+		}))
 	}
 
 	// Start reading the data from the stream
 	for _, babyInfo := range app.SessionStore.Session.Babies {
 		_babyInfo := babyInfo
-		ctx.RunAsChild(func(childCtx utils.GracefulContext) {
+		producers = append(producers, utils.RunWithGracefulCancel(func(childCtx utils.GracefulContext) {
 			app.handleBaby(_babyInfo, childCtx)
-		})
+		}))
+	}
+
+	// Reconnect after the system resumes from sleep
+	if app.Opts.ResumeThreshold > 0 {
+		producers = append(producers, utils.RunWithGracefulCancel(func(childCtx utils.GracefulContext) {
+			app.runResumeWatcher(childCtx)
+		}))
 	}
 
 	// Start serving content over HTTP
+	var httpServer *http.Server
 	if app.Opts.HTTPEnabled {
-		go serve(app.SessionStore.Session.Babies, app.Opts.DataDirectories)
+		httpServer = app.serve()
 	}
 
-	<-ctx.Done()
+	select {
+	case <-ctx.Done():
+	case err := <-app.fatalC:
+		log.Error().Err(err).Msg("Fatal condition, shutting down")
+		app.fatalErr = err
+	}
+
+	app.shutdown(httpServer, producers, streamServers, consumers)
+}
+
+// failFatal - shuts the app down, the process is then expected to exit with an error (and its supervisor to restart it)
+func (app *App) failFatal(err error) {
+	select {
+	case app.fatalC <- err:
+	default:
+	}
+}
+
+// FatalError - returns the fatal condition which terminated Run (nil if it was cancelled)
+// Note: only valid after Run returned
+func (app *App) FatalError() error {
+	return app.fatalErr
+}
+
+// shutdown - stops the components in order so that the final states still reach the integrations
+// 1. HTTP server stops accepting requests and finishes the pending ones
+// 2. websockets ask the cams to stop streaming, timelapse/preview stop capturing
+// 3. RTMP server drops the (now idle) publishers and subscribers
+// 4. MQTT, notifications and HomeKit publish the offline states and disconnect, history records them
+// Note: overall deadline is enforced by the caller, HTTP server is only given ShutdownTimeout to drain
+func (app *App) shutdown(httpServer *http.Server, producers []utils.GracefulRunner, streamServers []utils.GracefulRunner, consumers []utils.GracefulRunner) {
+	if httpServer != nil {
+		log.Debug().Msg("Shutting down HTTP server")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		if app.Opts.ShutdownTimeout > 0 {
+			ctx, cancel = context.WithTimeout(context.Background(), app.Opts.ShutdownTimeout)
+		}
+
+		if err := httpServer.Shutdown(ctx); err != nil {
+			log.Warn().Err(err).Msg("HTTP server did not shut down cleanly")
+		}
+
+		cancel()
+	}
+
+	phases := []struct {
+		name    string
+		runners []utils.GracefulRunner
+	}{
+		{"websockets", producers},
+		{"stream server", streamServers},
+		{"integrations", consumers},
+	}
+
+	for _, phase := range phases {
+		if len(phase.runners) == 0 {
+			continue
+		}
+
+		log.Debug().Str("phase", phase.name).Msg("Shutting down")
+
+		var wg sync.WaitGroup
+		for _, runner := range phase.runners {
+			wg.Add(1)
+			go func(runner utils.GracefulRunner) {
+				runner.Cancel()
+				wg.Done()
+			}(runner)
+		}
+
+		wg.Wait()
+	}
 }
 
 func (app *App) handleBaby(baby baby.Baby, ctx utils.GracefulContext) {
 	if app.Opts.RTMP != nil || app.MQTTConnection != nil {
-		// Websocket connection
-		ws := client.NewWebsocketConnectionManager(baby.UID, baby.CameraUID, app.SessionStore.Session, app.RestClient, app.BabyStateManager)
+		// Websocket connection + stream for every camera of the baby
+		for _, cameraUID := range baby.GetCameraUIDs() {
+			stateKey := baby.GetStateKey(cameraUID)
+			if app.getStreamKey(stateKey) != stateKey {
+				ctx.RunAsChild(func(childCtx utils.GracefulContext) {
+					app.followSharedCamera(stateKey, childCtx)
+				})
+			} else {
+				app.handleCamera(stateKey, cameraUID, ctx)
+			}
+		}
+	}
+
+	<-ctx.Done()
+}
+
+// handleCamera - state of the camera is tracked under stateKey (baby UID for the primary camera)
+func (app *App) handleCamera(stateKey string, cameraUID string, ctx utils.GracefulContext) {
+	ws := client.NewWebsocketConnectionManager(stateKey, cameraUID, app.SessionStore, app.RestClient, app.BabyStateManager)
+	ws.MaxFailures = app.Opts.WebsocketMaxFailures
+	ws.ConnectTimeout = app.Opts.WebsocketConnectTimeout
 
-		ws.WithReadyConnection(func(conn *client.WebsocketConnection, childCtx utils.GracefulContext) {
-			app.runWebsocket(baby.UID, conn, childCtx)
+	app.websocketManagersMu.Lock()
+	app.websocketManagers[stateKey] = ws
+	app.websocketManagersMu.Unlock()
+
+	ws.WithReadyConnection(func(conn *client.WebsocketConnection, childCtx utils.GracefulContext) {
+		app.runWebsocket(stateKey, conn, childCtx)
+	})
+
+	ctx.RunAsChild(func(childCtx utils.GracefulContext) {
+		if err := ws.RunWithinContext(childCtx); err != nil {
+			app.failFatal(fmt.Errorf("websocket of %v: %w", stateKey, err))
+		}
+	})
+
+	if app.Opts.Timelapse != nil && app.Opts.RTMP != nil {
+		ctx.RunAsChild(func(childCtx utils.GracefulContext) {
+			app.runTimelapse(stateKey, childCtx)
 		})
+	}
 
+	if app.Opts.Preview != nil && app.Opts.RTMP != nil {
 		ctx.RunAsChild(func(childCtx utils.GracefulContext) {
-			ws.RunWithinContext(childCtx)
+			app.runPreview(stateKey, childCtx)
 		})
 	}
 
-	<-ctx.Done()
+	if app.Opts.Rewind != nil && app.Opts.RTMP != nil {
+		ctx.RunAsChild(func(childCtx utils.GracefulContext) {
+			app.runRewind(stateKey, childCtx)
+		})
+	}
 }
 
 func (app *App) runWebsocket(babyUID string, conn *client.WebsocketConnection, childCtx utils.GracefulContext) {
+	sensorDataReceivedC := make(chan struct{}, 1)
+	notifySensorDataReceived := func() {
+		select {
+		case sensorDataReceivedC <- struct{}{}:
+		default:
+		}
+	}
+
+	// Sensor data are processed asynchronously, so that the message handler does not wait for the state downstreams
+	sensorQueue := newSensorQueue(babyUID)
+	childCtx.RunAsChild(func(queueCtx utils.GracefulContext) {
+		sensorQueue.run(queueCtx)
+	})
+
 	// Reading sensor data
 	conn.RegisterMessageHandler(func(m *client.Message, conn *client.WebsocketConnection) {
 		// Sensor request initiated by us on start (or some other client, we don't care)
 		if *m.Type == client.Message_RESPONSE && m.Response != nil {
 			if *m.Response.RequestType == client.RequestType_GET_SENSOR_DATA && len(m.Response.SensorData) > 0 {
-				processSensorData(babyUID, m.Response.SensorData, app.BabyStateManager)
+				// Dropped while paused, but the readings are not considered stale
+				if app.isSensorProcessingPaused() {
+					notifySensorDataReceived()
+					return
+				}
+
+				receivedAt := time.Now()
+				sensorData := m.Response.SensorData
+				sensorQueue.push(func() {
+					processSensorData(babyUID, sensorData, app.Opts.Sensors, app.BabyStateManager)
+					app.updateCapabilities(babyUID, getSensorCapabilities(sensorData))
+					app.recordSensorDataTimes(babyUID, sensorData, receivedAt)
+				})
+
+				notifySensorDataReceived()
 			}
 		} else
 
@@ -110,46 +387,75 @@ func (app *App) runWebsocket(babyUID string, conn *client.WebsocketConnection, c
 		// Note: it sends the updates periodically on its own + whenever some significant change occurs
 		if *m.Type == client.Message_REQUEST && m.Request != nil {
 			if *m.Request.Type == client.RequestType_PUT_SENSOR_DATA && len(m.Request.SensorData_) > 0 {
-				processSensorData(babyUID, m.Request.SensorData_, app.BabyStateManager)
+				if app.isSensorProcessingPaused() {
+					notifySensorDataReceived()
+					return
+				}
+
+				receivedAt := time.Now()
+				sensorData := m.Request.SensorData_
+				sensorQueue.push(func() {
+					processSensorData(babyUID, sensorData, app.Opts.Sensors, app.BabyStateManager)
+					processSensorAlerts(babyUID, sensorData, receivedAt, app.BabyStateManager)
+					app.updateCapabilities(babyUID, getSensorCapabilities(sensorData))
+					app.recordSensorDataTimes(babyUID, sensorData, receivedAt)
+				})
+
+				notifySensorDataReceived()
+			} else if *m.Request.Type == client.RequestType_PUT_CONTROL && m.Request.Control != nil {
+				// Night light switched by other client (ie. mobile app)
+				processControl(babyUID, m.Request.Control, app.BabyStateManager)
 			}
 		}
 	})
 
 	// Ask for sensor data (initial request)
-	conn.SendRequest(client.RequestType_GET_SENSOR_DATA, &client.Request{
-		GetSensorData: &client.GetSensorData{
-			All: utils.ConstRefBool(true),
-		},
+	requestSensorData(conn)
+
+	// Controls are sent over the current connection
+	app.websocketManagersMu.Lock()
+	app.websocketConns[babyUID] = conn
+	app.websocketManagersMu.Unlock()
+
+	defer func() {
+		app.websocketManagersMu.Lock()
+		if app.websocketConns[babyUID] == conn {
+			delete(app.websocketConns, babyUID)
+		}
+		app.websocketManagersMu.Unlock()
+	}()
+
+	// Periodic refresh and staleness detection
+	childCtx.RunAsChild(func(watchCtx utils.GracefulContext) {
+		watchSensorData(babyUID, app.Opts.Sensors, sensorDataReceivedC, conn, app.BabyStateManager, watchCtx)
 	})
 
-	// Ask for status
-	// conn.SendRequest(client.RequestType_GET_STATUS, &client.Request{
-	// 	GetStatus_: &client.GetStatus{
-	// 		All: utils.ConstRefBool(true),
-	// 	},
-	// })
+	// Ask for status (capabilities of the cam)
+	go app.requestStatus(babyUID, conn)
 
-	// Ask for logs
-	// conn.SendRequest(client.RequestType_GET_LOGS, &client.Request{
-	// 	GetLogs: &client.GetLogs{
-	// 		Url: utils.ConstRefStr("http://192.168.3.234:8080/log"),
-	// 	},
-	// })
+	// Ask for logs (cam uploads them to our HTTP server)
+	if app.Opts.CamLogs != nil {
+		go app.requestCamLogs(babyUID, conn)
+	}
 
 	var cleanup func()
 
 	// Local streaming
 	if app.Opts.RTMP != nil {
 		initializeLocalStreaming := func() {
-			requestLocalStreaming(babyUID, app.getLocalStreamURL(babyUID), client.Streaming_STARTED, conn, app.BabyStateManager)
+			requestLocalStreaming(babyUID, app.getLocalStreamURL(babyUID), client.Streaming_STARTED, app.getStartupGraceDeadline(), conn, app.BabyStateManager)
+
+			if app.BabyStateManager.GetBabyState(babyUID).GetStreamRequestState() == baby.StreamRequestState_RequestFailed {
+				if _, err := app.getRTMPPublicAddrCheck(); err != nil {
+					log.Warn().Err(err).Str("public_addr", app.Opts.RTMP.PublicAddr).Msg("Streaming request failed, RTMP server is likely not reachable from the cam")
+				}
+			}
 		}
 
 		// Watch for stream liveness change
 		unsubscribe := app.BabyStateManager.Subscribe(func(updatedBabyUID string, stateUpdate baby.State) {
-			// Do another streaming request if stream just turned unhealthy
 			if updatedBabyUID == babyUID && stateUpdate.StreamState != nil && *stateUpdate.StreamState == baby.StreamState_Unhealthy {
-				// Prevent duplicate request if we already received failure
-				if app.BabyStateManager.GetBabyState(babyUID).GetStreamRequestState() != baby.StreamRequestState_RequestFailed {
+				if app.decideStreamAction(babyUID, baby.StreamEvent_StreamUnhealthy) == baby.StreamAction_Request {
 					go initializeLocalStreaming()
 				}
 			}
@@ -160,18 +466,14 @@ func (app *App) runWebsocket(babyUID string, conn *client.WebsocketConnection, c
 			unsubscribe()
 
 			// Stop local streaming
-			state := app.BabyStateManager.GetBabyState(babyUID)
-			if state.GetIsWebsocketAlive() && state.GetStreamState() == baby.StreamState_Alive {
-				requestLocalStreaming(babyUID, app.getLocalStreamURL(babyUID), client.Streaming_STOPPED, conn, app.BabyStateManager)
+			if app.decideStreamAction(babyUID, baby.StreamEvent_WebsocketClosing) == baby.StreamAction_Stop {
+				requestLocalStreaming(babyUID, app.getLocalStreamURL(babyUID), client.Streaming_STOPPED, time.Time{}, conn, app.BabyStateManager)
 			}
 		}
 
 		// Initialize local streaming upon connection if we know that the stream is not alive
-		babyState := app.BabyStateManager.GetBabyState(babyUID)
-		if babyState.GetStreamState() != baby.StreamState_Alive {
-			if babyState.GetStreamRequestState() != baby.StreamRequestState_Requested || babyState.GetStreamState() == baby.StreamState_Unhealthy {
-				go initializeLocalStreaming()
-			}
+		if app.decideStreamAction(babyUID, baby.StreamEvent_WebsocketReady) == baby.StreamAction_Request {
+			go initializeLocalStreaming()
 		}
 	}
 
@@ -181,8 +483,36 @@ func (app *App) runWebsocket(babyUID string, conn *client.WebsocketConnection, c
 	}
 }
 
-func (app *App) getRemoteStreamURL(babyUID string) string {
-	return fmt.Sprintf("rtmps://media-secured.nanit.com/nanit/%v.%v", babyUID, app.SessionStore.Session.AuthToken)
+// decideStreamAction - consults the stream state machine with the current state of the baby
+func (app *App) decideStreamAction(babyUID string, event baby.StreamEvent) baby.StreamAction {
+	action, rule := baby.NextStreamAction(app.BabyStateManager.GetBabyState(babyUID), event)
+	log.Trace().Str("baby_uid", babyUID).Str("rule", rule).Msg("Stream state machine decision")
+
+	return action
+}
+
+// getStartupGraceDeadline - rejected streaming requests are not considered failed until this time
+func (app *App) getStartupGraceDeadline() time.Time {
+	if app.Opts.RTMP == nil {
+		return time.Time{}
+	}
+
+	return app.startedAt.Add(app.Opts.RTMP.StartupGrace)
+}
+
+func (app *App) getWebsocketManager(babyUID string) *client.WebsocketConnectionManager {
+	app.websocketManagersMu.RLock()
+	defer app.websocketManagersMu.RUnlock()
+
+	return app.websocketManagers[app.getStreamKey(babyUID)]
+}
+
+// getWebsocketConnection - returns ready websocket connection of the baby (nil if it is not connected)
+func (app *App) getWebsocketConnection(babyUID string) *client.WebsocketConnection {
+	app.websocketManagersMu.RLock()
+	defer app.websocketManagersMu.RUnlock()
+
+	return app.websocketConns[app.getStreamKey(babyUID)]
 }
 
 func (app *App) getLocalStreamURL(babyUID string) string {
@@ -0,0 +1,29 @@
+package app
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+func TestShutdownOrder(t *testing.T) {
+	var mu sync.Mutex
+	var stopped []string
+
+	run := func(name string) utils.GracefulRunner {
+		return utils.RunWithGracefulCancel(func(ctx utils.GracefulContext) {
+			<-ctx.Done()
+
+			mu.Lock()
+			stopped = append(stopped, name)
+			mu.Unlock()
+		})
+	}
+
+	app := &App{}
+	app.shutdown(nil, []utils.GracefulRunner{run("websocket")}, []utils.GracefulRunner{run("rtmp")}, []utils.GracefulRunner{run("mqtt")})
+
+	assert.Equal(t, []string{"websocket", "rtmp", "mqtt"}, stopped)
+}
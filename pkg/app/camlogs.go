@@ -0,0 +1,196 @@
+package app
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gitlab.com/adam.stanek/nanit/pkg/client"
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+const (
+	// camLogsRequestTimeout - how long to wait for the cam to acknowledge the logs request
+	camLogsRequestTimeout = 30 * time.Second
+
+	// camLogsMaxSize - upper limit of the accepted archive
+	camLogsMaxSize = 64 << 20
+
+	// camLogsMaxEvents - number of the most recent events kept per baby
+	camLogsMaxEvents = 100
+)
+
+// camLogEventRX - log lines worth surfacing (connectivity and hardware issues)
+var camLogEventRX = regexp.MustCompile(`(?i)\b(error|fail(ed|ure)?|disconnect(ed)?|reboot(ing)?|panic|timeout|timed out|overheat(ing)?)\b`)
+
+// camLogEvent - notable line of the cam logs
+type camLogEvent struct {
+	File string `json:"file"`
+	Line string `json:"line"`
+}
+
+type camLogs struct {
+	ReceivedAt time.Time     `json:"received_at"`
+	File       string        `json:"file"`
+	Events     []camLogEvent `json:"events"`
+}
+
+// getCamLogsURL - URL to which the cam uploads the logs (derived from the RTMP public address unless configured)
+func (app *App) getCamLogsURL(babyUID string) string {
+	baseURL := app.Opts.CamLogs.ReceiverURL
+	if baseURL == "" {
+		host, _, _ := net.SplitHostPort(app.Opts.RTMP.PublicAddr)
+		baseURL = fmt.Sprintf("http://%v", net.JoinHostPort(host, fmt.Sprint(httpPort)))
+	}
+
+	return fmt.Sprintf("%v/log/%v", strings.TrimSuffix(baseURL, "/"), babyUID)
+}
+
+// requestCamLogs - asks the cam to upload its logs, once per application run
+func (app *App) requestCamLogs(babyUID string, conn *client.WebsocketConnection) {
+	app.camLogsMu.Lock()
+	alreadyRequested := app.camLogsRequested[babyUID]
+	app.camLogsRequested[babyUID] = true
+	app.camLogsMu.Unlock()
+
+	if alreadyRequested {
+		return
+	}
+
+	url := app.getCamLogsURL(babyUID)
+	log.Info().Str("baby_uid", babyUID).Str("url", utils.RedactURL(url)).Msg("Requesting cam logs")
+
+	awaitResponse := conn.SendRequest(client.RequestType_GET_LOGS, &client.Request{
+		GetLogs: &client.GetLogs{
+			Url: &url,
+		},
+	})
+
+	if _, err := awaitResponse(camLogsRequestTimeout); err != nil {
+		log.Warn().Str("baby_uid", babyUID).Err(err).Msg("Cam logs request failed")
+
+		// Allow retry upon next connection
+		app.camLogsMu.Lock()
+		delete(app.camLogsRequested, babyUID)
+		app.camLogsMu.Unlock()
+	}
+}
+
+// handleCamLogs - receives the logs uploaded by the cam
+// Note: Cam is sending tared archive through curl as binary file
+func (app *App) handleCamLogs(w http.ResponseWriter, r *http.Request) {
+	babyUID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/log"), "/")
+	if babyUID != "" && !uidRegexp.MatchString(babyUID) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	defer r.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, camLogsMaxSize))
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to receive cam logs")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	name := "camlogs"
+	if babyUID != "" {
+		name = fmt.Sprintf("camlogs-%v", babyUID)
+	}
+
+	filename := filepath.Join(app.Opts.DataDirectories.LogDir, fmt.Sprintf("%v-%v.tar.gz", name, time.Now().Format("20060102-150405")))
+	log.Info().Str("file", filename).Int("size", len(data)).Msg("Saving cam logs to file")
+
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		log.Error().Str("file", filename).Err(err).Msg("Unable to save received log file")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	events, err := parseCamLogs(data)
+	if err != nil {
+		log.Warn().Str("file", filename).Err(err).Msg("Unable to parse cam logs")
+	}
+
+	for _, event := range events {
+		log.Debug().Str("baby_uid", babyUID).Str("file", event.File).Msg(event.Line)
+	}
+
+	log.Info().Str("baby_uid", babyUID).Int("events", len(events)).Msg("Cam logs received")
+
+	if babyUID != "" {
+		app.camLogsMu.Lock()
+		app.camLogs[babyUID] = camLogs{ReceivedAt: time.Now(), File: filename, Events: events}
+		app.camLogsMu.Unlock()
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getCamLogs - returns the last received cam logs of the baby (false if there are none)
+func (app *App) getCamLogs(babyUID string) (camLogs, bool) {
+	app.camLogsMu.Lock()
+	defer app.camLogsMu.Unlock()
+
+	logs, ok := app.camLogs[babyUID]
+	return logs, ok
+}
+
+// parseCamLogs - extracts notable events from the log archive (tar.gz, tar or plain text), keeps the most recent ones
+func parseCamLogs(data []byte) ([]camLogEvent, error) {
+	var events []camLogEvent
+	scan := func(file string, r io.Reader) error {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); camLogEventRX.MatchString(line) {
+				events = append(events, camLogEvent{File: file, Line: line})
+				if len(events) > camLogsMaxEvents {
+					events = events[1:]
+				}
+			}
+		}
+
+		return scanner.Err()
+	}
+
+	if gzipReader, err := gzip.NewReader(bytes.NewReader(data)); err == nil {
+		defer gzipReader.Close()
+		if data, err = ioutil.ReadAll(gzipReader); err != nil {
+			return events, err
+		}
+	}
+
+	tarReader := tar.NewReader(bytes.NewReader(data))
+	header, err := tarReader.Next()
+	if err != nil {
+		// Not an archive
+		return events, scan("", bytes.NewReader(data))
+	}
+
+	for ; err == nil; header, err = tarReader.Next() {
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if scanErr := scan(header.Name, tarReader); scanErr != nil {
+			return events, fmt.Errorf("%v: %v", header.Name, scanErr)
+		}
+	}
+
+	if err != io.EOF {
+		return events, err
+	}
+
+	return events, nil
+}
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCamLogs(t *testing.T) {
+	var archive bytes.Buffer
+	gzipWriter := gzip.NewWriter(&archive)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	files := map[string]string{
+		"var/log/messages": "boot ok\nwlan0: disconnected from AP\nsensors ok\n",
+		"var/log/streamer": "rtmp connect to 192.168.1.2:1935 failed: timeout\n",
+	}
+
+	for _, name := range []string{"var/log/messages", "var/log/streamer"} {
+		require.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), Typeflag: tar.TypeReg}))
+		tarWriter.Write([]byte(files[name]))
+	}
+
+	tarWriter.Close()
+	gzipWriter.Close()
+
+	events, err := parseCamLogs(archive.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, []camLogEvent{
+		{File: "var/log/messages", Line: "wlan0: disconnected from AP"},
+		{File: "var/log/streamer", Line: "rtmp connect to 192.168.1.2:1935 failed: timeout"},
+	}, events)
+
+	// Plain text
+	events, err = parseCamLogs([]byte("all good\nkernel panic\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []camLogEvent{{Line: "kernel panic"}}, events)
+}
+
+func TestCamLogsURL(t *testing.T) {
+	app := &App{Opts: Opts{RTMP: &RTMPOpts{PublicAddr: "192.168.1.2:1935"}, CamLogs: &CamLogsOpts{}}}
+	assert.Equal(t, "http://192.168.1.2:8080/log/baby1", app.getCamLogsURL("baby1"))
+
+	app.Opts.CamLogs.ReceiverURL = "http://nanit.local:8081/"
+	assert.Equal(t, "http://nanit.local:8081/log/baby1", app.getCamLogsURL("baby1"))
+}
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"time"
+
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+	"gitlab.com/adam.stanek/nanit/pkg/client"
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+// getCapabilities - returns known capabilities of the baby's camera (cached in the session across restarts)
+func (app *App) getCapabilities(babyUID string) baby.Capabilities {
+	return app.SessionStore.GetCapabilities(app.getStreamKey(babyUID))
+}
+
+// updateCapabilities - merges newly detected capabilities of the baby's camera
+func (app *App) updateCapabilities(babyUID string, update baby.Capabilities) {
+	stateKey := app.getStreamKey(babyUID)
+	if capabilities, changed := app.SessionStore.UpdateCapabilities(stateKey, update); changed {
+		log.Debug().Str("baby_uid", stateKey).Interface("capabilities", capabilities).Msg("Camera capabilities updated")
+	}
+}
+
+// requestStatus - asks the cam for its status (hardware and firmware version)
+func (app *App) requestStatus(babyUID string, conn *client.WebsocketConnection) {
+	res, err := conn.SendRequest(client.RequestType_GET_STATUS, &client.Request{
+		GetStatus_: &client.GetStatus{
+			All: utils.ConstRefBool(true),
+		},
+	})(30 * time.Second)
+
+	if err != nil {
+		log.Debug().Str("baby_uid", babyUID).Err(err).Msg("Unable to retrieve cam status")
+		return
+	}
+
+	if res.Status != nil {
+		app.updateCapabilities(babyUID, baby.Capabilities{
+			HardwareVersion: res.Status.GetHardwareVersion(),
+			FirmwareVersion: res.Status.GetCurrentVersion(),
+		})
+	}
+}
+
+// getSensorCapabilities - sensors are considered supported once they report a value (missing reading does not prove anything)
+func getSensorCapabilities(sensorData []*client.SensorData) baby.Capabilities {
+	capabilities := baby.Capabilities{}
+	for _, sensorDataSet := range sensorData {
+		if sensorDataSet.SensorType == nil || sensorDataSet.ValueMilli == nil {
+			continue
+		}
+
+		switch *sensorDataSet.SensorType {
+		case client.SensorType_TEMPERATURE:
+			capabilities.Temperature = utils.ConstRefBool(true)
+		case client.SensorType_HUMIDITY:
+			capabilities.Humidity = utils.ConstRefBool(true)
+		}
+	}
+
+	return capabilities
+}
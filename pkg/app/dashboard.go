@@ -0,0 +1,107 @@
+package app
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// dashboardRefreshSeconds - interval of the state refresh, image is refreshed every 3rd time
+const dashboardRefreshSeconds = 10
+
+// dashboardTemplate - minimal standalone page polling the JSON API
+// Note: kept inline (no embed) so that the app builds with the Go version of the Docker image
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Nanit</title>
+<style>
+body { font-family: sans-serif; margin: 1em; background: #f4f4f4; }
+.baby { background: #fff; border-radius: 8px; padding: 1em; margin-bottom: 1em; max-width: 660px; }
+.baby img { width: 100%; border-radius: 4px; background: #ddd; min-height: 100px; }
+.values span { display: inline-block; margin-right: 1.5em; }
+.offline { color: #b00; }
+</style>
+</head>
+<body>
+{{range .Babies}}
+<div class="baby" data-uid="{{.UID}}">
+  <h2>{{.Name}}</h2>
+  <img alt="No image available">
+  <div class="values">
+    <span>Temperature: <b class="temperature">-</b></span>
+    <span>Humidity: <b class="humidity">-</b></span>
+    <span>Stream: <b class="stream">-</b></span>
+    <span>Cam: <b class="websocket">-</b></span>
+  </div>
+</div>
+{{end}}
+<script>
+var imagePath = {{.ImagePath}};
+var tick = 0;
+
+function refresh() {
+  document.querySelectorAll(".baby").forEach(function (el) {
+    var uid = el.dataset.uid;
+    fetch("/api/babies/" + uid).then(function (res) { return res.json(); }).then(function (status) {
+      var state = status.state || {};
+      el.querySelector(".temperature").textContent = state.temperature !== undefined ? state.temperature.toFixed(1) + " °C" : "-";
+      el.querySelector(".humidity").textContent = state.humidity !== undefined ? state.humidity.toFixed(0) + " %" : "-";
+      el.querySelector(".stream").textContent = status.stream_state || "-";
+
+      var connected = status.websocket && status.websocket.is_connected;
+      var ws = el.querySelector(".websocket");
+      ws.textContent = connected ? "online" : "offline";
+      ws.className = connected ? "websocket" : "websocket offline";
+    }).catch(function () {});
+
+    if (imagePath && tick % 3 === 0) {
+      el.querySelector("img").src = "/api/babies/" + uid + "/" + imagePath + (imagePath.indexOf("?") < 0 ? "?" : "&") + "t=" + Date.now();
+    }
+  });
+
+  tick++;
+}
+
+refresh();
+setInterval(refresh, {{.RefreshSeconds}} * 1000);
+</script>
+</body>
+</html>
+`))
+
+type dashboardBaby struct {
+	UID  string
+	Name string
+}
+
+// GET /dashboard
+func (app *App) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	data := struct {
+		Babies         []dashboardBaby
+		ImagePath      string
+		RefreshSeconds int
+	}{RefreshSeconds: dashboardRefreshSeconds}
+
+	for _, babyInfo := range app.SessionStore.Session.Babies {
+		data.Babies = append(data.Babies, dashboardBaby{babyInfo.UID, babyInfo.Name})
+	}
+
+	// Preview is cheap (already captured), snapshot spawns ffmpeg
+	if app.Opts.Preview != nil {
+		data.ImagePath = "preview"
+	} else if app.Opts.RTMP != nil {
+		data.ImagePath = "snapshot?w=640"
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, data); err != nil {
+		log.Error().Err(err).Msg("Unable to render dashboard")
+	}
+}
@@ -0,0 +1,61 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+	"gitlab.com/adam.stanek/nanit/pkg/client"
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+// errNightLightUnsupported - cam rejected the night light control before, it is not offered anymore
+var errNightLightUnsupported = errors.New("night light is not supported by the cam")
+
+// processControl - updates the state from the control request (cam announces changes made by other clients)
+func processControl(babyUID string, control *client.Control, sink stateSink) {
+	if control.NightLight != nil {
+		sink.Update(babyUID, *baby.NewState().SetNightLight(*control.NightLight == client.Control_LIGHT_ON))
+	}
+}
+
+// setNightLight - switches the night light of the cam
+// Note: protocol does not expose capabilities of the cam, support is detected from the response
+func (app *App) setNightLight(babyUID string, on bool) error {
+	if !app.getCapabilities(babyUID).HasNightLight() {
+		return errNightLightUnsupported
+	}
+
+	conn := app.getWebsocketConnection(babyUID)
+	if conn == nil {
+		return errors.New("websocket is not connected")
+	}
+
+	nightLight := client.Control_LIGHT_OFF
+	if on {
+		nightLight = client.Control_LIGHT_ON
+	}
+
+	log.Info().Str("baby_uid", babyUID).Bool("on", on).Msg("Switching night light")
+	_, err := conn.SendRequest(client.RequestType_PUT_CONTROL, &client.Request{
+		Control: &client.Control{
+			NightLight: nightLight.Enum(),
+		},
+	})(10 * time.Second)
+
+	if err != nil {
+		var resErr *client.ResponseError
+		if errors.As(err, &resErr) && resErr.StatusCode >= 400 && resErr.StatusCode < 500 {
+			log.Warn().Str("baby_uid", babyUID).Err(err).Msg("Cam rejected night light control, considering it unsupported")
+			app.updateCapabilities(babyUID, baby.Capabilities{NightLight: utils.ConstRefBool(false)})
+			return fmt.Errorf("%w: %v", errNightLightUnsupported, err)
+		}
+
+		return err
+	}
+
+	app.updateCapabilities(babyUID, baby.Capabilities{NightLight: utils.ConstRefBool(true)})
+	app.BabyStateManager.Update(babyUID, *baby.NewState().SetNightLight(on))
+	return nil
+}
@@ -0,0 +1,54 @@
+package app
+
+import (
+	"errors"
+	"time"
+
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+const (
+	// NoBabies_Retry - babies are fetched again periodically until some appear (ie. right after the account setup)
+	NoBabies_Retry = "retry"
+	// NoBabies_Exit - app terminates with an error
+	NoBabies_Exit = "exit"
+)
+
+// defaultNoBabiesRetryInterval - used if the interval is not set in the options
+const defaultNoBabiesRetryInterval = 5 * time.Minute
+
+var errNoBabies = errors.New("No babies found in the account")
+
+// waitForBabies - handles the account without babies according to the options,
+// returns false if the app should not continue (terminated or cancelled while waiting)
+func (app *App) waitForBabies(ctx utils.GracefulContext) bool {
+	if app.Opts.NoBabies == NoBabies_Exit {
+		log.Error().Msg("No babies found in the account, check that the cam is set up in the Nanit app")
+		app.fatalErr = errNoBabies
+		return false
+	}
+
+	retryInterval := app.Opts.NoBabiesRetryInterval
+	if retryInterval == 0 {
+		retryInterval = defaultNoBabiesRetryInterval
+	}
+
+	log.Warn().Dur("retry_interval", retryInterval).Msg("No babies found in the account, waiting for them to appear")
+
+	ticker := time.NewTicker(retryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+			if babies := app.RestClient.EnsureBabies(); len(babies) > 0 {
+				log.Info().Int("num_babies", len(babies)).Msg("Babies found, starting")
+				return true
+			}
+
+			log.Debug().Msg("Still no babies found in the account")
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"net/http"
+	"time"
+
+	"gitlab.com/adam.stanek/nanit/pkg/notify"
+)
+
+type cooldownPayload struct {
+	BabyUID         string           `json:"baby_uid"`
+	Event           notify.EventType `json:"event"`
+	LastSentAt      time.Time        `json:"last_sent_at"`
+	IntervalSeconds float64          `json:"interval_seconds"`
+	AllowedAt       time.Time        `json:"allowed_at"`
+	IsCoolingDown   bool             `json:"is_cooling_down"`
+	Aggregated      int              `json:"aggregated"`
+}
+
+// GET /api/notifications/cooldowns - rate limiting state of the delivered notifications
+func (app *App) handleAPINotificationCooldowns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if app.Notifier == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Notifications are disabled"})
+		return
+	}
+
+	now := time.Now()
+	payload := []cooldownPayload{}
+	for _, cooldown := range app.Notifier.GetCooldowns() {
+		payload = append(payload, cooldownPayload{
+			BabyUID:         cooldown.BabyUID,
+			Event:           cooldown.Event,
+			LastSentAt:      cooldown.LastSentAt,
+			IntervalSeconds: cooldown.Interval.Seconds(),
+			AllowedAt:       cooldown.AllowedAt,
+			IsCoolingDown:   now.Before(cooldown.AllowedAt),
+			Aggregated:      cooldown.Aggregated,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, payload)
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+	"gitlab.com/adam.stanek/nanit/pkg/client"
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+const (
+	// onceStreamTimeout - how long to wait for the cam to start streaming
+	onceStreamTimeout = 1 * time.Minute
+
+	// onceCaptureTimeout - how long can ffmpeg take to grab the frame
+	onceCaptureTimeout = 30 * time.Second
+)
+
+// RunOnce - requests the stream of a single baby, stores a snapshot of it to outputFile and returns
+// Empty babyUID is allowed if there is only a single baby on the account.
+func (app *App) RunOnce(ctx utils.GracefulContext, babyUID string, outputFile string) error {
+	if app.Opts.RTMP == nil {
+		return errors.New("Local RTMP streaming has to be enabled to capture a snapshot")
+	}
+
+	// Reauthorize if we don't have a token or we assume it is invalid
+	app.RestClient.MaybeAuthorize(false)
+
+	babyInfo, err := pickBaby(app.RestClient.EnsureBabies(), babyUID)
+	if err != nil {
+		return err
+	}
+
+	rtmpRunner := ctx.RunAsChild(func(childCtx utils.GracefulContext) {
+		app.RTMPServer.Run(childCtx)
+	})
+
+	defer rtmpRunner.Cancel()
+
+	resultC := make(chan error, 1)
+
+	ws := client.NewWebsocketConnectionManager(babyInfo.UID, babyInfo.CameraUID, app.SessionStore, app.RestClient, app.BabyStateManager)
+	ws.WithReadyConnection(func(conn *client.WebsocketConnection, childCtx utils.GracefulContext) {
+		select {
+		case resultC <- app.captureOnce(babyInfo.UID, outputFile, conn, childCtx):
+		default:
+		}
+	})
+
+	runner := ctx.RunAsChild(func(childCtx utils.GracefulContext) {
+		ws.RunWithinContext(childCtx)
+	})
+
+	defer runner.Cancel()
+
+	var connectTimeoutC <-chan time.Time
+	if app.Opts.WebsocketConnectTimeout > 0 {
+		connectTimer := time.NewTimer(app.Opts.WebsocketConnectTimeout)
+		defer connectTimer.Stop()
+		connectTimeoutC = connectTimer.C
+	}
+
+	for {
+		select {
+		case err := <-resultC:
+			return err
+		case <-connectTimeoutC:
+			if !ws.GetStats().IsConnected {
+				return fmt.Errorf("Websocket connection not established within %v", app.Opts.WebsocketConnectTimeout)
+			}
+
+			// Connected in time, the capture has its own timeouts
+			connectTimeoutC = nil
+		case <-ctx.Done():
+			return errors.New("Snapshot capture has been cancelled")
+		}
+	}
+}
+
+func (app *App) captureOnce(babyUID string, outputFile string, conn *client.WebsocketConnection, ctx utils.GracefulContext) error {
+	aliveC := make(chan struct{}, 1)
+	unsubscribe := app.BabyStateManager.Subscribe(func(updatedBabyUID string, state baby.State) {
+		if updatedBabyUID == babyUID && state.GetStreamState() == baby.StreamState_Alive {
+			select {
+			case aliveC <- struct{}{}:
+			default:
+			}
+		}
+	})
+
+	defer unsubscribe()
+
+	requestLocalStreaming(babyUID, app.getLocalStreamURL(babyUID), client.Streaming_STARTED, app.getStartupGraceDeadline(), conn, app.BabyStateManager)
+
+	// Make sure we leave the cam as we found it
+	defer func() {
+		if app.BabyStateManager.GetBabyState(babyUID).GetIsWebsocketAlive() {
+			requestLocalStreaming(babyUID, app.getLocalStreamURL(babyUID), client.Streaming_STOPPED, time.Time{}, conn, app.BabyStateManager)
+		}
+	}()
+
+	state := app.BabyStateManager.GetBabyState(babyUID)
+	if state.GetStreamRequestState() == baby.StreamRequestState_RequestFailed && state.GetStreamState() != baby.StreamState_Alive {
+		return errors.New("Cam refused the local streaming request")
+	}
+
+	select {
+	case <-aliveC:
+	case <-ctx.Done():
+		return errors.New("Websocket connection closed before the stream started")
+	case <-time.After(onceStreamTimeout):
+		return fmt.Errorf("Stream did not start within %v", onceStreamTimeout)
+	}
+
+	log.Info().Str("baby_uid", babyUID).Str("file", outputFile).Msg("Capturing snapshot")
+	if err := captureSnapshot(app.getLocalPlaybackURL(babyUID), outputFile, onceCaptureTimeout); err != nil {
+		return err
+	}
+
+	if app.Opts.CaptureSidecars {
+		return writeSidecar(outputFile, app.getCaptureMetadata(babyUID, "once", time.Now()))
+	}
+
+	return nil
+}
+
+func pickBaby(babies []baby.Baby, babyUID string) (baby.Baby, error) {
+	if babyUID == "" {
+		if len(babies) != 1 {
+			return baby.Baby{}, fmt.Errorf("Account has %v babies, please specify which one to capture", len(babies))
+		}
+
+		return babies[0], nil
+	}
+
+	for _, babyInfo := range babies {
+		if babyInfo.UID == babyUID {
+			return babyInfo, nil
+		}
+	}
+
+	return baby.Baby{}, fmt.Errorf("Unknown baby %v", babyUID)
+}
@@ -1,7 +1,20 @@
 package app
 
 import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"gitlab.com/adam.stanek/nanit/pkg/client"
+	"gitlab.com/adam.stanek/nanit/pkg/history"
+	"gitlab.com/adam.stanek/nanit/pkg/homekit"
 	"gitlab.com/adam.stanek/nanit/pkg/mqtt"
+	"gitlab.com/adam.stanek/nanit/pkg/notify"
+	"gitlab.com/adam.stanek/nanit/pkg/rtmpserver"
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
 )
 
 // Opts - application run options
@@ -10,14 +23,78 @@ type Opts struct {
 	SessionFile      string
 	DataDirectories  DataDirectories
 	HTTPEnabled      bool
+	HTTPAdminToken   string
+	Sensors          SensorOpts
 	MQTT             *mqtt.Opts
 	RTMP             *RTMPOpts
+	Timelapse        *TimelapseOpts
+	Preview          *PreviewOpts
+	Presence         *PresenceOpts
+	Rewind           *RewindOpts
+	Notifications    *notify.Opts
+	HomeKit          *homekit.Opts
+	History          *history.Opts
+	BuildInfo        BuildInfo
+
+	// Serve minimal dashboard at /dashboard
+	HTTPDashboard bool
+
+	// Snapshots served over HTTP are reused for this long (0 = only concurrent requests share the capture)
+	HTTPSnapshotCacheTTL time.Duration
+
+	// Bounds of the snapshot size requested over HTTP (0 = no bound)
+	HTTPSnapshotMaxWidth  int
+	HTTPSnapshotMaxHeight int
+
+	// Log connection and stream transitions as structured events (component "state", event "state_transition")
+	LogStateTransitions bool
+
+	// Write JSON sidecar with metadata next to the captured images (timelapse frames, -once snapshot)
+	CaptureSidecars bool
+
+	// Retrieval of the cam logs (nil = disabled)
+	CamLogs *CamLogsOpts
+
+	// ShutdownTimeout - deadline for the whole shutdown, the app is terminated without finishing the clean up afterwards (0 = no deadline)
+	ShutdownTimeout time.Duration
+
+	// SharedCameras - handling of the camera paired with multiple babies (SharedCameras_Share or SharedCameras_Separate, empty = share)
+	SharedCameras string
+
+	// NoBabies - handling of the account without babies (NoBabies_Retry or NoBabies_Exit, empty = retry)
+	NoBabies string
+
+	// NoBabiesRetryInterval - interval of fetching the babies again while there are none (NoBabies_Retry only, 0 = 5 minutes)
+	NoBabiesRetryInterval time.Duration
+
+	// WebsocketMaxFailures - app terminates after this many consecutive failed websocket connection attempts,
+	// so that its supervisor (systemd, Kubernetes, ...) can restart it (0 = retry forever)
+	WebsocketMaxFailures int
+
+	// WebsocketConnectTimeout - cam is reported as offline if its websocket does not connect within this time after startup (0 = disabled)
+	WebsocketConnectTimeout time.Duration
+
+	// ResumeThreshold - wall clock jumping ahead of the monotonic one by more than this is treated as a resume from sleep,
+	// the token is refreshed and the websockets reconnected right away (0 = disabled)
+	ResumeThreshold time.Duration
 }
 
 // NanitCredentials - user credentials for Nanit account
 type NanitCredentials struct {
 	Email    string
 	Password string
+
+	// Assumed lifetime of the auth token, valid stored token is reused across restarts (0 = client.AuthTokenTimelife)
+	TokenLifetime time.Duration
+
+	// Token is refreshed this long before its assumed expiry
+	TokenRefreshMargin time.Duration
+
+	// Tolerated difference when the token seems to be issued in the future (ie. system clock was adjusted)
+	ClockSkewTolerance time.Duration
+
+	// Proxy for the Nanit API and websocket (empty = use HTTP_PROXY/HTTPS_PROXY environment variables)
+	ProxyURL string
 }
 
 // DataDirectories - dictionary of dir paths
@@ -34,4 +111,340 @@ type RTMPOpts struct {
 
 	// IP:Port under which can Cam reach the RTMP server
 	PublicAddr string
+
+	// Conditions for declaring the published stream alive
+	Probe rtmpserver.ProbeOpts
+
+	// Streaming requests rejected within this time after startup are retried instead of being considered failed
+	StartupGrace time.Duration
+}
+
+// SensorOpts - options for reading sensor data
+type SensorOpts struct {
+	// Interval of re-requesting sensor data from the cam (0 = disabled)
+	RefreshInterval time.Duration
+
+	// Readings are marked as stale if there is no update within this time (0 = disabled)
+	StaleTimeout time.Duration
+
+	// Readings outside of these ranges are considered as sensor glitches and ignored
+	TemperatureRange SensorRange
+	HumidityRange    SensorRange
+
+	// Publish every reading, even if it does not change the state (by default the unchanged readings are dropped)
+	PublishUnchanged bool
+}
+
+// SensorRange - range of plausible sensor values (inclusive)
+type SensorRange struct {
+	Min float64
+	Max float64
+}
+
+// contains - checks whether the reading in thousandths of a unit falls within the range
+func (r SensorRange) contains(valueMilli int32) bool {
+	value := float64(valueMilli) / 1000
+	return value >= r.Min && value <= r.Max
+}
+
+// TimelapseOpts - options for periodic capturing of stream frames
+type TimelapseOpts struct {
+	// Interval between captured frames
+	Interval time.Duration
+
+	// Directory for the frames (each baby has its own subdirectory)
+	Dir string
+
+	// Frames older than this are removed (0 = keep forever)
+	Retention time.Duration
+
+	// Frames are captured only after the stream has been alive for this long (skips brief stream blips)
+	MinStreamAge time.Duration
+
+	// Per baby override of MinStreamAge (by baby UID)
+	MinStreamAgeByBaby map[string]time.Duration
+
+	// Layout of the frame filenames in Go time format, without the extension (empty = defaultTimelapseFilenameFormat)
+	FilenameFormat string
+}
+
+// defaultTimelapseFilenameFormat - ie. 20210131-235959
+const defaultTimelapseFilenameFormat = "20060102-150405"
+
+func (opts TimelapseOpts) getFilenameFormat() string {
+	if opts.FilenameFormat == "" {
+		return defaultTimelapseFilenameFormat
+	}
+
+	return opts.FilenameFormat
+}
+
+// getMinStreamAge - returns minimal stream age for given baby
+func (opts TimelapseOpts) getMinStreamAge(babyUID string) time.Duration {
+	if minStreamAge, ok := opts.MinStreamAgeByBaby[babyUID]; ok {
+		return minStreamAge
+	}
+
+	return opts.MinStreamAge
+}
+
+// PreviewOpts - options for periodically refreshed low-res preview image
+type PreviewOpts struct {
+	// Interval between preview refreshes
+	Interval time.Duration
+
+	// Width of the preview image in pixels, height is scaled accordingly (0 = original size)
+	Width int
+}
+
+// RewindOpts - options for the rolling buffer of the stream ("instant replay")
+type RewindOpts struct {
+	// Length of the buffer
+	Duration time.Duration
+
+	// Length of a single HLS segment, disk usage is bounded by the buffer length plus one segment
+	SegmentDuration time.Duration
+}
+
+// PresenceOpts - rules of the presence derived from the motion and sound alerts
+type PresenceOpts struct {
+	// Motion within this window means active
+	ActiveWindow time.Duration
+
+	// Motion or sound within this window means awake, quiet for the whole window means sleeping
+	AwakeWindow time.Duration
+
+	// Sleeping is reported only in the night mode (awake otherwise)
+	SleepRequiresNight bool
+}
+
+// CamLogsOpts - options for retrieving the cam logs
+type CamLogsOpts struct {
+	// Base URL of our HTTP server as seen from the cam (empty = derived from the RTMP public address)
+	ReceiverURL string
+}
+
+// Validate - checks the options (including the dependencies between them), returns descriptive error if they are not valid
+func (opts Opts) Validate() error {
+	var errs []string
+	addErr := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	if opts.NanitCredentials.Email == "" || opts.NanitCredentials.Password == "" {
+		addErr("Nanit e-mail and password are required")
+	}
+
+	tokenLifetime := opts.NanitCredentials.TokenLifetime
+	if tokenLifetime == 0 {
+		tokenLifetime = client.AuthTokenTimelife
+	}
+
+	if opts.NanitCredentials.TokenLifetime < 0 || opts.NanitCredentials.TokenRefreshMargin < 0 || opts.NanitCredentials.ClockSkewTolerance < 0 {
+		addErr("token lifetime, token refresh margin and clock skew tolerance cannot be negative")
+	} else if opts.NanitCredentials.TokenRefreshMargin >= tokenLifetime {
+		addErr("token refresh margin has to be shorter than the token lifetime (%v)", tokenLifetime)
+	}
+
+	if opts.NanitCredentials.ProxyURL != "" {
+		if proxyURL, err := url.Parse(opts.NanitCredentials.ProxyURL); err != nil {
+			// Note: URL is not included, it can contain credentials
+			addErr("invalid proxy URL: %v", err.(*url.Error).Err)
+		} else if (proxyURL.Scheme != "http" && proxyURL.Scheme != "https" && proxyURL.Scheme != "socks5") || proxyURL.Host == "" {
+			addErr("invalid proxy URL %q, expected {http|https|socks5}://{host}:{port}", utils.RedactURL(opts.NanitCredentials.ProxyURL))
+		}
+	}
+
+	if opts.HTTPEnabled && opts.DataDirectories.BaseDir == "" {
+		addErr("HTTP server requires data directory")
+	}
+
+	if opts.HTTPDashboard && !opts.HTTPEnabled {
+		addErr("dashboard requires HTTP server to be enabled")
+	}
+
+	if opts.HTTPSnapshotCacheTTL < 0 {
+		addErr("HTTP snapshot cache TTL cannot be negative")
+	}
+
+	if opts.WebsocketMaxFailures < 0 {
+		addErr("websocket max failures cannot be negative")
+	}
+
+	if opts.WebsocketConnectTimeout < 0 {
+		addErr("websocket connect timeout cannot be negative")
+	}
+
+	if opts.ResumeThreshold < 0 {
+		addErr("resume threshold cannot be negative")
+	}
+
+	if opts.SharedCameras != "" && opts.SharedCameras != SharedCameras_Share && opts.SharedCameras != SharedCameras_Separate {
+		addErr("invalid shared cameras mode %q (allowed values %v, %v)", opts.SharedCameras, SharedCameras_Share, SharedCameras_Separate)
+	}
+
+	if opts.NoBabies != "" && opts.NoBabies != NoBabies_Retry && opts.NoBabies != NoBabies_Exit {
+		addErr("invalid no babies mode %q (allowed values %v, %v)", opts.NoBabies, NoBabies_Retry, NoBabies_Exit)
+	}
+
+	if opts.NoBabiesRetryInterval < 0 {
+		addErr("no babies retry interval cannot be negative")
+	}
+
+	if opts.ShutdownTimeout < 0 {
+		addErr("shutdown timeout cannot be negative")
+	}
+
+	if opts.HTTPSnapshotMaxWidth < 0 || opts.HTTPSnapshotMaxHeight < 0 {
+		addErr("HTTP snapshot maximum width and height cannot be negative")
+	}
+
+	if opts.Sensors.RefreshInterval < 0 || opts.Sensors.StaleTimeout < 0 {
+		addErr("sensor refresh interval and stale timeout cannot be negative")
+	} else if opts.Sensors.RefreshInterval > 0 && opts.Sensors.StaleTimeout > 0 && opts.Sensors.StaleTimeout <= opts.Sensors.RefreshInterval {
+		addErr("sensor stale timeout (%v) has to be longer than the refresh interval (%v)", opts.Sensors.StaleTimeout, opts.Sensors.RefreshInterval)
+	}
+
+	if opts.Sensors.TemperatureRange.Min >= opts.Sensors.TemperatureRange.Max {
+		addErr("sensor temperature range minimum (%v) has to be lower than the maximum (%v)", opts.Sensors.TemperatureRange.Min, opts.Sensors.TemperatureRange.Max)
+	}
+
+	if opts.Sensors.HumidityRange.Min >= opts.Sensors.HumidityRange.Max {
+		addErr("sensor humidity range minimum (%v) has to be lower than the maximum (%v)", opts.Sensors.HumidityRange.Min, opts.Sensors.HumidityRange.Max)
+	}
+
+	if opts.RTMP != nil {
+		if err := opts.RTMP.validate(); err != nil {
+			addErr("RTMP: %v", err)
+		}
+	}
+
+	if opts.MQTT != nil {
+		if err := opts.MQTT.Validate(); err != nil {
+			addErr("MQTT: %v", err)
+		}
+	}
+
+	if opts.Timelapse != nil {
+		if opts.RTMP == nil {
+			addErr("timelapse requires RTMP server to be enabled")
+		}
+
+		if opts.Timelapse.Interval <= 0 {
+			addErr("timelapse interval has to be positive")
+		}
+
+		if opts.Timelapse.Dir == "" {
+			addErr("timelapse directory is required")
+		}
+
+		if opts.Timelapse.MinStreamAge < 0 {
+			addErr("timelapse minimal stream age cannot be negative")
+		}
+
+		if strings.ContainsAny(opts.Timelapse.FilenameFormat, `/\`) {
+			addErr("timelapse filename format cannot contain path separators")
+		}
+
+		for babyUID, minStreamAge := range opts.Timelapse.MinStreamAgeByBaby {
+			if minStreamAge < 0 {
+				addErr("timelapse minimal stream age of baby %v cannot be negative", babyUID)
+			}
+		}
+	}
+
+	if opts.Preview != nil {
+		if opts.RTMP == nil {
+			addErr("preview requires RTMP server to be enabled")
+		}
+
+		if opts.Preview.Interval <= 0 {
+			addErr("preview interval has to be positive")
+		}
+
+		if opts.Preview.Width < 0 {
+			addErr("preview width cannot be negative")
+		}
+	}
+
+	if opts.Rewind != nil {
+		if opts.RTMP == nil || !opts.HTTPEnabled {
+			addErr("rewind requires RTMP and HTTP servers to be enabled")
+		}
+
+		if opts.Rewind.Duration <= 0 || opts.Rewind.SegmentDuration <= 0 {
+			addErr("rewind durations have to be positive")
+		} else if opts.Rewind.SegmentDuration > opts.Rewind.Duration {
+			addErr("rewind segment cannot be longer than the rewind duration")
+		}
+	}
+
+	if opts.Presence != nil {
+		if opts.Presence.ActiveWindow <= 0 || opts.Presence.AwakeWindow <= 0 {
+			addErr("presence windows have to be positive")
+		} else if opts.Presence.ActiveWindow > opts.Presence.AwakeWindow {
+			addErr("presence active window cannot be longer than the awake window")
+		}
+	}
+
+	if opts.CamLogs != nil {
+		if !opts.HTTPEnabled {
+			addErr("cam logs require HTTP server to be enabled")
+		}
+
+		if opts.CamLogs.ReceiverURL == "" && opts.RTMP == nil {
+			addErr("cam logs receiver URL is required when RTMP server is disabled")
+		} else if opts.CamLogs.ReceiverURL != "" {
+			if receiverURL, err := url.Parse(opts.CamLogs.ReceiverURL); err != nil || (receiverURL.Scheme != "http" && receiverURL.Scheme != "https") || receiverURL.Host == "" {
+				addErr("invalid cam logs receiver URL %q, expected http://{host}:{port}", opts.CamLogs.ReceiverURL)
+			}
+		}
+	}
+
+	if opts.Notifications != nil {
+		if err := opts.Notifications.Validate(); err != nil {
+			addErr("notifications: %v", err)
+		}
+	}
+
+	if opts.HomeKit != nil {
+		if err := opts.HomeKit.Validate(); err != nil {
+			addErr("HomeKit: %v", err)
+		}
+	}
+
+	if opts.History != nil {
+		if err := opts.History.Validate(); err != nil {
+			addErr("history: %v", err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+func (opts RTMPOpts) validate() error {
+	if _, _, err := net.SplitHostPort(opts.ListenAddr); err != nil {
+		return fmt.Errorf("invalid listen address %q: %v", opts.ListenAddr, err)
+	}
+
+	host, _, err := net.SplitHostPort(opts.PublicAddr)
+	if err != nil {
+		return fmt.Errorf("invalid public address %q: %v", opts.PublicAddr, err)
+	} else if host == "" {
+		return fmt.Errorf("public address %q has to contain IP/hostname reachable from the cam", opts.PublicAddr)
+	}
+
+	if opts.Probe.MinFrames < 0 || opts.Probe.Duration < 0 || opts.Probe.UnhealthyDebounce < 0 {
+		return errors.New("stream probe parameters cannot be negative")
+	}
+
+	if opts.StartupGrace < 0 {
+		return errors.New("startup grace period cannot be negative")
+	}
+
+	return nil
 }
@@ -0,0 +1,84 @@
+package app_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/adam.stanek/nanit/pkg/app"
+	"gitlab.com/adam.stanek/nanit/pkg/homekit"
+	"gitlab.com/adam.stanek/nanit/pkg/mqtt"
+)
+
+func validOpts() app.Opts {
+	return app.Opts{
+		NanitCredentials: app.NanitCredentials{Email: "xxx@xxx.tld", Password: "xxx"},
+		DataDirectories:  app.DataDirectories{BaseDir: "/data"},
+		Sensors: app.SensorOpts{
+			RefreshInterval:  5 * time.Minute,
+			StaleTimeout:     15 * time.Minute,
+			TemperatureRange: app.SensorRange{Min: -10, Max: 50},
+			HumidityRange:    app.SensorRange{Min: 0, Max: 100},
+		},
+		RTMP: &app.RTMPOpts{ListenAddr: ":1935", PublicAddr: "192.168.1.2:1935"},
+		MQTT: &mqtt.Opts{BrokerURL: "tcp://192.168.1.3:1883", TopicPrefix: "nanit"},
+	}
+}
+
+func TestOptsValid(t *testing.T) {
+	assert.NoError(t, validOpts().Validate())
+}
+
+func TestOptsValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(opts *app.Opts)
+		errMsg string
+	}{
+		{"missing credentials", func(opts *app.Opts) { opts.NanitCredentials.Password = "" }, "password"},
+		{"proxy without scheme", func(opts *app.Opts) { opts.NanitCredentials.ProxyURL = "192.168.1.2:3128" }, "proxy URL"},
+		{"http without data dir", func(opts *app.Opts) { opts.HTTPEnabled = true; opts.DataDirectories.BaseDir = "" }, "data directory"},
+		{"unknown shared cameras mode", func(opts *app.Opts) { opts.SharedCameras = "merge" }, "shared cameras mode"},
+		{"unknown no babies mode", func(opts *app.Opts) { opts.NoBabies = "ignore" }, "no babies mode"},
+		{"rewind without http", func(opts *app.Opts) {
+			opts.Rewind = &app.RewindOpts{Duration: time.Minute, SegmentDuration: 2 * time.Second}
+		}, "rewind requires"},
+		{"presence active window longer than awake", func(opts *app.Opts) {
+			opts.Presence = &app.PresenceOpts{ActiveWindow: time.Hour, AwakeWindow: time.Minute}
+		}, "presence active window"},
+		{"negative resume threshold", func(opts *app.Opts) { opts.ResumeThreshold = -time.Second }, "resume threshold"},
+		{"stale before refresh", func(opts *app.Opts) { opts.Sensors.StaleTimeout = time.Minute }, "stale timeout"},
+		{"inverted temperature range", func(opts *app.Opts) { opts.Sensors.TemperatureRange = app.SensorRange{Min: 50, Max: -10} }, "temperature range"},
+		{"rtmp public addr without port", func(opts *app.Opts) { opts.RTMP.PublicAddr = "192.168.1.2" }, "public address"},
+		{"rtmp public addr without host", func(opts *app.Opts) { opts.RTMP.PublicAddr = ":1935" }, "reachable from the cam"},
+		{"rtmp negative startup grace", func(opts *app.Opts) { opts.RTMP.StartupGrace = -time.Second }, "startup grace"},
+		{"mqtt broker without scheme", func(opts *app.Opts) { opts.MQTT.BrokerURL = "192.168.1.3:1883" }, "broker URL"},
+		{"mqtt wildcard prefix", func(opts *app.Opts) { opts.MQTT.TopicPrefix = "nanit/#" }, "wildcards"},
+		{"timelapse without rtmp", func(opts *app.Opts) {
+			opts.RTMP = nil
+			opts.Timelapse = &app.TimelapseOpts{Interval: time.Minute, Dir: "/data/timelapse"}
+		}, "timelapse requires RTMP"},
+		{"timelapse negative stream age of baby", func(opts *app.Opts) {
+			opts.Timelapse = &app.TimelapseOpts{Interval: time.Minute, Dir: "/data/timelapse", MinStreamAgeByBaby: map[string]time.Duration{"abc": -time.Second}}
+		}, "stream age of baby abc"},
+		{"preview without rtmp", func(opts *app.Opts) {
+			opts.RTMP = nil
+			opts.Preview = &app.PreviewOpts{Interval: time.Minute}
+		}, "preview requires RTMP"},
+		{"homekit trivial pin", func(opts *app.Opts) {
+			opts.HomeKit = &homekit.Opts{Pin: "12345678", StorageDir: "/data/homekit"}
+		}, "invalid pin"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := validOpts()
+			test.modify(&opts)
+
+			err := opts.Validate()
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), test.errMsg)
+			}
+		})
+	}
+}
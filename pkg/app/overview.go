@@ -0,0 +1,81 @@
+package app
+
+import (
+	"net/http"
+	"time"
+)
+
+// overviewSchemaVersion - incremented on incompatible changes of the overview payload (fields may be added without it)
+const overviewSchemaVersion = 1
+
+type overviewPayload struct {
+	SchemaVersion int                    `json:"schema_version"`
+	GeneratedAt   time.Time              `json:"generated_at"`
+	Version       BuildInfo              `json:"version"`
+	Account       overviewAccountPayload `json:"account"`
+	Babies        []overviewBabyPayload  `json:"babies"`
+}
+
+type overviewAccountPayload struct {
+	TokenExpiresAt *time.Time `json:"token_expires_at"`
+}
+
+type overviewBabyPayload struct {
+	UID     string                  `json:"uid"`
+	Name    string                  `json:"name"`
+	Cameras []overviewCameraPayload `json:"cameras"`
+}
+
+type overviewCameraPayload struct {
+	cameraStatusPayload
+
+	// SharedWith - state key of the camera whose connection and stream are used (only for the cameras shared by multiple babies)
+	SharedWith string `json:"shared_with,omitempty"`
+}
+
+// GET /api/overview - account, babies and all their cameras in a single payload (ie. initial load of a dashboard)
+func (app *App) handleAPIOverview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, app.getOverview())
+}
+
+func (app *App) getOverview() overviewPayload {
+	payload := overviewPayload{
+		SchemaVersion: overviewSchemaVersion,
+		GeneratedAt:   time.Now(),
+		Version:       app.Opts.BuildInfo,
+		Babies:        []overviewBabyPayload{},
+	}
+
+	if app.RestClient != nil {
+		if token, _ := app.SessionStore.GetAuth(); token != "" {
+			tokenExpiresAt := app.RestClient.GetTokenExpiry()
+			payload.Account.TokenExpiresAt = &tokenExpiresAt
+		}
+	}
+
+	for _, babyInfo := range app.SessionStore.Session.Babies {
+		babyPayload := overviewBabyPayload{
+			UID:     babyInfo.UID,
+			Name:    babyInfo.Name,
+			Cameras: []overviewCameraPayload{},
+		}
+
+		for _, cameraUID := range babyInfo.GetCameraUIDs() {
+			cameraPayload := overviewCameraPayload{cameraStatusPayload: app.getCameraStatus(babyInfo, cameraUID)}
+			if streamKey := app.getStreamKey(cameraPayload.StateKey); streamKey != cameraPayload.StateKey {
+				cameraPayload.SharedWith = streamKey
+			}
+
+			babyPayload.Cameras = append(babyPayload.Cameras, cameraPayload)
+		}
+
+		payload.Babies = append(payload.Babies, babyPayload)
+	}
+
+	return payload
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// isSensorProcessingPaused - returns true if the received sensor data are dropped (connections are kept alive)
+func (app *App) isSensorProcessingPaused() bool {
+	return atomic.LoadInt32(&app.sensorProcessingPaused) == 1
+}
+
+// setSensorProcessingPaused - pauses/resumes processing of the sensor data, returns false if it was already in given state
+// Note: fresh sensor data are requested upon resume, so that the consumers do not have to wait for the next update
+func (app *App) setSensorProcessingPaused(paused bool) bool {
+	var value, prevValue int32 = 0, 1
+	if paused {
+		value, prevValue = 1, 0
+	}
+
+	if !atomic.CompareAndSwapInt32(&app.sensorProcessingPaused, prevValue, value) {
+		return false
+	}
+
+	if paused {
+		log.Warn().Msg("Sensor processing paused, sensor data are dropped until resumed")
+		return true
+	}
+
+	log.Info().Msg("Sensor processing resumed")
+
+	app.websocketManagersMu.RLock()
+	for _, conn := range app.websocketConns {
+		requestSensorData(conn)
+	}
+	app.websocketManagersMu.RUnlock()
+
+	return true
+}
+
+// POST /api/sensors/{pause|resume}
+func (app *App) handleAPISensors(w http.ResponseWriter, r *http.Request) {
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/sensors/"), "/")
+	if action != "pause" && action != "resume" {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Unknown action"})
+		return
+	} else if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	app.withAdminAuth(w, r, func() {
+		app.setSensorProcessingPaused(action == "pause")
+		writeJSON(w, http.StatusOK, map[string]bool{"sensor_processing_paused": app.isSensorProcessingPaused()})
+	})
+}
+
+// GET /healthz
+func (app *App) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":                   "ok",
+		"sensor_processing_paused": app.isSensorProcessingPaused(),
+	})
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"time"
+
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+const (
+	// Presence_Active - motion was detected recently
+	Presence_Active = "active"
+	// Presence_Awake - motion or sound was detected within the awake window
+	Presence_Awake = "awake"
+	// Presence_Sleeping - quiet for the whole awake window
+	Presence_Sleeping = "sleeping"
+)
+
+// presenceCheckInterval - how often is the presence re-evaluated (it changes with the passing time, not only with the alerts)
+const presenceCheckInterval = 15 * time.Second
+
+// getPresence - derives the presence from the recent alerts, returns false if it cannot be decided yet
+// (cam is offline or we have not been observing it for the whole awake window)
+func getPresence(state *baby.State, opts PresenceOpts, observingSince time.Time, now time.Time) (string, bool) {
+	if !state.GetIsWebsocketAlive() {
+		return "", false
+	}
+
+	isRecent := func(alertAt *time.Time, window time.Duration) bool {
+		return alertAt != nil && now.Sub(*alertAt) < window
+	}
+
+	if isRecent(state.MotionAlertAt, opts.ActiveWindow) {
+		return Presence_Active, true
+	}
+
+	if isRecent(state.MotionAlertAt, opts.AwakeWindow) || isRecent(state.SoundAlertAt, opts.AwakeWindow) {
+		return Presence_Awake, true
+	}
+
+	if now.Sub(observingSince) < opts.AwakeWindow {
+		return "", false
+	}
+
+	if opts.SleepRequiresNight && (state.IsNight == nil || !*state.IsNight) {
+		return Presence_Awake, true
+	}
+
+	return Presence_Sleeping, true
+}
+
+// runPresence - keeps the presence of all the babies up to date until the context is done
+// Note: presence is published as any other state value (MQTT, API, history), state manager drops the unchanged values
+func (app *App) runPresence(ctx utils.GracefulContext) {
+	observingSince := time.Now()
+
+	update := func(stateKey string, now time.Time) {
+		if presence, ok := getPresence(app.BabyStateManager.GetBabyState(stateKey), *app.Opts.Presence, observingSince, now); ok {
+			app.BabyStateManager.Update(stateKey, *baby.NewState().SetPresence(presence))
+		}
+	}
+
+	unsubscribe := app.BabyStateManager.Subscribe(func(stateKey string, state baby.State) {
+		if state.MotionAlertAt != nil || state.SoundAlertAt != nil || state.IsNight != nil || state.IsWebsocketAlive != nil {
+			update(stateKey, time.Now())
+		}
+	})
+
+	defer unsubscribe()
+
+	ticker := time.NewTicker(presenceCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, babyInfo := range app.SessionStore.Session.Babies {
+				for _, cameraUID := range babyInfo.GetCameraUIDs() {
+					update(babyInfo.GetStateKey(cameraUID), now)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+)
+
+func TestGetPresence(t *testing.T) {
+	now := time.Now()
+	opts := PresenceOpts{ActiveWindow: time.Minute, AwakeWindow: 10 * time.Minute}
+	observingSince := now.Add(-time.Hour)
+
+	online := func() *baby.State { return baby.NewState().SetWebsocketAlive(true) }
+
+	tests := []struct {
+		name     string
+		state    *baby.State
+		opts     PresenceOpts
+		since    time.Time
+		expected string
+	}{
+		{"offline", baby.NewState().SetWebsocketAlive(false).SetMotionAlertAt(now), opts, observingSince, ""},
+		{"recent motion", online().SetMotionAlertAt(now.Add(-30 * time.Second)), opts, observingSince, Presence_Active},
+		{"older motion", online().SetMotionAlertAt(now.Add(-5 * time.Minute)), opts, observingSince, Presence_Awake},
+		{"recent sound", online().SetSoundAlertAt(now.Add(-30 * time.Second)), opts, observingSince, Presence_Awake},
+		{"quiet", online().SetMotionAlertAt(now.Add(-20 * time.Minute)), opts, observingSince, Presence_Sleeping},
+		{"quiet, not observed long enough", online(), opts, now.Add(-time.Minute), ""},
+		{"quiet during the day", online().SetIsNight(false), PresenceOpts{ActiveWindow: time.Minute, AwakeWindow: 10 * time.Minute, SleepRequiresNight: true}, observingSince, Presence_Awake},
+		{"quiet at night", online().SetIsNight(true), PresenceOpts{ActiveWindow: time.Minute, AwakeWindow: 10 * time.Minute, SleepRequiresNight: true}, observingSince, Presence_Sleeping},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			presence, ok := getPresence(test.state, test.opts, test.since, now)
+			assert.Equal(t, test.expected != "", ok)
+			assert.Equal(t, test.expected, presence)
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+// previewCaptureTimeout - how long can ffmpeg take to grab the preview frame
+const previewCaptureTimeout = 30 * time.Second
+
+type previewImage struct {
+	Data       []byte
+	CapturedAt time.Time
+}
+
+// runPreview - periodically refreshes low-res preview image of the stream (only while it is alive)
+// Note: cam does not offer a separate preview stream, so the frame is grabbed from the local RTMP stream
+func (app *App) runPreview(babyUID string, ctx utils.GracefulContext) {
+	sublog := log.With().Str("baby_uid", babyUID).Logger()
+
+	ticker := time.NewTicker(app.Opts.Preview.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := app.checkStreamCapturable(babyUID); err != nil {
+				sublog.Trace().Err(err).Msg("Skipping preview refresh")
+				continue
+			}
+
+			data, err := app.capturePreview(babyUID)
+			if err != nil {
+				sublog.Warn().Err(err).Msg("Unable to refresh preview")
+				continue
+			}
+
+			app.previewsMu.Lock()
+			app.previews[babyUID] = previewImage{Data: data, CapturedAt: time.Now()}
+			app.previewsMu.Unlock()
+
+			sublog.Debug().Int("size", len(data)).Msg("Preview refreshed")
+
+			if app.MQTTConnection != nil {
+				app.MQTTConnection.PublishRaw(babyUID, "preview", data)
+				for _, follower := range app.getSharedCameraFollowers(babyUID) {
+					app.MQTTConnection.PublishRaw(follower, "preview", data)
+				}
+			}
+		}
+	}
+}
+
+func (app *App) capturePreview(babyUID string) ([]byte, error) {
+	var extraArgs []string
+	if app.Opts.Preview.Width > 0 {
+		extraArgs = []string{"-vf", fmt.Sprintf("scale=%v:-2", app.Opts.Preview.Width)}
+	}
+
+	return captureSnapshotBytes(app.getLocalPlaybackURL(babyUID), previewCaptureTimeout, extraArgs...)
+}
+
+// getPreview - returns latest preview image of the baby (false if there is none yet)
+func (app *App) getPreview(babyUID string) (previewImage, bool) {
+	app.previewsMu.RLock()
+	defer app.previewsMu.RUnlock()
+
+	preview, ok := app.previews[app.getStreamKey(babyUID)]
+	return preview, ok
+}
@@ -0,0 +1,53 @@
+package app
+
+import (
+	"net/http"
+	"time"
+)
+
+// reauthorize - logs in again to get a new token (ie. after the password change), the current token is kept if it fails
+// Note: connected websockets reconnect with the new token on their own (see client.WebsocketConnectionManager)
+func (app *App) reauthorize() (time.Time, error) {
+	if err := app.RestClient.TryAuthorize(); err != nil {
+		log.Error().Err(err).Msg("Reauthorization failed, keeping the current token")
+		return time.Time{}, err
+	}
+
+	return app.RestClient.GetTokenExpiry(), nil
+}
+
+// reconnectWebsockets - forces reconnect of all the websockets, returns number of those which were connected
+func (app *App) reconnectWebsockets() int {
+	app.websocketManagersMu.RLock()
+	defer app.websocketManagersMu.RUnlock()
+
+	numReconnected := 0
+	for _, ws := range app.websocketManagers {
+		if ws.Reconnect() {
+			numReconnected++
+		}
+	}
+
+	return numReconnected
+}
+
+// POST /api/auth/reauthorize
+func (app *App) handleAPIReauthorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	app.withAdminAuth(w, r, func() {
+		tokenExpiresAt, err := app.reauthorize()
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":           "ok",
+			"token_expires_at": tokenExpiresAt,
+		})
+	})
+}
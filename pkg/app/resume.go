@@ -0,0 +1,49 @@
+package app
+
+import (
+	"time"
+
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+// resumeCheckInterval - how often is the clock checked for the jump
+const resumeCheckInterval = 5 * time.Second
+
+// getClockJump - returns by how much the wall clock advanced more than the monotonic one since prev
+// Note: monotonic clock does not advance while the system sleeps, wall clock does
+func getClockJump(prev time.Time, now time.Time) time.Duration {
+	return now.Round(0).Sub(prev.Round(0)) - now.Sub(prev)
+}
+
+// runResumeWatcher - refreshes the token and reconnects the websockets when the system resumes from sleep,
+// the connections are dead by then but it could take a long time before their timeouts notice
+func (app *App) runResumeWatcher(ctx utils.GracefulContext) {
+	ticker := time.NewTicker(resumeCheckInterval)
+	defer ticker.Stop()
+
+	prev := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			jump := getClockJump(prev, now)
+			prev = now
+
+			if jump > app.Opts.ResumeThreshold {
+				log.Info().Dur("jump", jump).Msg("Clock jumped ahead, system probably resumed from sleep, reconnecting")
+				app.handleResume()
+			}
+		}
+	}
+}
+
+// handleResume - refreshes the token (current one is kept if it fails) and reconnects all the websockets
+func (app *App) handleResume() {
+	if err := app.RestClient.TryAuthorize(); err != nil {
+		log.Warn().Err(err).Msg("Unable to refresh token after resume, keeping the current one")
+	}
+
+	numReconnected := app.reconnectWebsockets()
+	log.Debug().Int("reconnected", numReconnected).Msg("Websockets reconnected after resume")
+}
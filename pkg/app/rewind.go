@@ -0,0 +1,137 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+// rewindCheckInterval - how often is the stream checked for liveness (both before and while recording the buffer)
+const rewindCheckInterval = 5 * time.Second
+
+// getRewindPlaylistName - playlist in the video directory (prefixed by the state key so that it is served under /video/)
+func getRewindPlaylistName(stateKey string) string {
+	return stateKey + "-rewind.m3u8"
+}
+
+// getRewindFFmpegArgs - copies the stream into the sliding window HLS playlist, old segments are deleted
+func getRewindFFmpegArgs(opts RewindOpts, streamURL string, videoDir string, stateKey string) []string {
+	listSize := int(math.Ceil(float64(opts.Duration) / float64(opts.SegmentDuration)))
+
+	return []string{
+		"-loglevel", "error",
+		"-i", streamURL,
+		"-c", "copy",
+		"-f", "hls",
+		"-hls_time", strconv.FormatFloat(opts.SegmentDuration.Seconds(), 'f', -1, 64),
+		"-hls_list_size", strconv.Itoa(listSize),
+		"-hls_flags", "delete_segments",
+		"-hls_segment_filename", filepath.Join(videoDir, stateKey+"-rewind-%d.ts"),
+		filepath.Join(videoDir, getRewindPlaylistName(stateKey)),
+	}
+}
+
+// runRewind - keeps the last minutes of the stream as HLS playlist (only while the stream is alive)
+func (app *App) runRewind(stateKey string, ctx utils.GracefulContext) {
+	sublog := log.With().Str("baby_uid", stateKey).Logger()
+
+	ticker := time.NewTicker(rewindCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := app.checkStreamCapturable(stateKey); err != nil {
+				continue
+			}
+
+			sublog.Debug().Msg("Stream is alive, recording rewind buffer")
+			app.recordRewind(stateKey, ctx)
+			app.removeRewindFiles(stateKey)
+		}
+	}
+}
+
+// recordRewind - runs ffmpeg until the stream dies, ffmpeg fails or the context is done
+func (app *App) recordRewind(stateKey string, ctx utils.GracefulContext) {
+	sublog := log.With().Str("baby_uid", stateKey).Logger()
+
+	procCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	args := getRewindFFmpegArgs(*app.Opts.Rewind, app.getLocalPlaybackURL(stateKey), app.Opts.DataDirectories.VideoDir, stateKey)
+	sublog.Debug().Str("cmd", utils.RedactCommand("ffmpeg", args)).Msg("Starting rewind buffer")
+
+	var stderr bytes.Buffer
+	cmd := newFFmpegCommand(procCtx, args...)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		sublog.Warn().Err(err).Msg("Unable to start rewind buffer")
+		return
+	}
+
+	doneC := make(chan error, 1)
+	go func() { doneC <- cmd.Wait() }()
+
+	ticker := time.NewTicker(rewindCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			cancel()
+			<-doneC
+			return
+
+		case err := <-doneC:
+			// Restarted by the caller on the next check if the stream is still alive
+			sublog.Warn().Err(err).Str("output", strings.TrimSpace(stderr.String())).Msg("Rewind buffer stopped unexpectedly")
+			return
+
+		case <-ticker.C:
+			if err := app.checkStreamCapturable(stateKey); err != nil {
+				sublog.Debug().Err(err).Msg("Stopping rewind buffer")
+				cancel()
+				<-doneC
+				return
+			}
+		}
+	}
+}
+
+// removeRewindFiles - stale buffer would be replayed as if it was recent
+func (app *App) removeRewindFiles(stateKey string) {
+	files, _ := filepath.Glob(filepath.Join(app.Opts.DataDirectories.VideoDir, stateKey+"-rewind*"))
+	for _, file := range files {
+		os.Remove(file)
+	}
+}
+
+// GET /api/babies/{uid}/rewind - redirects to the HLS playlist of the last minutes of the stream
+func (app *App) handleAPIBabyRewind(w http.ResponseWriter, r *http.Request, babyInfo baby.Baby) {
+	if app.Opts.Rewind == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Rewind is not enabled"})
+		return
+	}
+
+	playlistName := getRewindPlaylistName(babyInfo.UID)
+	if _, err := os.Stat(filepath.Join(app.Opts.DataDirectories.VideoDir, playlistName)); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Rewind buffer is not available, stream is not alive"})
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/video/%v", playlistName), http.StatusFound)
+}
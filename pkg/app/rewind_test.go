@@ -0,0 +1,36 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetRewindFFmpegArgs(t *testing.T) {
+	args := getRewindFFmpegArgs(RewindOpts{Duration: time.Minute, SegmentDuration: 4 * time.Second}, "rtmp://127.0.0.1:1935/local/baby1", "/data/video", "baby1")
+
+	assert.Contains(t, args, "-hls_list_size")
+	assert.Equal(t, "15", args[indexOf(args, "-hls_list_size")+1])
+	assert.Equal(t, "4", args[indexOf(args, "-hls_time")+1])
+	assert.Equal(t, "/data/video/baby1-rewind-%d.ts", args[indexOf(args, "-hls_segment_filename")+1])
+	assert.Equal(t, "/data/video/baby1-rewind.m3u8", args[len(args)-1])
+}
+
+func indexOf(items []string, item string) int {
+	for i, value := range items {
+		if value == item {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func TestRewindNotAvailable(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newTestHTTPHandler(t).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/babies/baby1/rewind", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+const (
+	// rtmpCheckStartupTimeout - how long to wait for the RTMP server to start before checking it
+	rtmpCheckStartupTimeout = 10 * time.Second
+
+	// rtmpCheckDialTimeout - timeout for connecting to the public address
+	rtmpCheckDialTimeout = 5 * time.Second
+)
+
+// runRTMPPublicAddrCheck - verifies that the RTMP server is reachable under the address advertised to the cam
+// Note: the check is done from our perspective, cam might see the network differently (warning only)
+func (app *App) runRTMPPublicAddrCheck(ctx utils.GracefulContext) {
+	deadline := time.Now().Add(rtmpCheckStartupTimeout)
+	for !app.RTMPServer.IsRunning() {
+		if time.Now().After(deadline) {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	err := checkRTMPPublicAddr(app.Opts.RTMP.PublicAddr)
+
+	app.rtmpCheckMu.Lock()
+	app.rtmpCheckErr = err
+	app.rtmpCheckDone = true
+	app.rtmpCheckMu.Unlock()
+
+	if err != nil {
+		log.Warn().Err(err).Str("public_addr", app.Opts.RTMP.PublicAddr).Msg("RTMP server does not seem to be reachable under the public address, cam will likely fail to stream (check NANIT_RTMP_ADDR and port forwarding)")
+	} else {
+		log.Debug().Str("public_addr", app.Opts.RTMP.PublicAddr).Msg("RTMP server is reachable under the public address")
+	}
+}
+
+// getRTMPPublicAddrCheck - returns result of the check (done = false if it has not finished yet)
+func (app *App) getRTMPPublicAddrCheck() (done bool, err error) {
+	app.rtmpCheckMu.RLock()
+	defer app.rtmpCheckMu.RUnlock()
+
+	return app.rtmpCheckDone, app.rtmpCheckErr
+}
+
+func checkRTMPPublicAddr(publicAddr string) error {
+	host, _, err := net.SplitHostPort(publicAddr)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && (ip.IsLoopback() || ip.IsUnspecified())) {
+		return fmt.Errorf("address %v is not reachable from the cam, use address of this machine in your local network", host)
+	}
+
+	conn, err := net.DialTimeout("tcp", publicAddr, rtmpCheckDialTimeout)
+	if err != nil {
+		return err
+	}
+
+	conn.Close()
+	return nil
+}
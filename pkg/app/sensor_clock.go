@@ -0,0 +1,72 @@
+package app
+
+import (
+	"time"
+
+	"gitlab.com/adam.stanek/nanit/pkg/client"
+)
+
+// cameraClockDriftThreshold - difference between the cam and the local clock which is worth reporting
+const cameraClockDriftThreshold = 5 * time.Minute
+
+// sensorDataTimes - when were the sensor data last received (local clock) and what time did the cam report with them
+// Note: the local receipt time is authoritative, camera time is exposed for diagnostics only
+type sensorDataTimes struct {
+	ReceivedAt time.Time
+	CameraTime time.Time
+	Drift      time.Duration
+}
+
+// getSensorDataCameraTime - returns the newest timestamp reported by the cam (false if there is none)
+func getSensorDataCameraTime(sensorData []*client.SensorData) (time.Time, bool) {
+	var newest int32
+	for _, sensorDataSet := range sensorData {
+		if sensorDataSet.Timestamp != nil && *sensorDataSet.Timestamp > newest {
+			newest = *sensorDataSet.Timestamp
+		}
+	}
+
+	if newest == 0 {
+		return time.Time{}, false
+	}
+
+	return time.Unix(int64(newest), 0), true
+}
+
+// recordSensorDataTimes - reconciles the cam timestamps with the local time of receipt, logs when the clocks drift apart
+func (app *App) recordSensorDataTimes(babyUID string, sensorData []*client.SensorData, receivedAt time.Time) {
+	times := sensorDataTimes{ReceivedAt: receivedAt}
+	if cameraTime, ok := getSensorDataCameraTime(sensorData); ok {
+		times.CameraTime = cameraTime
+		times.Drift = cameraTime.Sub(receivedAt)
+	}
+
+	app.sensorDataTimesMu.Lock()
+	prevTimes, hadPrevTimes := app.sensorDataTimes[babyUID]
+	app.sensorDataTimes[babyUID] = times
+	app.sensorDataTimesMu.Unlock()
+
+	// Log only the transitions to avoid flooding the log with every update
+	isDrifting := isCameraClockDrifting(times)
+	wasDrifting := hadPrevTimes && isCameraClockDrifting(prevTimes)
+
+	if isDrifting && !wasDrifting {
+		log.Warn().Str("baby_uid", babyUID).Time("camera_time", times.CameraTime).Dur("drift", times.Drift).Msg("Camera clock differs significantly from the local clock, using local time of receipt")
+	} else if !isDrifting && wasDrifting && !times.CameraTime.IsZero() {
+		log.Info().Str("baby_uid", babyUID).Dur("drift", times.Drift).Msg("Camera clock is in sync again")
+	}
+}
+
+// isCameraClockDrifting - only future timestamps are considered, readings which did not change for a while can carry an old timestamp
+func isCameraClockDrifting(times sensorDataTimes) bool {
+	return !times.CameraTime.IsZero() && times.Drift > cameraClockDriftThreshold
+}
+
+// getSensorDataTimes - returns times of the last sensor data of the baby (false if none were received yet)
+func (app *App) getSensorDataTimes(babyUID string) (sensorDataTimes, bool) {
+	app.sensorDataTimesMu.RLock()
+	defer app.sensorDataTimesMu.RUnlock()
+
+	times, ok := app.sensorDataTimes[babyUID]
+	return times, ok
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/adam.stanek/nanit/pkg/client"
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+func TestSensorDataTimes(t *testing.T) {
+	app := &App{sensorDataTimes: make(map[string]sensorDataTimes)}
+	now := time.Unix(1600000000, 0)
+
+	sensorData := func(timestamps ...int32) []*client.SensorData {
+		var data []*client.SensorData
+		for _, timestamp := range timestamps {
+			data = append(data, &client.SensorData{SensorType: client.SensorType_TEMPERATURE.Enum(), Timestamp: utils.ConstRefInt32(timestamp)})
+		}
+
+		return data
+	}
+
+	// Newest timestamp wins
+	app.recordSensorDataTimes("baby1", sensorData(1599999000, 1599999990), now)
+	times, ok := app.getSensorDataTimes("baby1")
+	assert.True(t, ok)
+	assert.Equal(t, now, times.ReceivedAt)
+	assert.Equal(t, -10*time.Second, times.Drift)
+	assert.False(t, isCameraClockDrifting(times))
+
+	// Future dated
+	app.recordSensorDataTimes("baby1", sensorData(1600003600), now)
+	times, _ = app.getSensorDataTimes("baby1")
+	assert.Equal(t, time.Hour, times.Drift)
+	assert.True(t, isCameraClockDrifting(times))
+
+	// No timestamp at all
+	app.recordSensorDataTimes("baby1", sensorData(0), now)
+	times, _ = app.getSensorDataTimes("baby1")
+	assert.True(t, times.CameraTime.IsZero())
+	assert.False(t, isCameraClockDrifting(times))
+
+	_, ok = app.getSensorDataTimes("baby2")
+	assert.False(t, ok)
+}
@@ -2,21 +2,41 @@ package app
 
 import (
 	"fmt"
-	"io"
 	"net/http"
-	"os"
 	"path/filepath"
-	"time"
+	"strings"
 
-	"github.com/rs/zerolog/log"
 	"gitlab.com/adam.stanek/nanit/pkg/baby"
 )
 
-func serve(babies []baby.Baby, dataDir DataDirectories) {
-	const port = 8080
+// httpPort - port of the HTTP server
+const httpPort = 8080
+
+// serve - starts the HTTP server in the background, returns it so that it can be shut down
+func (app *App) serve() *http.Server {
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%v", httpPort),
+		Handler: app.newHTTPHandler(),
+	}
+
+	log.Info().Int("port", httpPort).Msg("Starting HTTP server")
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Error().Err(err).Msg("HTTP server failed")
+		}
+	}()
+
+	return server
+}
+
+func (app *App) newHTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	babies := app.SessionStore.Session.Babies
+	dataDir := app.Opts.DataDirectories
 
 	// Index handler
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html")
 
 		for _, baby := range babies {
@@ -24,36 +44,43 @@ func serve(babies []baby.Baby, dataDir DataDirectories) {
 		}
 	})
 
-	// Video files
-	http.Handle("/video/", http.StripPrefix("/video/", http.FileServer(http.Dir(dataDir.VideoDir))))
+	// Video files (only files of known babies)
+	mux.HandleFunc("/video/", func(w http.ResponseWriter, r *http.Request) {
+		filename, ok := resolveVideoFile(dataDir.VideoDir, babies, strings.TrimPrefix(r.URL.Path, "/video/"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		http.ServeFile(w, r, filename)
+	})
 
-	// Dummy log handler - useful for receiving logs from cam
-	// Note: Cam is sending tared archive through curl as binary file
-	// TODO: proper handling of Expect: 100-continue
-	http.HandleFunc("/log", func(w http.ResponseWriter, r *http.Request) {
-		filename := filepath.Join(dataDir.LogDir, fmt.Sprintf("camlogs-%v.tar.gz", time.Now().Format(time.RFC3339)))
+	// Logs uploaded by the cam (see GET_LOGS request)
+	mux.HandleFunc("/log", app.handleCamLogs)
+	mux.HandleFunc("/log/", app.handleCamLogs)
 
-		log.Info().Str("file", filename).Msg("Saving log to file")
-		defer r.Body.Close()
+	// JSON API + metrics
+	app.registerAPIHandlers(mux)
 
-		out, err := os.Create(filename)
-		if err != nil {
-			log.Error().Str("file", filename).Err(err).Msg("Unable to create file")
-		}
+	if app.Opts.HTTPDashboard {
+		mux.HandleFunc("/dashboard", app.handleDashboard)
+	}
 
-		defer out.Close()
+	return mux
+}
 
-		_, err = io.Copy(out, r.Body)
+// resolveVideoFile - maps requested file name to a path within the video directory
+// Only plain file names prefixed by UID of a known baby are accepted (ie. {uid}.m3u8, {uid}-1.ts)
+func resolveVideoFile(videoDir string, babies []baby.Baby, name string) (string, bool) {
+	if name == "" || strings.ContainsAny(name, "/\\\x00") || strings.Contains(name, "..") {
+		return "", false
+	}
 
-		if err != nil {
-			log.Error().Str("file", filename).Err(err).Msg("Unable to save received log file")
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+	for _, babyInfo := range babies {
+		if rest := strings.TrimPrefix(name, babyInfo.UID); rest != name && (strings.HasPrefix(rest, ".") || strings.HasPrefix(rest, "-")) {
+			return filepath.Join(videoDir, name), true
 		}
+	}
 
-		w.WriteHeader(http.StatusNoContent)
-	})
-
-	log.Info().Int("port", port).Msg("Starting HTTP server")
-	http.ListenAndServe(fmt.Sprintf(":%v", port), nil)
+	return "", false
 }
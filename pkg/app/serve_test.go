@@ -0,0 +1,209 @@
+package app
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+	"gitlab.com/adam.stanek/nanit/pkg/client"
+	"gitlab.com/adam.stanek/nanit/pkg/session"
+)
+
+func newTestHTTPHandler(t *testing.T) http.Handler {
+	baseDir, err := ioutil.TempDir("", "nanit-serve-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(baseDir) })
+
+	videoDir := filepath.Join(baseDir, "video")
+	os.MkdirAll(videoDir, 0755)
+	ioutil.WriteFile(filepath.Join(videoDir, "baby1.m3u8"), []byte("#EXTM3U"), 0644)
+	ioutil.WriteFile(filepath.Join(videoDir, "other.m3u8"), []byte("#EXTM3U"), 0644)
+	ioutil.WriteFile(filepath.Join(baseDir, "session.json"), []byte("secret"), 0644)
+
+	sessionStore := session.NewSessionStore()
+	sessionStore.Session.Babies = []baby.Baby{{UID: "baby1", Name: "Baby", CameraUID: "cam1"}}
+
+	app := &App{
+		Opts:              Opts{DataDirectories: DataDirectories{BaseDir: baseDir, VideoDir: videoDir}},
+		SessionStore:      sessionStore,
+		BabyStateManager:  baby.NewStateManager(),
+		websocketManagers: make(map[string]*client.WebsocketConnectionManager),
+		previews:          make(map[string]previewImage),
+	}
+
+	return app.newHTTPHandler()
+}
+
+func TestHTTPRoutesRejectUnknownAndTraversal(t *testing.T) {
+	handler := newTestHTTPHandler(t)
+
+	tests := []struct {
+		path       string
+		statusCode int
+	}{
+		{"/video/baby1.m3u8", http.StatusOK},
+		{"/video/other.m3u8", http.StatusNotFound},
+		{"/video/../session.json", http.StatusMovedPermanently}, // cleaned by the mux, never served
+		{"/video/baby1%5c..%5csession.json", http.StatusNotFound},
+		{"/api/babies/baby1", http.StatusOK},
+		{"/api/babies/baby1/", http.StatusOK},
+		{"/api/babies/unknown", http.StatusNotFound},
+		{"/version", http.StatusOK},
+		{"/api/babies/baby1%2e%2e", http.StatusBadRequest},
+		{"/api/babies/baby1%00", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+			assert.Equal(t, test.statusCode, rec.Code)
+			assert.NotContains(t, rec.Body.String(), "secret")
+		})
+	}
+}
+
+func TestResolveVideoFile(t *testing.T) {
+	babies := []baby.Baby{{UID: "baby1"}}
+
+	tests := []struct {
+		name     string
+		expected string
+		ok       bool
+	}{
+		{"baby1.m3u8", "/data/video/baby1.m3u8", true},
+		{"baby1-12.ts", "/data/video/baby1-12.ts", true},
+		{"baby10.m3u8", "", false},
+		{"other.m3u8", "", false},
+		{"", "", false},
+		{"../session.json", "", false},
+		{"baby1/../../session.json", "", false},
+		{"baby1..m3u8", "", false},
+		{"baby1.\\..\\session.json", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filename, ok := resolveVideoFile("/data/video", babies, test.name)
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.expected, filename)
+		})
+	}
+}
+
+func TestPauseSensorProcessing(t *testing.T) {
+	app := &App{
+		Opts:           Opts{HTTPAdminToken: "secret"},
+		SessionStore:   session.NewSessionStore(),
+		websocketConns: make(map[string]*client.WebsocketConnection),
+	}
+
+	handler := app.newHTTPHandler()
+	request := func(method string, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/api/sensors/pause").Code)
+	assert.True(t, app.isSensorProcessingPaused())
+	assert.Contains(t, request(http.MethodGet, "/healthz").Body.String(), `"sensor_processing_paused":true`)
+
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/api/sensors/resume").Code)
+	assert.False(t, app.isSensorProcessingPaused())
+	assert.Equal(t, http.StatusMethodNotAllowed, request(http.MethodGet, "/api/sensors/pause").Code)
+}
+
+func TestDashboard(t *testing.T) {
+	sessionStore := session.NewSessionStore()
+	sessionStore.Session.Babies = []baby.Baby{{UID: "baby1", Name: "<Baby>", CameraUID: "cam1"}}
+
+	app := &App{Opts: Opts{HTTPDashboard: true, RTMP: &RTMPOpts{}}, SessionStore: sessionStore}
+
+	rec := httptest.NewRecorder()
+	app.newHTTPHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dashboard", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `data-uid="baby1"`)
+	assert.Contains(t, rec.Body.String(), "&lt;Baby&gt;")
+	assert.Contains(t, rec.Body.String(), `"snapshot?w=640"`)
+}
+
+func TestOverview(t *testing.T) {
+	handler := newTestHTTPHandler(t)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/overview", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"schema_version":1`)
+	assert.Contains(t, rec.Body.String(), `"uid":"baby1"`)
+	assert.Contains(t, rec.Body.String(), `"camera_uid":"cam1"`)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/overview", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestStreamURLs(t *testing.T) {
+	sessionStore := session.NewSessionStore()
+	sessionStore.Session.Babies = []baby.Baby{{UID: "baby1", Name: "Baby", CameraUID: "cam1"}}
+
+	app := &App{
+		Opts:             Opts{RTMP: &RTMPOpts{PublicAddr: "192.168.1.2:1935"}},
+		SessionStore:     sessionStore,
+		BabyStateManager: baby.NewStateManager(),
+	}
+
+	app.BabyStateManager.Update("baby1", *baby.NewState().SetStreamState(baby.StreamState_Alive))
+
+	rec := httptest.NewRecorder()
+	app.newHTTPHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/babies/baby1/stream", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"baby_uid":"baby1","streams":[{"camera_uid":"cam1","type":"rtmp","url":"rtmp://192.168.1.2:1935/local/baby1","available":true}]}`, rec.Body.String())
+}
+
+func TestBabyReconnect(t *testing.T) {
+	sessionStore := session.NewSessionStore()
+	sessionStore.Session.Babies = []baby.Baby{{UID: "baby1", CameraUID: "cam1"}, {UID: "baby2", CameraUID: "cam2"}}
+
+	app := &App{
+		Opts:              Opts{HTTPAdminToken: "secret"},
+		SessionStore:      sessionStore,
+		BabyStateManager:  baby.NewStateManager(),
+		websocketManagers: make(map[string]*client.WebsocketConnectionManager),
+	}
+
+	// Manager exists but the websocket is not connected yet
+	app.websocketManagers["baby2"] = client.NewWebsocketConnectionManager("baby2", "cam2", sessionStore, nil, app.BabyStateManager)
+
+	handler := app.newHTTPHandler()
+	request := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := request("/api/babies/baby1/reconnect")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Contains(t, rec.Body.String(), "No websocket connection manager for baby")
+
+	assert.Equal(t, http.StatusConflict, request("/api/babies/baby2/reconnect").Code)
+	assert.Equal(t, http.StatusBadRequest, request("/api/babies/baby2/reconnect?wait=maybe").Code)
+
+	assert.EqualError(t, app.handleMQTTCommand("baby1", "reconnect"), "no websocket connection manager for baby")
+}
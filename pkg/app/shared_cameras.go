@@ -0,0 +1,85 @@
+package app
+
+import (
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+const (
+	// SharedCameras_Share - camera shared by multiple babies has single connection, its state is copied to all of them
+	SharedCameras_Share = "share"
+	// SharedCameras_Separate - every baby has its own connection even if the camera is shared (conflicting streaming requests)
+	SharedCameras_Separate = "separate"
+)
+
+// getSharedCameraOwners - returns owner state key for the state keys of the cameras which are shared with another baby
+// Owner is the first baby with the camera, it holds the connection and the stream
+func getSharedCameraOwners(babies []baby.Baby) map[string]string {
+	ownerByCamera := make(map[string]string)
+	owners := make(map[string]string)
+
+	for _, babyInfo := range babies {
+		for _, cameraUID := range babyInfo.GetCameraUIDs() {
+			stateKey := babyInfo.GetStateKey(cameraUID)
+			if owner, ok := ownerByCamera[cameraUID]; ok {
+				owners[stateKey] = owner
+			} else {
+				ownerByCamera[cameraUID] = stateKey
+			}
+		}
+	}
+
+	return owners
+}
+
+// getStreamKey - returns the state key which holds the connection and the stream of the camera
+func (app *App) getStreamKey(stateKey string) string {
+	if owner, ok := app.sharedCameraOwners[stateKey]; ok {
+		return owner
+	}
+
+	return stateKey
+}
+
+// getSharedCameraFollowers - returns state keys of the babies sharing the camera held by given state key
+func (app *App) getSharedCameraFollowers(stateKey string) []string {
+	var followers []string
+	for follower, owner := range app.sharedCameraOwners {
+		if owner == stateKey {
+			followers = append(followers, follower)
+		}
+	}
+
+	return followers
+}
+
+// followSharedCamera - copies the state of the camera owner to the follower until the context is cancelled
+func (app *App) followSharedCamera(stateKey string, ctx utils.GracefulContext) {
+	owner := app.getStreamKey(stateKey)
+	log.Info().Str("baby_uid", stateKey).Str("owner", owner).Msg("Camera is shared with another baby, reusing its connection")
+
+	unsubscribe := app.BabyStateManager.Subscribe(func(updatedBabyUID string, state baby.State) {
+		if updatedBabyUID == owner {
+			app.BabyStateManager.Update(stateKey, state)
+		}
+	})
+
+	app.BabyStateManager.Update(stateKey, *app.BabyStateManager.GetBabyState(owner))
+
+	<-ctx.Done()
+	unsubscribe()
+}
+
+// isKnownStreamKey - returns true if the key belongs to a camera of the account which streams on its own (not a follower)
+func (app *App) isKnownStreamKey(streamKey string) bool {
+	for _, babyInfo := range app.SessionStore.Session.Babies {
+		for _, cameraUID := range babyInfo.GetCameraUIDs() {
+			stateKey := babyInfo.GetStateKey(cameraUID)
+			if stateKey == streamKey && app.getStreamKey(stateKey) == stateKey {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,37 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+	"gitlab.com/adam.stanek/nanit/pkg/session"
+)
+
+func TestGetSharedCameraOwners(t *testing.T) {
+	babies := []baby.Baby{
+		{UID: "twin1", CameraUID: "cam1"},
+		{UID: "twin2", CameraUID: "cam1", Cameras: []baby.Camera{{UID: "cam2"}}},
+		{UID: "other", CameraUID: "cam3", Cameras: []baby.Camera{{UID: "cam2"}}},
+	}
+
+	assert.Equal(t, map[string]string{
+		"twin2":      "twin1",
+		"other-cam2": "twin2-cam2",
+	}, getSharedCameraOwners(babies))
+
+	app := &App{
+		sharedCameraOwners: getSharedCameraOwners(babies),
+		SessionStore:       &session.Store{Session: &session.Session{Babies: babies}},
+	}
+
+	assert.Equal(t, "twin1", app.getStreamKey("twin2"))
+	assert.Equal(t, "other", app.getStreamKey("other"))
+	assert.Equal(t, []string{"twin2"}, app.getSharedCameraFollowers("twin1"))
+
+	// Only the stream owners publish
+	assert.True(t, app.isKnownStreamKey("twin1"))
+	assert.True(t, app.isKnownStreamKey("twin2-cam2"))
+	assert.False(t, app.isKnownStreamKey("twin2"))
+	assert.False(t, app.isKnownStreamKey("stale"))
+}
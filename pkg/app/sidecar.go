@@ -0,0 +1,67 @@
+package app
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// captureMetadata - content of the JSON sidecar written next to the captured image
+type captureMetadata struct {
+	BabyUID     string    `json:"baby_uid"`
+	BabyName    string    `json:"baby_name,omitempty"`
+	CameraUID   string    `json:"camera_uid,omitempty"`
+	CapturedAt  time.Time `json:"captured_at"`
+	Trigger     string    `json:"trigger"`
+	Temperature *float64  `json:"temperature,omitempty"`
+	Humidity    *float64  `json:"humidity,omitempty"`
+}
+
+// getCaptureMetadata - returns metadata of the capture of the camera tracked under stateKey
+func (app *App) getCaptureMetadata(stateKey string, trigger string, capturedAt time.Time) captureMetadata {
+	metadata := captureMetadata{
+		BabyUID:    stateKey,
+		CapturedAt: capturedAt,
+		Trigger:    trigger,
+	}
+
+	for _, babyInfo := range app.SessionStore.Session.Babies {
+		for _, cameraUID := range babyInfo.GetCameraUIDs() {
+			if babyInfo.GetStateKey(cameraUID) == stateKey {
+				metadata.BabyUID = babyInfo.UID
+				metadata.BabyName = babyInfo.Name
+				metadata.CameraUID = cameraUID
+			}
+		}
+	}
+
+	state := app.BabyStateManager.GetBabyState(stateKey)
+	if state.TemperatureMilli != nil {
+		temperature := state.GetTemperature()
+		metadata.Temperature = &temperature
+	}
+
+	if state.HumidityMilli != nil {
+		humidity := state.GetHumidity()
+		metadata.Humidity = &humidity
+	}
+
+	return metadata
+}
+
+// getSidecarFilename - returns filename of the sidecar of the image (same name, .json extension)
+func getSidecarFilename(imageFilename string) string {
+	return strings.TrimSuffix(imageFilename, filepath.Ext(imageFilename)) + ".json"
+}
+
+// writeSidecar - writes the metadata next to the image
+func writeSidecar(imageFilename string, metadata captureMetadata) error {
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(getSidecarFilename(imageFilename), data, 0644)
+}
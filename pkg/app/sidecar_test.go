@@ -0,0 +1,46 @@
+package app
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+	"gitlab.com/adam.stanek/nanit/pkg/session"
+)
+
+func TestWriteSidecar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nanit-sidecar-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	app := &App{
+		SessionStore:     &session.Store{Session: &session.Session{Babies: []baby.Baby{{UID: "baby1", Name: "Adam", CameraUID: "cam1", Cameras: []baby.Camera{{UID: "cam2"}}}}}},
+		BabyStateManager: baby.NewStateManager(),
+	}
+
+	app.BabyStateManager.Update("baby1-cam2", *baby.NewState().SetTemperatureMilli(22500))
+
+	capturedAt := time.Date(2021, 1, 31, 23, 59, 59, 0, time.UTC)
+	imageFilename := filepath.Join(dir, "20210131-235959.jpg")
+	require.NoError(t, writeSidecar(imageFilename, app.getCaptureMetadata("baby1-cam2", "timelapse", capturedAt)))
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "20210131-235959.json"))
+	require.NoError(t, err)
+
+	var metadata map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &metadata))
+	assert.Equal(t, map[string]interface{}{
+		"baby_uid":    "baby1",
+		"baby_name":   "Adam",
+		"camera_uid":  "cam2",
+		"captured_at": "2021-01-31T23:59:59Z",
+		"trigger":     "timelapse",
+		"temperature": 22.5,
+	}, metadata)
+}
@@ -0,0 +1,171 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+// newFFmpegCommand - ffmpeg runs with C locale, so that its messages (included in the errors and logs) are not localized
+func newFFmpegCommand(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Env = append(os.Environ(), "LC_ALL=C", "LANG=C")
+	return cmd
+}
+
+// captureSnapshot - grabs a single frame of the stream using ffmpeg and writes it to a file
+// Optional extra args are passed to ffmpeg as output options (ie. filters)
+func captureSnapshot(streamURL string, outputFile string, timeout time.Duration, extraArgs ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	args := []string{"-y", "-loglevel", "error", "-i", streamURL, "-frames:v", "1"}
+	args = append(args, extraArgs...)
+	args = append(args, outputFile)
+	log.Debug().Str("cmd", utils.RedactCommand("ffmpeg", args)).Msg("Capturing snapshot")
+
+	out, err := newFFmpegCommand(ctx, args...).CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("Snapshot capture timed out after %v", timeout)
+	} else if err != nil {
+		return fmt.Errorf("Snapshot capture failed: %v: %v", err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// captureSnapshotBytes - same as captureSnapshot, but returns the image data instead of writing it to a file
+func captureSnapshotBytes(streamURL string, timeout time.Duration, extraArgs ...string) ([]byte, error) {
+	f, err := ioutil.TempFile("", "nanit-snapshot-*.jpg")
+	if err != nil {
+		return nil, err
+	}
+
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if err := captureSnapshot(streamURL, f.Name(), timeout, extraArgs...); err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadFile(f.Name())
+}
+
+// checkStreamCapturable - returns error if the frame cannot be grabbed from the baby's stream
+func (app *App) checkStreamCapturable(babyUID string) error {
+	state := app.BabyStateManager.GetBabyState(babyUID)
+	if state.GetStreamState() != baby.StreamState_Alive {
+		return errors.New("Stream is not alive")
+	}
+
+	// Audio-only stream (ffmpeg would wait for a video frame until the timeout)
+	if state.StreamHasVideo != nil && !*state.StreamHasVideo {
+		return errors.New("Stream has no video")
+	}
+
+	return nil
+}
+
+// captureNotificationSnapshot - snapshot provider for the notifier
+func (app *App) captureNotificationSnapshot(babyUID string) ([]byte, error) {
+	if err := app.checkStreamCapturable(babyUID); err != nil {
+		return nil, err
+	}
+
+	return captureSnapshotBytes(app.getLocalPlaybackURL(babyUID), notificationSnapshotTimeout)
+}
+
+// notificationSnapshotTimeout - notifications should not be delayed for too long
+const notificationSnapshotTimeout = 15 * time.Second
+
+// captureHTTPSnapshot - snapshot for the HTTP API (use through the snapshot cache)
+func (app *App) captureHTTPSnapshot(babyUID string, size snapshotSize) ([]byte, error) {
+	if err := app.checkStreamCapturable(babyUID); err != nil {
+		return nil, err
+	}
+
+	return captureSnapshotBytes(app.getLocalPlaybackURL(babyUID), httpSnapshotTimeout, size.ffmpegArgs()...)
+}
+
+// snapshotSize - requested dimensions of the snapshot (0 = not specified)
+type snapshotSize struct {
+	Width  int
+	Height int
+}
+
+// parseSnapshotSize - reads width and height query parameters, values over the maximums are clamped (0 = no maximum)
+// Note: missing dimension is bounded by its maximum so that the other one cannot be used to get around it
+func parseSnapshotSize(query url.Values, maxWidth int, maxHeight int) (snapshotSize, error) {
+	parse := func(name string, max int) (int, error) {
+		str := query.Get(name)
+		if str == "" {
+			return 0, nil
+		}
+
+		value, err := strconv.Atoi(str)
+		if err != nil || value <= 0 {
+			return 0, fmt.Errorf("Invalid %v %q, expected positive number of pixels", name, str)
+		}
+
+		if max > 0 && value > max {
+			value = max
+		}
+
+		return value, nil
+	}
+
+	width, err := parse("width", maxWidth)
+	if err != nil {
+		return snapshotSize{}, err
+	}
+
+	height, err := parse("height", maxHeight)
+	if err != nil {
+		return snapshotSize{}, err
+	}
+
+	if width > 0 && height == 0 {
+		height = maxHeight
+	} else if height > 0 && width == 0 {
+		width = maxWidth
+	}
+
+	return snapshotSize{Width: width, Height: height}, nil
+}
+
+// String - used as a part of the snapshot cache key
+func (size snapshotSize) String() string {
+	return fmt.Sprintf("%vx%v", size.Width, size.Height)
+}
+
+// ffmpegArgs - fits the frame into the requested size, keeps the aspect ratio and never upscales
+func (size snapshotSize) ffmpegArgs() []string {
+	switch {
+	case size.Width > 0 && size.Height > 0:
+		return []string{"-vf", fmt.Sprintf("scale='min(iw,%v)':'min(ih,%v)':force_original_aspect_ratio=decrease", size.Width, size.Height)}
+	case size.Width > 0:
+		return []string{"-vf", fmt.Sprintf("scale='min(iw,%v)':-2", size.Width)}
+	case size.Height > 0:
+		return []string{"-vf", fmt.Sprintf("scale=-2:'min(ih,%v)'", size.Height)}
+	default:
+		return nil
+	}
+}
+
+// httpSnapshotTimeout - HTTP clients should not wait for too long
+const httpSnapshotTimeout = 15 * time.Second
+
+// getLocalPlaybackURL - URL of the baby's stream on the local RTMP server (as seen from this machine)
+func (app *App) getLocalPlaybackURL(babyUID string) string {
+	return fmt.Sprintf("rtmp://127.0.0.1%v/local/%v", app.Opts.RTMP.ListenAddr, app.getStreamKey(babyUID))
+}
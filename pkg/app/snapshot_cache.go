@@ -0,0 +1,75 @@
+package app
+
+import (
+	"sync"
+	"time"
+)
+
+// snapshotCache - coalesces concurrent captures of the same key and keeps the result for a while
+// Note: prevents spawning ffmpeg for every viewer when a dashboard refreshes the thumbnails
+type snapshotCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]*snapshotCacheEntry
+}
+
+type snapshotCacheEntry struct {
+	doneC      chan struct{}
+	data       []byte
+	err        error
+	capturedAt time.Time
+}
+
+func newSnapshotCache(ttl time.Duration) *snapshotCache {
+	return &snapshotCache{
+		ttl:     ttl,
+		entries: make(map[string]*snapshotCacheEntry),
+	}
+}
+
+// get - returns cached snapshot if it is not older than TTL, otherwise captures a new one
+// Callers arriving while the capture is in progress wait for it and share its result (including an error)
+func (cache *snapshotCache) get(key string, capture func() ([]byte, error)) ([]byte, time.Time, error) {
+	cache.mu.Lock()
+	entry, ok := cache.entries[key]
+	if ok {
+		select {
+		case <-entry.doneC:
+			if entry.err != nil || time.Since(entry.capturedAt) > cache.ttl {
+				ok = false
+			}
+		default:
+			// Capture in progress
+		}
+	}
+
+	if !ok {
+		cache.evictExpired()
+
+		entry = &snapshotCacheEntry{doneC: make(chan struct{})}
+		cache.entries[key] = entry
+		cache.mu.Unlock()
+
+		entry.data, entry.err = capture()
+		entry.capturedAt = time.Now()
+		close(entry.doneC)
+	} else {
+		cache.mu.Unlock()
+		<-entry.doneC
+	}
+
+	return entry.data, entry.capturedAt, entry.err
+}
+
+// evictExpired - removes finished entries older than TTL (keys vary with the requested size), must be called with mu held
+func (cache *snapshotCache) evictExpired() {
+	for key, entry := range cache.entries {
+		select {
+		case <-entry.doneC:
+			if time.Since(entry.capturedAt) > cache.ttl {
+				delete(cache.entries, key)
+			}
+		default:
+		}
+	}
+}
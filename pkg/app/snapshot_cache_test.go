@@ -0,0 +1,63 @@
+package app
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotCacheCoalescesConcurrentCaptures(t *testing.T) {
+	cache := newSnapshotCache(time.Minute)
+
+	var numCaptures int32
+	capture := func() ([]byte, error) {
+		atomic.AddInt32(&numCaptures, 1)
+		time.Sleep(50 * time.Millisecond)
+		return []byte("jpeg"), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, _, err := cache.get("baby1", capture)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("jpeg"), data)
+		}()
+	}
+
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&numCaptures))
+
+	// Served from the cache within TTL
+	cache.get("baby1", capture)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&numCaptures))
+
+	// Other keys are captured separately
+	cache.get("baby2", capture)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&numCaptures))
+}
+
+func TestSnapshotCacheExpiresAndRetriesErrors(t *testing.T) {
+	cache := newSnapshotCache(0)
+
+	var numCaptures int32
+	_, _, err := cache.get("baby1", func() ([]byte, error) {
+		atomic.AddInt32(&numCaptures, 1)
+		return nil, errors.New("Stream is not alive")
+	})
+	assert.Error(t, err)
+
+	data, _, err := cache.get("baby1", func() ([]byte, error) {
+		atomic.AddInt32(&numCaptures, 1)
+		return []byte("jpeg"), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("jpeg"), data)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&numCaptures))
+}
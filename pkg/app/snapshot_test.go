@@ -0,0 +1,41 @@
+package app
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSnapshotSize(t *testing.T) {
+	tests := []struct {
+		query        string
+		expected     snapshotSize
+		expectedArgs []string
+	}{
+		{"", snapshotSize{}, nil},
+		{"width=640&height=360", snapshotSize{640, 360}, []string{"-vf", "scale='min(iw,640)':'min(ih,360)':force_original_aspect_ratio=decrease"}},
+		{"width=640", snapshotSize{640, 1080}, []string{"-vf", "scale='min(iw,640)':'min(ih,1080)':force_original_aspect_ratio=decrease"}},
+		{"width=10000&height=10000", snapshotSize{1920, 1080}, []string{"-vf", "scale='min(iw,1920)':'min(ih,1080)':force_original_aspect_ratio=decrease"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			query, _ := url.ParseQuery(test.query)
+			size, err := parseSnapshotSize(query, 1920, 1080)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, size)
+			assert.Equal(t, test.expectedArgs, size.ffmpegArgs())
+		})
+	}
+
+	size, err := parseSnapshotSize(url.Values{"height": {"360"}}, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"-vf", "scale=-2:'min(ih,360)'"}, size.ffmpegArgs())
+
+	for _, invalid := range []string{"width=abc", "width=-1", "height=0"} {
+		query, _ := url.ParseQuery(invalid)
+		_, err := parseSnapshotSize(query, 1920, 1080)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,101 @@
+package app
+
+import (
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+// stateEventsLog - separate component so that the transitions can be logged (or silenced) regardless of the app level
+var stateEventsLog = utils.NewComponentLogger("state")
+
+// stateTransitionEvent - stable name of the event, log based alerting keys off it
+const stateTransitionEvent = "state_transition"
+
+// stateTransition - change of the connection or stream state of the baby
+type stateTransition struct {
+	State  string
+	From   string
+	To     string
+	Reason string
+}
+
+// getStateTransitions - compares the update with the previous state, returns the connection and stream transitions
+func getStateTransitions(prev baby.State, update baby.State) []stateTransition {
+	var transitions []stateTransition
+
+	if update.IsWebsocketAlive != nil && (prev.IsWebsocketAlive == nil || *prev.IsWebsocketAlive != *update.IsWebsocketAlive) {
+		transition := stateTransition{State: "connection", From: getConnectionStateName(prev.IsWebsocketAlive), To: getConnectionStateName(update.IsWebsocketAlive)}
+		if *update.IsWebsocketAlive {
+			transition.Reason = "websocket_connected"
+		} else {
+			transition.Reason = "websocket_lost"
+		}
+
+		transitions = append(transitions, transition)
+	}
+
+	if update.StreamState != nil && prev.GetStreamState() != *update.StreamState {
+		// Stream request state tells whether the cam was asked to stream, failed to or streams elsewhere
+		streamRequestState := prev.GetStreamRequestState()
+		if update.StreamRequestState != nil {
+			streamRequestState = *update.StreamRequestState
+		}
+
+		transitions = append(transitions, stateTransition{
+			State:  "stream",
+			From:   getStreamStateName(prev.GetStreamState()),
+			To:     getStreamStateName(*update.StreamState),
+			Reason: getStreamRequestStateName(streamRequestState),
+		})
+	}
+
+	return transitions
+}
+
+func getConnectionStateName(isWebsocketAlive *bool) string {
+	if isWebsocketAlive == nil {
+		return "unknown"
+	} else if *isWebsocketAlive {
+		return "online"
+	}
+
+	return "offline"
+}
+
+func getStreamRequestStateName(streamRequestState baby.StreamRequestState) string {
+	switch streamRequestState {
+	case baby.StreamRequestState_Requested:
+		return "stream_requested"
+	case baby.StreamRequestState_RequestFailed:
+		return "stream_request_failed"
+	case baby.StreamRequestState_AlreadyStreaming:
+		return "already_streaming"
+	default:
+		return "stream_not_requested"
+	}
+}
+
+// runStateEventsLog - logs the connection and stream transitions as structured events until the context is done
+func (app *App) runStateEventsLog(ctx utils.GracefulContext) {
+	// Note: subscriber callback is never called concurrently, no locking needed
+	states := make(map[string]baby.State)
+
+	unsubscribe := app.BabyStateManager.Subscribe(func(babyUID string, update baby.State) {
+		prev := states[babyUID]
+		for _, transition := range getStateTransitions(prev, update) {
+			stateEventsLog.Info().
+				Str("event", stateTransitionEvent).
+				Str("baby_uid", babyUID).
+				Str("state", transition.State).
+				Str("from_state", transition.From).
+				Str("to_state", transition.To).
+				Str("reason", transition.Reason).
+				Msg("State changed")
+		}
+
+		states[babyUID] = *prev.Merge(&update)
+	})
+
+	<-ctx.Done()
+	unsubscribe()
+}
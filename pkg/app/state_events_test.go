@@ -0,0 +1,23 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+)
+
+func TestGetStateTransitions(t *testing.T) {
+	prev := *baby.NewState().SetWebsocketAlive(true).SetStreamRequestState(baby.StreamRequestState_Requested).SetStreamState(baby.StreamState_Alive)
+
+	assert.Empty(t, getStateTransitions(prev, *baby.NewState().SetWebsocketAlive(true).SetTemperatureMilli(22000)))
+
+	assert.Equal(t, []stateTransition{
+		{State: "connection", From: "online", To: "offline", Reason: "websocket_lost"},
+		{State: "stream", From: "alive", To: "unhealthy", Reason: "stream_requested"},
+	}, getStateTransitions(prev, *baby.NewState().SetWebsocketAlive(false).SetStreamState(baby.StreamState_Unhealthy)))
+
+	assert.Equal(t, []stateTransition{
+		{State: "connection", From: "unknown", To: "online", Reason: "websocket_connected"},
+	}, getStateTransitions(baby.State{}, *baby.NewState().SetWebsocketAlive(true)))
+}
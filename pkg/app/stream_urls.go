@@ -0,0 +1,74 @@
+package app
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+const (
+	// StreamType_RTMP - live stream relayed by the integrated RTMP server
+	StreamType_RTMP = "rtmp"
+	// StreamType_HLSRewind - HLS playlist of the last minutes of the stream (see RewindOpts)
+	StreamType_HLSRewind = "hls_rewind"
+)
+
+type streamURLPayload struct {
+	CameraUID string `json:"camera_uid"`
+	Type      string `json:"type"`
+	URL       string `json:"url"`
+
+	// Available - stream can be played right now (RTMP stream is alive, rewind buffer is being recorded)
+	Available bool `json:"available"`
+}
+
+type streamURLsPayload struct {
+	BabyUID string             `json:"baby_uid"`
+	Streams []streamURLPayload `json:"streams"`
+}
+
+// getStreamURLs - returns URLs of the enabled outputs for all the cameras of the baby
+// Note: relative URLs are served by our HTTP server
+func (app *App) getStreamURLs(babyInfo baby.Baby) streamURLsPayload {
+	payload := streamURLsPayload{BabyUID: babyInfo.UID, Streams: []streamURLPayload{}}
+
+	for _, cameraUID := range babyInfo.GetCameraUIDs() {
+		stateKey := babyInfo.GetStateKey(cameraUID)
+		isAlive := app.BabyStateManager.GetBabyState(stateKey).GetStreamState() == baby.StreamState_Alive
+
+		// Shared camera is published only once, under the key of its owner
+		payload.Streams = append(payload.Streams, streamURLPayload{
+			CameraUID: cameraUID,
+			Type:      StreamType_RTMP,
+			URL:       utils.RedactURL(app.getLocalStreamURL(app.getStreamKey(stateKey))),
+			Available: isAlive,
+		})
+
+		if app.Opts.Rewind != nil {
+			playlistName := getRewindPlaylistName(stateKey)
+			_, err := os.Stat(filepath.Join(app.Opts.DataDirectories.VideoDir, playlistName))
+
+			payload.Streams = append(payload.Streams, streamURLPayload{
+				CameraUID: cameraUID,
+				Type:      StreamType_HLSRewind,
+				URL:       "/video/" + playlistName,
+				Available: err == nil,
+			})
+		}
+	}
+
+	return payload
+}
+
+// GET /api/babies/{uid}/stream - playable stream URLs, so that external players do not have to construct them
+func (app *App) handleAPIBabyStream(w http.ResponseWriter, babyInfo baby.Baby) {
+	if app.Opts.RTMP == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Streaming requires RTMP server to be enabled"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, app.getStreamURLs(babyInfo))
+}
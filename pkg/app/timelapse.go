@@ -0,0 +1,91 @@
+package app
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+// timelapseCaptureTimeout - how long can ffmpeg take to grab the frame
+const timelapseCaptureTimeout = 30 * time.Second
+
+// runTimelapse - periodically captures a frame of the stream (only while it is alive) into the timelapse directory
+func (app *App) runTimelapse(babyUID string, ctx utils.GracefulContext) {
+	opts := app.Opts.Timelapse
+	dir := filepath.Join(opts.Dir, babyUID)
+	sublog := log.With().Str("baby_uid", babyUID).Str("dir", dir).Logger()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		sublog.Error().Err(err).Msg("Unable to create timelapse directory, timelapse disabled")
+		return
+	}
+
+	minStreamAge := opts.getMinStreamAge(babyUID)
+
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := app.checkStreamCapturable(babyUID); err != nil {
+				sublog.Trace().Err(err).Msg("Skipping timelapse frame")
+				continue
+			}
+
+			babyState := app.BabyStateManager.GetBabyState(babyUID)
+			// Avoids spawning ffmpeg for streams which come up just to die again
+			if aliveFor := babyState.GetStreamAliveDuration(now); aliveFor < minStreamAge {
+				sublog.Trace().Dur("alive_for", aliveFor).Msg("Stream is not alive long enough, skipping timelapse frame")
+				continue
+			}
+
+			filename := filepath.Join(dir, fmt.Sprintf("%v.jpg", now.Format(opts.getFilenameFormat())))
+			if err := captureSnapshot(app.getLocalPlaybackURL(babyUID), filename, timelapseCaptureTimeout); err != nil {
+				sublog.Warn().Err(err).Msg("Unable to capture timelapse frame")
+			} else {
+				sublog.Debug().Str("file", filename).Msg("Timelapse frame captured")
+
+				if app.Opts.CaptureSidecars {
+					if err := writeSidecar(filename, app.getCaptureMetadata(babyUID, "timelapse", now)); err != nil {
+						sublog.Warn().Err(err).Msg("Unable to write timelapse frame sidecar")
+					}
+				}
+			}
+
+			if opts.Retention > 0 {
+				pruneFiles(dir, ".jpg", opts.Retention)
+				pruneFiles(dir, ".json", opts.Retention)
+			}
+		}
+	}
+}
+
+// pruneFiles - removes files with given extension older than maxAge
+func pruneFiles(dir string, ext string, maxAge time.Duration) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Warn().Str("dir", dir).Err(err).Msg("Unable to list directory for pruning")
+		return
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ext) || time.Since(f.ModTime()) <= maxAge {
+			continue
+		}
+
+		filename := filepath.Join(dir, f.Name())
+		if err := os.Remove(filename); err != nil {
+			log.Warn().Str("file", filename).Err(err).Msg("Unable to remove old file")
+		} else {
+			log.Trace().Str("file", filename).Msg("Old file removed")
+		}
+	}
+}
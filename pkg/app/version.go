@@ -0,0 +1,26 @@
+package app
+
+import (
+	"net/http"
+)
+
+// BuildInfo - identification of the running build
+type BuildInfo struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"git_commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// String - short human readable version (ie. 1.2.0 (a1b2c3d))
+func (info BuildInfo) String() string {
+	if info.GitCommit != "" {
+		return info.Version + " (" + info.GitCommit + ")"
+	}
+
+	return info.Version
+}
+
+func (app *App) handleVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, app.Opts.BuildInfo)
+}
@@ -1,31 +1,187 @@
 package app
 
 import (
+	"errors"
 	"time"
 
-	"github.com/rs/zerolog/log"
 	"gitlab.com/adam.stanek/nanit/pkg/baby"
 	"gitlab.com/adam.stanek/nanit/pkg/client"
 	"gitlab.com/adam.stanek/nanit/pkg/utils"
 )
 
-func processSensorData(babyUID string, sensorData []*client.SensorData, stateManager *baby.StateManager) {
+// stateSink - receiver of the baby state updates (implemented by baby.StateManager)
+type stateSink interface {
+	Update(babyUID string, stateUpdate baby.State)
+	ForceUpdate(babyUID string, stateUpdate baby.State)
+}
+
+// maxSensorDataSets - cam sends one set per sensor type (6 of them), anything well above that is a protocol anomaly
+const maxSensorDataSets = 32
+
+// sensorQueueSize - how many sensor messages can wait for processing before the new ones are dropped
+const sensorQueueSize = 16
+
+// sensorQueue - processes the sensor messages outside of the websocket message handler
+// Slow state downstreams (publishing, persisting) therefore cannot stall reading of the new messages
+type sensorQueue struct {
+	babyUID string
+	jobsC   chan func()
+}
+
+func newSensorQueue(babyUID string) *sensorQueue {
+	return &sensorQueue{
+		babyUID: babyUID,
+		jobsC:   make(chan func(), sensorQueueSize),
+	}
+}
+
+// push - queues the processing, returns false if the queue is full and the processing has been dropped
+func (queue *sensorQueue) push(job func()) bool {
+	select {
+	case queue.jobsC <- job:
+		return true
+	default:
+		log.Warn().Str("baby_uid", queue.babyUID).Int("queue_size", sensorQueueSize).Msg("Sensor processing is falling behind, dropping sensor data")
+		return false
+	}
+}
+
+// run - processes the queued messages until the context is cancelled (pending ones are dropped)
+func (queue *sensorQueue) run(ctx utils.GracefulContext) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-queue.jobsC:
+			job()
+		}
+	}
+}
+
+func processSensorData(babyUID string, sensorData []*client.SensorData, opts SensorOpts, sink stateSink) {
+	if len(sensorData) > maxSensorDataSets {
+		log.Warn().Str("baby_uid", babyUID).Int("num_sets", len(sensorData)).Int("max_sets", maxSensorDataSets).Msg("Unexpectedly large sensor data payload, processing only the first sets")
+		sensorData = sensorData[:maxSensorDataSets]
+	}
+
 	// Parse sensor update
+	// Note: readings without a value are skipped, cam does not send them on its own, but it is not guaranteed
+	// Note: implausible readings are skipped too, so the last good value is kept in the state
 	stateUpdate := baby.State{}
 	for _, sensorDataSet := range sensorData {
-		if *sensorDataSet.SensorType == client.SensorType_TEMPERATURE {
-			stateUpdate.SetTemperatureMilli(*sensorDataSet.ValueMilli)
-		} else if *sensorDataSet.SensorType == client.SensorType_HUMIDITY {
-			stateUpdate.SetHumidityMilli(*sensorDataSet.ValueMilli)
-		} else if *sensorDataSet.SensorType == client.SensorType_NIGHT {
+		if sensorDataSet.SensorType == nil {
+			continue
+		}
+
+		if *sensorDataSet.SensorType == client.SensorType_TEMPERATURE && sensorDataSet.ValueMilli != nil {
+			if opts.TemperatureRange.contains(*sensorDataSet.ValueMilli) {
+				stateUpdate.SetTemperatureMilli(*sensorDataSet.ValueMilli)
+			} else {
+				log.Warn().Str("baby_uid", babyUID).Int32("value_milli", *sensorDataSet.ValueMilli).Msg("Ignoring temperature reading out of the plausible range")
+			}
+		} else if *sensorDataSet.SensorType == client.SensorType_HUMIDITY && sensorDataSet.ValueMilli != nil {
+			if opts.HumidityRange.contains(*sensorDataSet.ValueMilli) {
+				stateUpdate.SetHumidityMilli(*sensorDataSet.ValueMilli)
+			} else {
+				log.Warn().Str("baby_uid", babyUID).Int32("value_milli", *sensorDataSet.ValueMilli).Msg("Ignoring humidity reading out of the plausible range")
+			}
+		} else if *sensorDataSet.SensorType == client.SensorType_NIGHT && sensorDataSet.Value != nil {
 			stateUpdate.SetIsNight(*sensorDataSet.Value == 1)
 		}
 	}
 
-	stateManager.Update(babyUID, stateUpdate)
+	stateUpdate.SetIsSensorDataStale(false)
+
+	// Note: unchanged readings are dropped by the state manager unless the consumers want every one of them
+	if opts.PublishUnchanged {
+		sink.ForceUpdate(babyUID, stateUpdate)
+	} else {
+		sink.Update(babyUID, stateUpdate)
+	}
 }
 
-func requestLocalStreaming(babyUID string, targetURL string, streamingStatus client.Streaming_Status, conn *client.WebsocketConnection, stateManager *baby.StateManager) {
+// processSensorAlerts - records the time of motion and sound alerts pushed by the cam (local time of receipt)
+// Note: only the updates initiated by the cam carry fresh alerts, responses to our requests can repeat the old ones
+func processSensorAlerts(babyUID string, sensorData []*client.SensorData, receivedAt time.Time, sink stateSink) {
+	stateUpdate := baby.State{}
+	for _, sensorDataSet := range sensorData {
+		if sensorDataSet.SensorType == nil || sensorDataSet.IsAlert == nil || !*sensorDataSet.IsAlert {
+			continue
+		}
+
+		if *sensorDataSet.SensorType == client.SensorType_MOTION {
+			stateUpdate.SetMotionAlertAt(receivedAt)
+		} else if *sensorDataSet.SensorType == client.SensorType_SOUND {
+			stateUpdate.SetSoundAlertAt(receivedAt)
+		}
+	}
+
+	if stateUpdate.MotionAlertAt != nil || stateUpdate.SoundAlertAt != nil {
+		sink.Update(babyUID, stateUpdate)
+	}
+}
+
+func requestSensorData(conn *client.WebsocketConnection) {
+	conn.SendRequest(client.RequestType_GET_SENSOR_DATA, &client.Request{
+		GetSensorData: &client.GetSensorData{
+			All: utils.ConstRefBool(true),
+		},
+	})
+}
+
+// watchSensorData - periodically re-requests sensor data (safety net for cams which silently stop pushing updates)
+// and marks the readings as stale if there was no update for a while.
+// Note: state manager ignores unchanged values so the refresh does not produce duplicate updates (unless PublishUnchanged is set)
+func watchSensorData(babyUID string, opts SensorOpts, sensorDataReceivedC <-chan struct{}, conn *client.WebsocketConnection, stateManager *baby.StateManager, ctx utils.GracefulContext) {
+	var refreshC, staleC <-chan time.Time
+
+	if opts.RefreshInterval > 0 {
+		refreshTicker := time.NewTicker(opts.RefreshInterval)
+		defer refreshTicker.Stop()
+		refreshC = refreshTicker.C
+	}
+
+	var staleTimer *time.Timer
+	if opts.StaleTimeout > 0 {
+		staleTimer = time.NewTimer(opts.StaleTimeout)
+		defer staleTimer.Stop()
+		staleC = staleTimer.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-refreshC:
+			log.Debug().Str("baby_uid", babyUID).Msg("Refreshing sensor data")
+			requestSensorData(conn)
+
+		case <-sensorDataReceivedC:
+			if staleTimer != nil {
+				if !staleTimer.Stop() {
+					select {
+					case <-staleTimer.C:
+					default:
+					}
+				}
+
+				staleTimer.Reset(opts.StaleTimeout)
+			}
+
+		case <-staleC:
+			log.Warn().Str("baby_uid", babyUID).Msgf("No sensor data received within %v, marking readings as stale", opts.StaleTimeout)
+			stateManager.Update(babyUID, *baby.NewState().SetIsSensorDataStale(true))
+		}
+	}
+}
+
+// streamingRetryDelay - delay between the streaming requests rejected within the startup grace period
+const streamingRetryDelay = 5 * time.Second
+
+// requestLocalStreaming - asks the cam to start/stop streaming, rejected requests are retried until retryRejectedUntil
+// Note: right after startup the request can be rejected just because our RTMP server is not ready yet or previous request is still in flight
+func requestLocalStreaming(babyUID string, targetURL string, streamingStatus client.Streaming_Status, retryRejectedUntil time.Time, conn *client.WebsocketConnection, stateManager *baby.StateManager) {
 	for {
 		switch streamingStatus {
 		case client.Streaming_STARTED:
@@ -48,15 +204,56 @@ func requestLocalStreaming(babyUID string, targetURL string, streamingStatus cli
 		_, err := awaitResponse(30 * time.Second)
 
 		if err != nil {
+			// Connection is likely half-dead, request will be repeated once it is re-established
+			if errors.Is(err, client.ErrSendQueueFull) || errors.Is(err, client.ErrSendTimeout) || errors.Is(err, client.ErrConnectionClosed) {
+				log.Warn().Err(err).Msg("Unable to send streaming request")
+				return
+			}
+
+			if client.IsAlreadyStreamingError(err) {
+				state := stateManager.GetBabyState(babyUID)
+				if state.GetStreamState() == baby.StreamState_Alive {
+					log.Info().Err(err).Msg("Cam is already streaming for another client, stream is flowing to us anyway")
+				} else {
+					log.Info().Err(err).Msg("Cam is already streaming for another client, awaiting stream health check")
+				}
+
+				if requestState, changed := baby.NextStreamRequestState(state, baby.StreamRequestResult_AlreadyStreaming); changed {
+					stateManager.Update(babyUID, *baby.NewState().SetStreamRequestState(requestState))
+				}
+
+				return
+			}
+
+			// Already streaming rejection is matched by a guess, the raw response allows to correct it
+			var resErr *client.ResponseError
+			if errors.As(err, &resErr) {
+				log.Warn().Int32("status_code", resErr.StatusCode).Str("status_message", resErr.StatusMessage).Msg("Cam rejected the streaming request")
+			}
+
+			if err.Error() != "Request timeout" && time.Now().Add(streamingRetryDelay).Before(retryRejectedUntil) {
+				log.Info().Err(err).Msg("Streaming request rejected within startup grace period, trying again")
+				time.Sleep(streamingRetryDelay)
+
+				if !stateManager.GetBabyState(babyUID).GetIsWebsocketAlive() {
+					return
+				}
+
+				continue
+			}
+
 			if err.Error() != "Request timeout" {
-				if stateManager.GetBabyState(babyUID).GetStreamState() == baby.StreamState_Alive {
+				state := stateManager.GetBabyState(babyUID)
+				if state.GetStreamState() == baby.StreamState_Alive {
 					log.Info().Err(err).Msg("Failed to request local streaming, but stream seems to be alive from previous run")
-				} else if stateManager.GetBabyState(babyUID).GetStreamState() == baby.StreamState_Unhealthy {
+				} else if state.GetStreamState() == baby.StreamState_Unhealthy {
 					log.Error().Err(err).Msg("Failed to request local streaming and stream seems to be dead")
-					stateManager.Update(babyUID, *baby.NewState().SetStreamRequestState(baby.StreamRequestState_RequestFailed))
 				} else {
 					log.Warn().Err(err).Msg("Failed to request local streaming, awaiting stream health check")
-					stateManager.Update(babyUID, *baby.NewState().SetStreamRequestState(baby.StreamRequestState_RequestFailed))
+				}
+
+				if requestState, changed := baby.NextStreamRequestState(state, baby.StreamRequestResult_Rejected); changed {
+					stateManager.Update(babyUID, *baby.NewState().SetStreamRequestState(requestState))
 				}
 
 				return
@@ -70,7 +267,9 @@ func requestLocalStreaming(babyUID string, targetURL string, streamingStatus cli
 
 		} else {
 			log.Info().Msg("Local streaming successfully requested")
-			stateManager.Update(babyUID, *baby.NewState().SetStreamRequestState(baby.StreamRequestState_Requested))
+			if requestState, changed := baby.NextStreamRequestState(stateManager.GetBabyState(babyUID), baby.StreamRequestResult_Accepted); changed {
+				stateManager.Update(babyUID, *baby.NewState().SetStreamRequestState(requestState))
+			}
 			return
 		}
 	}
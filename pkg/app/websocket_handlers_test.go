@@ -0,0 +1,255 @@
+package app
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+	"gitlab.com/adam.stanek/nanit/pkg/client"
+	"gitlab.com/adam.stanek/nanit/pkg/notify"
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+type recordingStateSink struct {
+	babyUIDs []string
+	updates  []baby.State
+}
+
+func (sink *recordingStateSink) Update(babyUID string, stateUpdate baby.State) {
+	sink.babyUIDs = append(sink.babyUIDs, babyUID)
+	sink.updates = append(sink.updates, stateUpdate)
+}
+
+func (sink *recordingStateSink) ForceUpdate(babyUID string, stateUpdate baby.State) {
+	sink.Update(babyUID, stateUpdate)
+}
+
+// loadSensorDataFixture - reads recorded (anonymized) websocket message and returns its sensor data
+func loadSensorDataFixture(t *testing.T, name string) []*client.SensorData {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "sensor_data", name))
+	require.NoError(t, err)
+
+	m := &client.Message{}
+	require.NoError(t, protojson.Unmarshal(data, m))
+
+	if m.Request != nil {
+		return m.Request.SensorData_
+	}
+
+	return m.Response.SensorData
+}
+
+var testSensorOpts = SensorOpts{
+	TemperatureRange: SensorRange{Min: -10, Max: 50},
+	HumidityRange:    SensorRange{Min: 0, Max: 100},
+}
+
+func TestProcessSensorData(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		expected *baby.State
+	}{
+		{"put_sensor_data.json", baby.NewState().SetTemperatureMilli(22530).SetHumidityMilli(48120).SetIsNight(true)},
+		{"get_sensor_data_response.json", baby.NewState().SetTemperatureMilli(21875).SetHumidityMilli(51000).SetIsNight(false)},
+		{"put_sensor_data_temperature_only.json", baby.NewState().SetTemperatureMilli(23010)},
+		{"put_sensor_data_missing_values.json", baby.NewState()},
+		{"put_sensor_data_ignored_only.json", baby.NewState()},
+		{"put_sensor_data_out_of_range.json", baby.NewState().SetHumidityMilli(48120).SetIsNight(false)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			sink := &recordingStateSink{}
+			processSensorData("baby1", loadSensorDataFixture(t, test.fixture), testSensorOpts, sink)
+
+			// Any received data means the readings are fresh
+			test.expected.SetIsSensorDataStale(false)
+
+			require.Len(t, sink.updates, 1)
+			assert.Equal(t, "baby1", sink.babyUIDs[0])
+			assert.Equal(t, test.expected.AsMap(true), sink.updates[0].AsMap(true))
+		})
+	}
+}
+
+func TestProcessSensorDataEmpty(t *testing.T) {
+	sink := &recordingStateSink{}
+	processSensorData("baby1", nil, testSensorOpts, sink)
+
+	require.Len(t, sink.updates, 1)
+	assert.Equal(t, map[string]interface{}{"is_sensor_data_stale": false}, sink.updates[0].AsMap(true))
+}
+
+func TestProcessSensorAlerts(t *testing.T) {
+	receivedAt := time.Date(2021, 1, 7, 6, 28, 20, 0, time.UTC)
+
+	sink := &recordingStateSink{}
+	processSensorAlerts("baby1", loadSensorDataFixture(t, "put_sensor_data_ignored_only.json"), receivedAt, sink)
+
+	require.Len(t, sink.updates, 1)
+	assert.Equal(t, receivedAt, *sink.updates[0].MotionAlertAt)
+	assert.Equal(t, receivedAt, *sink.updates[0].SoundAlertAt)
+
+	// Readings without alerts do not produce an update
+	sink = &recordingStateSink{}
+	processSensorAlerts("baby1", loadSensorDataFixture(t, "put_sensor_data.json"), receivedAt, sink)
+	assert.Empty(t, sink.updates)
+}
+
+func TestProcessSensorAlertsNotified(t *testing.T) {
+	receivedAt := time.Date(2021, 1, 7, 6, 28, 20, 0, time.UTC)
+
+	sink := &recordingStateSink{}
+	processSensorAlerts("baby1", loadSensorDataFixture(t, "put_sensor_data_ignored_only.json"), receivedAt, sink)
+	require.Len(t, sink.updates, 1)
+
+	detector := notify.NewDetector(notify.Thresholds{})
+
+	var eventTypes []notify.EventType
+	for _, event := range detector.Detect("baby1", sink.updates[0]) {
+		assert.Equal(t, receivedAt, event.Time)
+		eventTypes = append(eventTypes, event.Type)
+	}
+
+	assert.ElementsMatch(t, []notify.EventType{notify.EventSoundAlert, notify.EventMotionAlert}, eventTypes)
+}
+
+func TestProcessSensorDataLarge(t *testing.T) {
+	sensorData := make([]*client.SensorData, 0, 100000)
+	for i := 0; i < cap(sensorData); i++ {
+		sensorType := client.SensorType_TEMPERATURE
+		sensorData = append(sensorData, &client.SensorData{SensorType: &sensorType, ValueMilli: utils.ConstRefInt32(int32(20000 + i))})
+	}
+
+	sink := &recordingStateSink{}
+	processSensorData("baby1", sensorData, testSensorOpts, sink)
+
+	// Only the first sets are processed, the last one of them wins
+	require.Len(t, sink.updates, 1)
+	assert.Equal(t, int32(20000+maxSensorDataSets-1), *sink.updates[0].TemperatureMilli)
+}
+
+// Repeated readings (ie. periodic refresh) must not reach the subscribers (MQTT, history, ...) again
+func TestProcessSensorDataUnchanged(t *testing.T) {
+	manager := baby.NewStateManager()
+
+	var numCalls int32
+	unsubscribe := manager.Subscribe(func(babyUID string, state baby.State) {
+		atomic.AddInt32(&numCalls, 1)
+	})
+	defer unsubscribe()
+
+	sensorData := loadSensorDataFixture(t, "put_sensor_data.json")
+
+	processSensorData("baby1", sensorData, testSensorOpts, manager)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&numCalls) == 1 }, time.Second, 10*time.Millisecond)
+
+	processSensorData("baby1", sensorData, testSensorOpts, manager)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&numCalls))
+
+	processSensorData("baby1", loadSensorDataFixture(t, "get_sensor_data_response.json"), testSensorOpts, manager)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&numCalls) == 2 }, time.Second, 10*time.Millisecond)
+}
+
+// With PublishUnchanged every reading reaches the subscribers (ie. as a heartbeat), the state stays the same
+func TestProcessSensorDataPublishUnchanged(t *testing.T) {
+	manager := baby.NewStateManager()
+
+	var numCalls int32
+	unsubscribe := manager.Subscribe(func(babyUID string, state baby.State) {
+		atomic.AddInt32(&numCalls, 1)
+	})
+	defer unsubscribe()
+
+	opts := testSensorOpts
+	opts.PublishUnchanged = true
+
+	sensorData := loadSensorDataFixture(t, "put_sensor_data.json")
+
+	processSensorData("baby1", sensorData, opts, manager)
+	processSensorData("baby1", sensorData, opts, manager)
+	processSensorData("baby1", sensorData, opts, manager)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&numCalls) == 3 }, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, int32(22530), *manager.GetBabyState("baby1").TemperatureMilli)
+}
+
+func TestProcessControl(t *testing.T) {
+	sink := &recordingStateSink{}
+
+	processControl("baby1", &client.Control{NightLight: client.Control_LIGHT_ON.Enum()}, sink)
+	processControl("baby1", &client.Control{NightLightTimeout: utils.ConstRefInt32(60)}, sink)
+
+	require.Len(t, sink.updates, 1)
+	assert.True(t, *sink.updates[0].NightLight)
+}
+
+type blockingStateSink struct {
+	releaseC chan struct{}
+	numCalls int32
+}
+
+func (sink *blockingStateSink) Update(babyUID string, stateUpdate baby.State) {
+	<-sink.releaseC
+	atomic.AddInt32(&sink.numCalls, 1)
+}
+
+func (sink *blockingStateSink) ForceUpdate(babyUID string, stateUpdate baby.State) {
+	sink.Update(babyUID, stateUpdate)
+}
+
+func TestSensorQueueSlowSink(t *testing.T) {
+	sink := &blockingStateSink{releaseC: make(chan struct{})}
+	sensorData := loadSensorDataFixture(t, "put_sensor_data.json")
+
+	queue := newSensorQueue("baby1")
+	runner := utils.RunWithGracefulCancel(queue.run)
+
+	// Handler keeps up while the sink is blocked, the overflow is dropped
+	pushedC := make(chan int)
+	go func() {
+		numPushed := 0
+		for i := 0; i < sensorQueueSize*2; i++ {
+			if queue.push(func() { processSensorData("baby1", sensorData, testSensorOpts, sink) }) {
+				numPushed++
+			}
+		}
+
+		pushedC <- numPushed
+	}()
+
+	var numPushed int
+	select {
+	case numPushed = <-pushedC:
+	case <-time.After(time.Second):
+		require.FailNow(t, "Pushing sensor data should not wait for the sink")
+	}
+
+	assert.Less(t, numPushed, sensorQueueSize*2)
+
+	close(sink.releaseC)
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&sink.numCalls) == int32(numPushed)
+	}, time.Second, 10*time.Millisecond)
+
+	runner.Cancel()
+}
+
+func TestSensorQueueCancel(t *testing.T) {
+	queue := newSensorQueue("baby1")
+	runner := utils.RunWithGracefulCancel(queue.run)
+	runner.Cancel()
+
+	var processed int32
+	queue.push(func() { atomic.AddInt32(&processed, 1) })
+	time.Sleep(20 * time.Millisecond)
+
+	assert.Equal(t, int32(0), atomic.LoadInt32(&processed))
+}
@@ -1,8 +1,73 @@
 package baby
 
+import (
+	"fmt"
+	"strings"
+)
+
 // Baby - baby info (matching the Nanit API)
 type Baby struct {
 	UID       string `json:"uid"`
 	Name      string `json:"name"`
 	CameraUID string `json:"camera_uid"`
+
+	// Cameras - all cameras paired with the baby (might not be present)
+	Cameras []Camera `json:"cameras,omitempty"`
+}
+
+// Camera - camera info (matching the Nanit API)
+type Camera struct {
+	UID string `json:"uid"`
+}
+
+// GetCameraUIDs - returns UIDs of all cameras paired with the baby, primary camera first
+func (baby Baby) GetCameraUIDs() []string {
+	uids := []string{}
+	if baby.CameraUID != "" {
+		uids = append(uids, baby.CameraUID)
+	}
+
+	for _, camera := range baby.Cameras {
+		if camera.UID != "" && camera.UID != baby.CameraUID {
+			uids = append(uids, camera.UID)
+		}
+	}
+
+	return uids
+}
+
+// GetStateKey - returns key under which the state (and stream) of given camera is tracked
+// Primary camera uses plain baby UID so that single camera setups are unaffected
+func (baby Baby) GetStateKey(cameraUID string) string {
+	if cameraUID == baby.CameraUID {
+		return baby.UID
+	}
+
+	return baby.UID + "-" + cameraUID
+}
+
+// NormalizeNames - returns copy of the babies with names usable for display (discovery, HomeKit, notifications)
+// Names are trimmed, empty name falls back to the UID and duplicate names get the UID appended
+// Note: duplicates are resolved by the UID, so the result does not depend on the order of the babies
+func NormalizeNames(babies []Baby) []Baby {
+	normalized := make([]Baby, len(babies))
+	counts := make(map[string]int)
+
+	for i, baby := range babies {
+		baby.Name = strings.TrimSpace(baby.Name)
+		if baby.Name == "" {
+			baby.Name = baby.UID
+		}
+
+		normalized[i] = baby
+		counts[strings.ToLower(baby.Name)]++
+	}
+
+	for i, baby := range normalized {
+		if counts[strings.ToLower(baby.Name)] > 1 && baby.Name != baby.UID {
+			normalized[i].Name = fmt.Sprintf("%v (%v)", baby.Name, baby.UID)
+		}
+	}
+
+	return normalized
 }
@@ -0,0 +1,44 @@
+package baby_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+)
+
+func TestBabySingleCamera(t *testing.T) {
+	b := baby.Baby{UID: "baby1", CameraUID: "cam1"}
+
+	assert.Equal(t, []string{"cam1"}, b.GetCameraUIDs())
+	assert.Equal(t, "baby1", b.GetStateKey("cam1"))
+}
+
+func TestBabyMultipleCameras(t *testing.T) {
+	b := baby.Baby{
+		UID:       "baby1",
+		CameraUID: "cam1",
+		Cameras:   []baby.Camera{{UID: "cam1"}, {UID: "cam2"}},
+	}
+
+	assert.Equal(t, []string{"cam1", "cam2"}, b.GetCameraUIDs())
+	assert.Equal(t, "baby1", b.GetStateKey("cam1"))
+	assert.Equal(t, "baby1-cam2", b.GetStateKey("cam2"))
+}
+
+func TestNormalizeNames(t *testing.T) {
+	babies := baby.NormalizeNames([]baby.Baby{
+		{UID: "baby1", Name: "Alex"},
+		{UID: "baby2", Name: ""},
+		{UID: "baby3", Name: " alex "},
+		{UID: "baby4", Name: "Sam"},
+		{UID: "baby5", Name: "   "},
+	})
+
+	var names []string
+	for _, b := range babies {
+		names = append(names, b.Name)
+	}
+
+	assert.Equal(t, []string{"Alex (baby1)", "baby2", "alex (baby3)", "Sam", "baby5"}, names)
+}
@@ -0,0 +1,45 @@
+package baby
+
+// Capabilities - features of the camera as detected from its responses (nil = not known yet)
+type Capabilities struct {
+	HardwareVersion string `json:"hardware_version,omitempty"`
+	FirmwareVersion string `json:"firmware_version,omitempty"`
+
+	NightLight  *bool `json:"night_light,omitempty"`
+	Temperature *bool `json:"temperature,omitempty"`
+	Humidity    *bool `json:"humidity,omitempty"`
+}
+
+// Merge - returns capabilities with known values of the update applied, second value is true if anything changed
+func (capabilities Capabilities) Merge(update Capabilities) (Capabilities, bool) {
+	merged := capabilities
+	changed := false
+
+	mergeStr := func(curr *string, value string) {
+		if value != "" && *curr != value {
+			*curr = value
+			changed = true
+		}
+	}
+
+	mergeBool := func(curr **bool, value *bool) {
+		if value != nil && (*curr == nil || **curr != *value) {
+			v := *value
+			*curr = &v
+			changed = true
+		}
+	}
+
+	mergeStr(&merged.HardwareVersion, update.HardwareVersion)
+	mergeStr(&merged.FirmwareVersion, update.FirmwareVersion)
+	mergeBool(&merged.NightLight, update.NightLight)
+	mergeBool(&merged.Temperature, update.Temperature)
+	mergeBool(&merged.Humidity, update.Humidity)
+
+	return merged, changed
+}
+
+// HasNightLight - returns false only if the cam is known not to support the night light
+func (capabilities Capabilities) HasNightLight() bool {
+	return capabilities.NightLight == nil || *capabilities.NightLight
+}
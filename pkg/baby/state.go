@@ -4,6 +4,7 @@ import (
 	reflect "reflect"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog"
 )
@@ -14,6 +15,8 @@ const (
 	StreamRequestState_NotRequested StreamRequestState = iota
 	StreamRequestState_Requested
 	StreamRequestState_RequestFailed
+	// StreamRequestState_AlreadyStreaming - cam rejected the request because it already streams for another client
+	StreamRequestState_AlreadyStreaming
 )
 
 type StreamState int32
@@ -29,10 +32,23 @@ type State struct {
 	StreamState        *StreamState        `internal:"true"`
 	StreamRequestState *StreamRequestState `internal:"true"`
 	IsWebsocketAlive   *bool               `internal:"true"`
-
-	IsNight          *bool
-	TemperatureMilli *int32
-	HumidityMilli    *int32
+	StreamAliveSince   *time.Time          `internal:"true"`
+	StreamHasVideo     *bool               `internal:"true"`
+	MotionAlertAt      *time.Time          `internal:"true"`
+	SoundAlertAt       *time.Time          `internal:"true"`
+
+	IsNight           *bool
+	TemperatureMilli  *int32
+	HumidityMilli     *int32
+	IsSensorDataStale *bool
+	NightLight        *bool
+	Presence          *string
+
+	// Properties of the local stream, measured by the RTMP server (resolution is nil until the cam sends it)
+	StreamWidth       *int32
+	StreamHeight      *int32
+	StreamFramerate   *int32
+	StreamBitrateKbps *int32
 }
 
 // NewState - constructor
@@ -186,15 +202,85 @@ func (state *State) GetStreamState() StreamState {
 	return StreamState_Unknown
 }
 
+// SetStreamAliveSince - mutates field, returns itself
+func (state *State) SetStreamAliveSince(value time.Time) *State {
+	state.StreamAliveSince = &value
+	return state
+}
+
+// GetStreamAliveDuration - returns for how long has the stream been alive (0 if it is not alive)
+func (state *State) GetStreamAliveDuration(now time.Time) time.Duration {
+	if state.GetStreamState() != StreamState_Alive || state.StreamAliveSince == nil {
+		return 0
+	}
+
+	return now.Sub(*state.StreamAliveSince)
+}
+
+// SetStreamHasVideo - mutates field, returns itself
+func (state *State) SetStreamHasVideo(value bool) *State {
+	state.StreamHasVideo = &value
+	return state
+}
+
+// SetStreamResolution - mutates fields, returns itself
+func (state *State) SetStreamResolution(width int32, height int32) *State {
+	state.StreamWidth = &width
+	state.StreamHeight = &height
+	return state
+}
+
+// SetStreamFramerate - mutates field, returns itself
+func (state *State) SetStreamFramerate(value int32) *State {
+	state.StreamFramerate = &value
+	return state
+}
+
+// SetStreamBitrateKbps - mutates field, returns itself
+func (state *State) SetStreamBitrateKbps(value int32) *State {
+	state.StreamBitrateKbps = &value
+	return state
+}
+
 // SetIsNight - mutates field, returns itself
 func (state *State) SetIsNight(value bool) *State {
 	state.IsNight = &value
 	return state
 }
 
+// SetNightLight - mutates field, returns itself
+func (state *State) SetNightLight(value bool) *State {
+	state.NightLight = &value
+	return state
+}
+
+// SetMotionAlertAt - mutates field, returns itself
+func (state *State) SetMotionAlertAt(value time.Time) *State {
+	state.MotionAlertAt = &value
+	return state
+}
+
+// SetSoundAlertAt - mutates field, returns itself
+func (state *State) SetSoundAlertAt(value time.Time) *State {
+	state.SoundAlertAt = &value
+	return state
+}
+
+// SetPresence - mutates field, returns itself
+func (state *State) SetPresence(value string) *State {
+	state.Presence = &value
+	return state
+}
+
+// SetIsSensorDataStale - mutates field, returns itself
+func (state *State) SetIsSensorDataStale(value bool) *State {
+	state.IsSensorDataStale = &value
+	return state
+}
+
 // GetIsWebsocketAlive - safely returns value
 func (state *State) GetIsWebsocketAlive() bool {
-	if state.StreamState != nil {
+	if state.IsWebsocketAlive != nil {
 		return *state.IsWebsocketAlive
 	}
 
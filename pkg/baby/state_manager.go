@@ -9,21 +9,54 @@ import (
 // StateManager - state manager context
 type StateManager struct {
 	babiesByUID      map[string]State
-	subscribers      map[*chan bool]func(babyUID string, state State)
+	subscribers      map[*subscriber]struct{}
 	stateMutex       sync.RWMutex
 	subscribersMutex sync.RWMutex
 }
 
+// maxPendingUpdates - how many updates can wait for a single subscriber before the oldest ones are dropped
+const maxPendingUpdates = 1024
+
+// subscriber - delivers updates to the callback one by one, in order, without blocking the producer
+// Note: previously every update spawned a goroutine per subscriber, a slow subscriber (MQTT publish, HomeKit, notification sinks)
+// then piled up an unbounded number of goroutines and observed the updates out of order.
+// Every update is delivered (short transitions like Unhealthy -> Alive included), only an overflowing queue drops the oldest ones.
+type subscriber struct {
+	callback func(babyUID string, state State)
+
+	pendingMu sync.Mutex
+	pending   []pendingUpdate
+
+	wakeC chan struct{}
+	doneC chan struct{}
+}
+
+type pendingUpdate struct {
+	babyUID string
+	state   State
+}
+
 // NewStateManager - state manager constructor
 func NewStateManager() *StateManager {
 	return &StateManager{
 		babiesByUID: make(map[string]State),
-		subscribers: make(map[*chan bool]func(babyUID string, state State)),
+		subscribers: make(map[*subscriber]struct{}),
 	}
 }
 
 // Update - updates baby info in thread safe manner
+// Note: never blocks on subscribers, they are notified asynchronously
+// Note: updates which do not change the state are not propagated to the subscribers
 func (manager *StateManager) Update(babyUID string, stateUpdate State) {
+	manager.update(babyUID, stateUpdate, false)
+}
+
+// ForceUpdate - same as Update, but the subscribers are notified even if the update does not change the state
+func (manager *StateManager) ForceUpdate(babyUID string, stateUpdate State) {
+	manager.update(babyUID, stateUpdate, true)
+}
+
+func (manager *StateManager) update(babyUID string, stateUpdate State, force bool) {
 	var updatedState *State
 
 	manager.stateMutex.Lock()
@@ -32,6 +65,10 @@ func (manager *StateManager) Update(babyUID string, stateUpdate State) {
 	if babyState, ok := manager.babiesByUID[babyUID]; ok {
 		updatedState = babyState.Merge(&stateUpdate)
 		if updatedState == &babyState {
+			if force {
+				manager.notifySubscribers(babyUID, stateUpdate)
+			}
+
 			return
 		}
 	} else {
@@ -41,29 +78,42 @@ func (manager *StateManager) Update(babyUID string, stateUpdate State) {
 	manager.babiesByUID[babyUID] = *updatedState
 	stateUpdate.EnhanceLogEvent(log.Debug().Str("baby_uid", babyUID)).Msg("Baby state updated")
 
-	go manager.notifySubscribers(babyUID, stateUpdate)
+	// Notified under the state lock so that subscribers receive the updates in the same order
+	manager.notifySubscribers(babyUID, stateUpdate)
 }
 
 // Subscribe - registers function to be called on every update
+// Callback is never called concurrently with itself, updates which arrive while it is busy are queued.
 // Returns unsubscribe function
 func (manager *StateManager) Subscribe(callback func(babyUID string, state State)) func() {
-	unsubscribeC := make(chan bool, 1)
+	sub := &subscriber{
+		callback: callback,
+		wakeC:    make(chan struct{}, 1),
+		doneC:    make(chan struct{}),
+	}
+
+	go sub.run()
 
 	manager.subscribersMutex.Lock()
-	manager.subscribers[&unsubscribeC] = callback
+	manager.subscribers[sub] = struct{}{}
 	manager.subscribersMutex.Unlock()
 
 	manager.stateMutex.RLock()
 	for babyUID, babyState := range manager.babiesByUID {
-		go callback(babyUID, babyState)
+		sub.push(babyUID, babyState)
 	}
 
 	manager.stateMutex.RUnlock()
 
+	var once sync.Once
 	return func() {
-		manager.subscribersMutex.Lock()
-		delete(manager.subscribers, &unsubscribeC)
-		manager.subscribersMutex.Unlock()
+		once.Do(func() {
+			manager.subscribersMutex.Lock()
+			delete(manager.subscribers, sub)
+			manager.subscribersMutex.Unlock()
+
+			close(sub.doneC)
+		})
 	}
 }
 
@@ -79,9 +129,51 @@ func (manager *StateManager) GetBabyState(babyUID string) *State {
 func (manager *StateManager) notifySubscribers(babyUID string, state State) {
 	manager.subscribersMutex.RLock()
 
-	for _, callback := range manager.subscribers {
-		go callback(babyUID, state)
+	for sub := range manager.subscribers {
+		sub.push(babyUID, state)
 	}
 
 	manager.subscribersMutex.RUnlock()
 }
+
+// push - queues update for delivery, drops the oldest pending update if the subscriber is too far behind
+func (sub *subscriber) push(babyUID string, state State) {
+	sub.pendingMu.Lock()
+	if len(sub.pending) >= maxPendingUpdates {
+		log.Warn().Str("baby_uid", sub.pending[0].babyUID).Int("max_pending", maxPendingUpdates).Msg("State subscriber is too slow, dropping the oldest update")
+		sub.pending = sub.pending[1:]
+	}
+
+	sub.pending = append(sub.pending, pendingUpdate{babyUID, state})
+	sub.pendingMu.Unlock()
+
+	select {
+	case sub.wakeC <- struct{}{}:
+	default:
+	}
+}
+
+func (sub *subscriber) run() {
+	for {
+		select {
+		case <-sub.doneC:
+			return
+		case <-sub.wakeC:
+		}
+
+		sub.pendingMu.Lock()
+		pending := sub.pending
+		sub.pending = nil
+		sub.pendingMu.Unlock()
+
+		for _, update := range pending {
+			select {
+			case <-sub.doneC:
+				return
+			default:
+			}
+
+			sub.callback(update.babyUID, update.state)
+		}
+	}
+}
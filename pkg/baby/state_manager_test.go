@@ -0,0 +1,131 @@
+package baby_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+)
+
+func TestStateManagerSlowSubscriberDoesNotBlockUpdates(t *testing.T) {
+	manager := baby.NewStateManager()
+
+	releaseC := make(chan struct{})
+	var mu sync.Mutex
+	var received []baby.StreamState
+
+	unsubscribe := manager.Subscribe(func(babyUID string, state baby.State) {
+		<-releaseC
+
+		mu.Lock()
+		received = append(received, *state.StreamState)
+		mu.Unlock()
+	})
+	defer unsubscribe()
+
+	updatedC := make(chan struct{})
+	go func() {
+		manager.Update("baby1", *baby.NewState().SetStreamState(baby.StreamState_Alive))
+		manager.Update("baby1", *baby.NewState().SetStreamState(baby.StreamState_Unhealthy))
+		manager.Update("baby1", *baby.NewState().SetStreamState(baby.StreamState_Alive))
+		close(updatedC)
+	}()
+
+	select {
+	case <-updatedC:
+	case <-time.After(time.Second):
+		assert.FailNow(t, "Updates should not wait for the blocked subscriber")
+	}
+
+	close(releaseC)
+
+	// Short transitions are not collapsed
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 3
+	}, time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []baby.StreamState{baby.StreamState_Alive, baby.StreamState_Unhealthy, baby.StreamState_Alive}, received)
+}
+
+func TestStateManagerDeliversInOrder(t *testing.T) {
+	manager := baby.NewStateManager()
+
+	var mu sync.Mutex
+	var received []int32
+
+	unsubscribe := manager.Subscribe(func(babyUID string, state baby.State) {
+		mu.Lock()
+		received = append(received, *state.TemperatureMilli)
+		mu.Unlock()
+	})
+	defer unsubscribe()
+
+	for i := 1; i <= 100; i++ {
+		manager.Update("baby1", *baby.NewState().SetTemperatureMilli(int32(i)))
+	}
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) > 0 && received[len(received)-1] == 100
+	}, time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 1; i < len(received); i++ {
+		assert.Greater(t, received[i], received[i-1])
+	}
+}
+
+func TestStateManagerUnsubscribe(t *testing.T) {
+	manager := baby.NewStateManager()
+
+	var numCalls int32
+	unsubscribe := manager.Subscribe(func(babyUID string, state baby.State) {
+		atomic.AddInt32(&numCalls, 1)
+	})
+
+	unsubscribe()
+	unsubscribe()
+
+	manager.Update("baby1", *baby.NewState().SetTemperatureMilli(1))
+	time.Sleep(20 * time.Millisecond)
+
+	assert.Equal(t, int32(0), atomic.LoadInt32(&numCalls))
+}
+
+func TestStateManagerForceUpdate(t *testing.T) {
+	manager := baby.NewStateManager()
+
+	var mu sync.Mutex
+	var received []baby.State
+	unsubscribe := manager.Subscribe(func(babyUID string, state baby.State) {
+		mu.Lock()
+		received = append(received, state)
+		mu.Unlock()
+	})
+	defer unsubscribe()
+
+	manager.Update("baby1", *baby.NewState().SetTemperatureMilli(1))
+	manager.Update("baby1", *baby.NewState().SetTemperatureMilli(1))
+	manager.ForceUpdate("baby1", *baby.NewState().SetTemperatureMilli(1))
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	assert.Equal(t, int32(1), *received[1].TemperatureMilli)
+	mu.Unlock()
+
+	assert.Equal(t, int32(1), *manager.GetBabyState("baby1").TemperatureMilli)
+}
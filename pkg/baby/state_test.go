@@ -2,6 +2,7 @@ package baby_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"gitlab.com/adam.stanek/nanit/pkg/baby"
@@ -50,3 +51,16 @@ func TestStateMergeDifferent(t *testing.T) {
 	assert.Equal(t, 20.0, s3.GetHumidity())
 	assert.Equal(t, baby.StreamState_Alive, s3.GetStreamState())
 }
+
+func TestStateStreamAliveDuration(t *testing.T) {
+	now := time.Now()
+
+	s := &baby.State{}
+	s.SetStreamAliveSince(now.Add(-time.Minute))
+	assert.Equal(t, time.Duration(0), s.GetStreamAliveDuration(now), "Should be zero for stream which is not alive")
+
+	s.SetStreamState(baby.StreamState_Alive)
+	assert.Equal(t, time.Minute, s.GetStreamAliveDuration(now))
+
+	assert.NotContains(t, s.AsMap(false), "stream_alive_since", "Should not contain internal fields")
+}
@@ -0,0 +1,135 @@
+package baby
+
+// StreamEvent - event which might require action on the local stream
+type StreamEvent int32
+
+const (
+	// StreamEvent_WebsocketReady - websocket connection to the cam has been established
+	StreamEvent_WebsocketReady StreamEvent = iota
+	// StreamEvent_StreamUnhealthy - stream has just turned unhealthy
+	StreamEvent_StreamUnhealthy
+	// StreamEvent_WebsocketClosing - websocket connection is about to be closed by us
+	StreamEvent_WebsocketClosing
+)
+
+// StreamAction - action to be performed on the local stream
+type StreamAction int32
+
+const (
+	// StreamAction_None - nothing to do
+	StreamAction_None StreamAction = iota
+	// StreamAction_Request - ask the cam to start streaming
+	StreamAction_Request
+	// StreamAction_Stop - ask the cam to stop streaming
+	StreamAction_Stop
+)
+
+// StreamRequestResult - outcome of the streaming request
+type StreamRequestResult int32
+
+const (
+	// StreamRequestResult_Accepted - cam accepted the request
+	StreamRequestResult_Accepted StreamRequestResult = iota
+	// StreamRequestResult_Rejected - cam responded with an error
+	StreamRequestResult_Rejected
+	// StreamRequestResult_AlreadyStreaming - cam is already streaming (requested by another client)
+	StreamRequestResult_AlreadyStreaming
+)
+
+type streamRule struct {
+	name   string
+	event  StreamEvent
+	guard  func(state *State) bool
+	action StreamAction
+}
+
+// streamRules - first rule matching the event and guard decides the action
+var streamRules = []streamRule{
+	{
+		name:   "stream already alive",
+		event:  StreamEvent_WebsocketReady,
+		guard:  func(state *State) bool { return state.GetStreamState() == StreamState_Alive },
+		action: StreamAction_None,
+	},
+	{
+		name:  "request pending",
+		event: StreamEvent_WebsocketReady,
+		guard: func(state *State) bool {
+			return state.GetStreamRequestState() == StreamRequestState_Requested && state.GetStreamState() != StreamState_Unhealthy
+		},
+		action: StreamAction_None,
+	},
+	{
+		// Stream requested by another client might be flowing to our server too, wait for the health check
+		name:  "already streaming elsewhere",
+		event: StreamEvent_WebsocketReady,
+		guard: func(state *State) bool {
+			return state.GetStreamRequestState() == StreamRequestState_AlreadyStreaming && state.GetStreamState() != StreamState_Unhealthy
+		},
+		action: StreamAction_None,
+	},
+	{
+		name:   "stream not alive",
+		event:  StreamEvent_WebsocketReady,
+		guard:  func(state *State) bool { return true },
+		action: StreamAction_Request,
+	},
+	{
+		// Prevent duplicate request if we already received failure
+		name:   "request already failed",
+		event:  StreamEvent_StreamUnhealthy,
+		guard:  func(state *State) bool { return state.GetStreamRequestState() == StreamRequestState_RequestFailed },
+		action: StreamAction_None,
+	},
+	{
+		name:   "stream turned unhealthy",
+		event:  StreamEvent_StreamUnhealthy,
+		guard:  func(state *State) bool { return true },
+		action: StreamAction_Request,
+	},
+	{
+		// Stopping would cut off the client which requested the stream
+		name:   "stream not requested by us on close",
+		event:  StreamEvent_WebsocketClosing,
+		guard:  func(state *State) bool { return state.GetStreamRequestState() == StreamRequestState_AlreadyStreaming },
+		action: StreamAction_None,
+	},
+	{
+		name:  "stream alive on close",
+		event: StreamEvent_WebsocketClosing,
+		guard: func(state *State) bool {
+			return state.GetIsWebsocketAlive() && state.GetStreamState() == StreamState_Alive
+		},
+		action: StreamAction_Stop,
+	},
+}
+
+// NextStreamAction - decides what to do with the local stream upon event, returns name of the matched rule for logging
+func NextStreamAction(state *State, event StreamEvent) (StreamAction, string) {
+	for _, rule := range streamRules {
+		if rule.event == event && rule.guard(state) {
+			return rule.action, rule.name
+		}
+	}
+
+	return StreamAction_None, "no rule"
+}
+
+// NextStreamRequestState - returns new request state after the streaming request finished (false if it should not change)
+func NextStreamRequestState(state *State, result StreamRequestResult) (StreamRequestState, bool) {
+	switch result {
+	case StreamRequestResult_Accepted:
+		return StreamRequestState_Requested, true
+	case StreamRequestResult_Rejected:
+		// Stream might be alive from the previous run, no reason to consider it failed then
+		if state.GetStreamState() == StreamState_Alive {
+			return state.GetStreamRequestState(), false
+		}
+
+		return StreamRequestState_RequestFailed, true
+	case StreamRequestResult_AlreadyStreaming:
+		return StreamRequestState_AlreadyStreaming, true
+	}
+
+	return state.GetStreamRequestState(), false
+}
@@ -0,0 +1,71 @@
+package baby_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+)
+
+func TestNextStreamAction(t *testing.T) {
+	newState := func(streamState baby.StreamState, requestState baby.StreamRequestState, websocketAlive bool) *baby.State {
+		return baby.NewState().
+			SetStreamState(streamState).
+			SetStreamRequestState(requestState).
+			SetWebsocketAlive(websocketAlive)
+	}
+
+	tests := []struct {
+		name     string
+		state    *baby.State
+		event    baby.StreamEvent
+		expected baby.StreamAction
+	}{
+		{"ready, unknown stream", newState(baby.StreamState_Unknown, baby.StreamRequestState_NotRequested, true), baby.StreamEvent_WebsocketReady, baby.StreamAction_Request},
+		{"ready, alive stream", newState(baby.StreamState_Alive, baby.StreamRequestState_Requested, true), baby.StreamEvent_WebsocketReady, baby.StreamAction_None},
+		{"ready, pending request", newState(baby.StreamState_Unknown, baby.StreamRequestState_Requested, true), baby.StreamEvent_WebsocketReady, baby.StreamAction_None},
+		{"ready, requested but unhealthy", newState(baby.StreamState_Unhealthy, baby.StreamRequestState_Requested, true), baby.StreamEvent_WebsocketReady, baby.StreamAction_Request},
+		{"ready, previously failed", newState(baby.StreamState_Unhealthy, baby.StreamRequestState_RequestFailed, true), baby.StreamEvent_WebsocketReady, baby.StreamAction_Request},
+		{"unhealthy, requested", newState(baby.StreamState_Unhealthy, baby.StreamRequestState_Requested, true), baby.StreamEvent_StreamUnhealthy, baby.StreamAction_Request},
+		{"unhealthy, request failed", newState(baby.StreamState_Unhealthy, baby.StreamRequestState_RequestFailed, true), baby.StreamEvent_StreamUnhealthy, baby.StreamAction_None},
+		{"closing, alive stream", newState(baby.StreamState_Alive, baby.StreamRequestState_Requested, true), baby.StreamEvent_WebsocketClosing, baby.StreamAction_Stop},
+		{"closing, websocket gone", newState(baby.StreamState_Alive, baby.StreamRequestState_Requested, false), baby.StreamEvent_WebsocketClosing, baby.StreamAction_None},
+		{"ready, already streaming elsewhere", newState(baby.StreamState_Unknown, baby.StreamRequestState_AlreadyStreaming, true), baby.StreamEvent_WebsocketReady, baby.StreamAction_None},
+		{"ready, already streaming elsewhere but unhealthy", newState(baby.StreamState_Unhealthy, baby.StreamRequestState_AlreadyStreaming, true), baby.StreamEvent_WebsocketReady, baby.StreamAction_Request},
+		{"unhealthy, already streaming elsewhere", newState(baby.StreamState_Unhealthy, baby.StreamRequestState_AlreadyStreaming, true), baby.StreamEvent_StreamUnhealthy, baby.StreamAction_Request},
+		{"closing, already streaming elsewhere", newState(baby.StreamState_Alive, baby.StreamRequestState_AlreadyStreaming, true), baby.StreamEvent_WebsocketClosing, baby.StreamAction_None},
+		{"closing, unhealthy stream", newState(baby.StreamState_Unhealthy, baby.StreamRequestState_Requested, true), baby.StreamEvent_WebsocketClosing, baby.StreamAction_None},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action, _ := baby.NextStreamAction(tt.state, tt.event)
+			assert.Equal(t, tt.expected, action)
+		})
+	}
+}
+
+func TestNextStreamRequestState(t *testing.T) {
+	tests := []struct {
+		name            string
+		streamState     baby.StreamState
+		result          baby.StreamRequestResult
+		expected        baby.StreamRequestState
+		expectedChanged bool
+	}{
+		{"accepted", baby.StreamState_Unknown, baby.StreamRequestResult_Accepted, baby.StreamRequestState_Requested, true},
+		{"rejected, unknown stream", baby.StreamState_Unknown, baby.StreamRequestResult_Rejected, baby.StreamRequestState_RequestFailed, true},
+		{"rejected, unhealthy stream", baby.StreamState_Unhealthy, baby.StreamRequestResult_Rejected, baby.StreamRequestState_RequestFailed, true},
+		{"rejected, alive stream", baby.StreamState_Alive, baby.StreamRequestResult_Rejected, baby.StreamRequestState_NotRequested, false},
+		{"already streaming, alive stream", baby.StreamState_Alive, baby.StreamRequestResult_AlreadyStreaming, baby.StreamRequestState_AlreadyStreaming, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := baby.NewState().SetStreamState(tt.streamState)
+			requestState, changed := baby.NextStreamRequestState(state, tt.result)
+			assert.Equal(t, tt.expected, requestState)
+			assert.Equal(t, tt.expectedChanged, changed)
+		})
+	}
+}
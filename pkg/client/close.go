@@ -0,0 +1,33 @@
+package client
+
+import (
+	"errors"
+
+	"github.com/gorilla/websocket"
+)
+
+// CloseAction - how to react to the websocket being closed by the server
+type CloseAction string
+
+const (
+	// CloseAction_Reconnect - transient close (server restart, going away), reconnecting with the same token
+	CloseAction_Reconnect CloseAction = "reconnect"
+	// CloseAction_Reauthorize - server refused the session (policy violation, unauthorized), token has to be renewed first
+	CloseAction_Reauthorize CloseAction = "reauthorize"
+)
+
+// getCloseAction - maps the close code sent by the server to the reaction, second value is false if err is not a close frame
+func getCloseAction(err error) (*websocket.CloseError, CloseAction, bool) {
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		return nil, "", false
+	}
+
+	switch closeErr.Code {
+	// 3000 and 3003 are registered as Unauthorized and Forbidden
+	case websocket.ClosePolicyViolation, 3000, 3003:
+		return closeErr, CloseAction_Reauthorize, true
+	default:
+		return closeErr, CloseAction_Reconnect, true
+	}
+}
@@ -0,0 +1,35 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetCloseAction(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected CloseAction
+	}{
+		{"normal", &websocket.CloseError{Code: websocket.CloseNormalClosure}, CloseAction_Reconnect},
+		{"going away", &websocket.CloseError{Code: websocket.CloseGoingAway, Text: "restart"}, CloseAction_Reconnect},
+		{"try again later", &websocket.CloseError{Code: websocket.CloseTryAgainLater}, CloseAction_Reconnect},
+		{"policy violation", &websocket.CloseError{Code: websocket.ClosePolicyViolation, Text: "invalid token"}, CloseAction_Reauthorize},
+		{"wrapped unauthorized", fmt.Errorf("read: %w", &websocket.CloseError{Code: 3000}), CloseAction_Reauthorize},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, action, ok := getCloseAction(test.err)
+			assert.True(t, ok)
+			assert.Equal(t, test.expected, action)
+		})
+	}
+
+	_, _, ok := getCloseAction(errors.New("read: connection reset by peer"))
+	assert.False(t, ok)
+}
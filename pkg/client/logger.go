@@ -0,0 +1,6 @@
+package client
+
+import "gitlab.com/adam.stanek/nanit/pkg/utils"
+
+// log - logger of the package, level can be set separately (NANIT_LOG_LEVELS)
+var log = utils.NewComponentLogger("client")
@@ -0,0 +1,7 @@
+//go:build !race
+// +build !race
+
+package client
+
+// raceEnabled - whether the tests run with the race detector
+const raceEnabled = false
@@ -3,16 +3,23 @@ package client
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"sync"
 	"time"
 
-	"github.com/rs/zerolog/log"
 	"gitlab.com/adam.stanek/nanit/pkg/baby"
 	"gitlab.com/adam.stanek/nanit/pkg/session"
 	"gitlab.com/adam.stanek/nanit/pkg/utils"
 )
 
-var myClient = &http.Client{Timeout: 10 * time.Second}
+// httpTimeout - timeout of REST API requests
+const httpTimeout = 10 * time.Second
+
+// apiBaseURL - base URL of the Nanit REST API
+const apiBaseURL = "https://api.nanit.com"
 
 // ------------------------------------------
 
@@ -31,17 +38,109 @@ type NanitClient struct {
 	Email        string
 	Password     string
 	SessionStore *session.Store
+
+	// TokenLifetime - assumed lifetime of the auth token (0 = AuthTokenTimelife)
+	// Stored token younger than this is reused across restarts without logging in again
+	TokenLifetime time.Duration
+
+	// TokenRefreshMargin - token is refreshed this long before its assumed expiry
+	TokenRefreshMargin time.Duration
+
+	// ClockSkewTolerance - how far in the future can the auth time be before we stop trusting it (ie. clock jumped back)
+	ClockSkewTolerance time.Duration
+
+	// ProxyURL - optional proxy for all the Nanit traffic (HTTP_PROXY, HTTPS_PROXY and NO_PROXY are honored if empty)
+	ProxyURL string
+
+	httpClientOnce sync.Once
+	httpClient     *http.Client
+
+	tokenSubscribersMu sync.Mutex
+	tokenSubscribers   map[*func()]struct{}
+
+	// apiURL - overrides apiBaseURL (tests)
+	apiURL string
+}
+
+// getAPIURL - returns URL of the REST API endpoint
+func (c *NanitClient) getAPIURL(path string) string {
+	if c.apiURL != "" {
+		return c.apiURL + path
+	}
+
+	return apiBaseURL + path
+}
+
+// getTokenLifetime - returns assumed lifetime of the auth token
+func (c *NanitClient) getTokenLifetime() time.Duration {
+	if c.TokenLifetime > 0 {
+		return c.TokenLifetime
+	}
+
+	return AuthTokenTimelife
+}
+
+// getProxyFunc - returns proxy resolver for both the REST API and the websocket
+func (c *NanitClient) getProxyFunc() func(*http.Request) (*url.URL, error) {
+	if c.ProxyURL == "" {
+		return http.ProxyFromEnvironment
+	}
+
+	proxyURL, err := url.Parse(c.ProxyURL)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid proxy URL")
+	}
+
+	return http.ProxyURL(proxyURL)
+}
+
+func (c *NanitClient) getHTTPClient() *http.Client {
+	c.httpClientOnce.Do(func() {
+		// Note: TLS to the API is negotiated end-to-end through the proxy (CONNECT)
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = c.getProxyFunc()
+
+		c.httpClient = &http.Client{Timeout: httpTimeout, Transport: transport}
+	})
+
+	return c.httpClient
 }
 
 // MaybeAuthorize - Performs authorizaiton if we don't have token or we assume it is expired
+// Note: valid token from the session file is trusted without any request, frequent restarts would trigger the login rate limits otherwise
 func (c *NanitClient) MaybeAuthorize(force bool) {
-	if force || c.SessionStore.Session.AuthToken == "" || time.Since(c.SessionStore.Session.AuthTime) > AuthTokenTimelife {
+	token, authTime := c.SessionStore.GetAuth()
+	if force || token == "" || c.isTokenExpired(time.Now()) {
 		c.Authorize()
+		return
 	}
+
+	log.Debug().Dur("age", time.Since(authTime)).Msg("Reusing stored auth token")
+}
+
+// isTokenExpired - decides whether token should be refreshed based on the time of authorization
+// Note: auth time comes from the local clock (possibly from the previous run), which might have been adjusted since
+func (c *NanitClient) isTokenExpired(now time.Time) bool {
+	_, authTime := c.SessionStore.GetAuth()
+	age := now.Sub(authTime)
+
+	if age < -c.ClockSkewTolerance {
+		log.Warn().Time("auth_time", authTime).Msg("Token was issued in the future, system clock has likely changed")
+		return true
+	}
+
+	return age > c.getTokenLifetime()-c.TokenRefreshMargin
 }
 
 // Authorize - performs authorization attempt, panics if it fails
 func (c *NanitClient) Authorize() {
+	if err := c.TryAuthorize(); err != nil {
+		log.Fatal().Err(err).Msg("Unable to authorize")
+	}
+}
+
+// TryAuthorize - performs authorization attempt, stored token is kept if it fails
+func (c *NanitClient) TryAuthorize() error {
 	log.Info().Str("email", c.Email).Str("password", utils.AnonymizeToken(c.Password, 0)).Msg("Authorizing using user credentials")
 
 	requestBody, requestBodyErr := json.Marshal(map[string]string{
@@ -50,42 +149,81 @@ func (c *NanitClient) Authorize() {
 	})
 
 	if requestBodyErr != nil {
-		log.Fatal().Err(requestBodyErr).Msg("Unable to marshal auth body")
+		return fmt.Errorf("unable to marshal auth body: %w", requestBodyErr)
 	}
 
-	r, clientErr := myClient.Post("https://api.nanit.com/login", "application/json", bytes.NewBuffer(requestBody))
+	r, clientErr := c.getHTTPClient().Post(c.getAPIURL("/login"), "application/json", bytes.NewBuffer(requestBody))
 	if clientErr != nil {
-		log.Fatal().Err(clientErr).Msg("Unable to fetch auth token")
+		return fmt.Errorf("unable to fetch auth token: %w", clientErr)
 	}
 
 	defer r.Body.Close()
 
 	if r.StatusCode == 401 {
-		log.Fatal().Msg("Server responded with code 401. Provided credentials has not been accepted by the server. Please check if your e-mail address and password is entered correctly and that 2FA is disabled on your account.")
+		return errors.New("server responded with code 401. Provided credentials has not been accepted by the server. Please check if your e-mail address and password is entered correctly and that 2FA is disabled on your account")
 	} else if r.StatusCode != 201 {
-		log.Fatal().Int("code", r.StatusCode).Msg("Server responded with unexpected status code")
+		return fmt.Errorf("server responded with unexpected status code %v", r.StatusCode)
 	}
 
 	authResponse := new(authResponsePayload)
 
 	jsonErr := json.NewDecoder(r.Body).Decode(authResponse)
 	if jsonErr != nil {
-		log.Fatal().Err(jsonErr).Msg("Unable to decode response")
+		return fmt.Errorf("unable to decode response: %w", jsonErr)
 	}
 
 	log.Info().Str("token", utils.AnonymizeToken(authResponse.AccessToken, 4)).Msg("Authorized")
-	c.SessionStore.Session.AuthToken = authResponse.AccessToken
-	c.SessionStore.Session.AuthTime = time.Now()
-	c.SessionStore.Save()
+	c.SessionStore.SetAuth(authResponse.AccessToken, time.Now())
+	c.notifyTokenRotated()
+	return nil
+}
+
+// OnTokenRotated - registers function to be called whenever a new token is obtained
+// Returns unsubscribe function
+func (c *NanitClient) OnTokenRotated(callback func()) func() {
+	c.tokenSubscribersMu.Lock()
+	defer c.tokenSubscribersMu.Unlock()
+
+	if c.tokenSubscribers == nil {
+		c.tokenSubscribers = make(map[*func()]struct{})
+	}
+
+	key := &callback
+	c.tokenSubscribers[key] = struct{}{}
+
+	return func() {
+		c.tokenSubscribersMu.Lock()
+		delete(c.tokenSubscribers, key)
+		c.tokenSubscribersMu.Unlock()
+	}
+}
+
+func (c *NanitClient) notifyTokenRotated() {
+	c.tokenSubscribersMu.Lock()
+	callbacks := make([]func(), 0, len(c.tokenSubscribers))
+	for callback := range c.tokenSubscribers {
+		callbacks = append(callbacks, *callback)
+	}
+	c.tokenSubscribersMu.Unlock()
+
+	for _, callback := range callbacks {
+		callback()
+	}
+}
+
+// GetTokenExpiry - returns time when the stored token is considered expired (it is refreshed a bit sooner, see TokenRefreshMargin)
+func (c *NanitClient) GetTokenExpiry() time.Time {
+	_, authTime := c.SessionStore.GetAuth()
+	return authTime.Add(c.getTokenLifetime())
 }
 
 // FetchAuthorized - makes authorized http request
 func (c *NanitClient) FetchAuthorized(req *http.Request, data interface{}) {
 	for i := 0; i < 2; i++ {
-		if c.SessionStore.Session.AuthToken != "" {
-			req.Header.Set("Authorization", c.SessionStore.Session.AuthToken)
+		if token, _ := c.SessionStore.GetAuth(); token != "" {
+			req.Header.Set("Authorization", token)
 
-			res, clientErr := myClient.Do(req)
+			res, clientErr := c.getHTTPClient().Do(req)
 			if clientErr != nil {
 				log.Fatal().Err(clientErr).Msg("HTTP request failed")
 			}
@@ -117,7 +255,7 @@ func (c *NanitClient) FetchAuthorized(req *http.Request, data interface{}) {
 // FetchBabies - fetches baby list
 func (c *NanitClient) FetchBabies() []baby.Baby {
 	log.Info().Msg("Fetching babies list")
-	req, reqErr := http.NewRequest("GET", "https://api.nanit.com/babies", nil)
+	req, reqErr := http.NewRequest("GET", c.getAPIURL("/babies"), nil)
 
 	if reqErr != nil {
 		log.Fatal().Err(reqErr).Msg("Unable to create request")
@@ -132,10 +270,12 @@ func (c *NanitClient) FetchBabies() []baby.Baby {
 }
 
 // EnsureBabies - fetches baby list if not fetched already
+// Names are normalized (see baby.NormalizeNames) so that everything derived from them is non-empty and distinct
 func (c *NanitClient) EnsureBabies() []baby.Baby {
 	if len(c.SessionStore.Session.Babies) == 0 {
-		return c.FetchBabies()
+		c.FetchBabies()
 	}
 
+	c.SessionStore.Session.Babies = baby.NormalizeNames(c.SessionStore.Session.Babies)
 	return c.SessionStore.Session.Babies
 }
@@ -0,0 +1,155 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/adam.stanek/nanit/pkg/session"
+)
+
+func TestIsTokenExpired(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		authTime time.Time
+		expired  bool
+	}{
+		{"fresh token", now.Add(-time.Minute), false},
+		{"within refresh margin", now.Add(-AuthTokenTimelife + 30*time.Second), true},
+		{"past lifetime", now.Add(-AuthTokenTimelife - time.Minute), true},
+		{"slightly in the future", now.Add(2 * time.Minute), false},
+		{"far in the future", now.Add(time.Hour), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &NanitClient{
+				SessionStore:       &session.Store{Session: &session.Session{AuthToken: "token", AuthTime: test.authTime}},
+				TokenRefreshMargin: time.Minute,
+				ClockSkewTolerance: 5 * time.Minute,
+			}
+
+			assert.Equal(t, test.expired, c.isTokenExpired(now))
+		})
+	}
+}
+
+func TestMaybeAuthorizeReusesStoredToken(t *testing.T) {
+	var numLogins int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			atomic.AddInt32(&numLogins, 1)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"access_token":"new-token"}`))
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name          string
+		authTime      time.Duration
+		tokenLifetime time.Duration
+		login         bool
+	}{
+		{"valid stored token", -5 * time.Minute, 0, false},
+		{"expired stored token", -20 * time.Minute, 0, true},
+		{"valid with longer lifetime", -20 * time.Minute, time.Hour, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			atomic.StoreInt32(&numLogins, 0)
+
+			store := session.NewSessionStore()
+			store.Session.AuthToken = "stored-token"
+			store.Session.AuthTime = time.Now().Add(test.authTime)
+
+			c := &NanitClient{
+				SessionStore:       store,
+				TokenLifetime:      test.tokenLifetime,
+				TokenRefreshMargin: time.Minute,
+				ClockSkewTolerance: 5 * time.Minute,
+				apiURL:             server.URL,
+			}
+
+			c.MaybeAuthorize(false)
+
+			if test.login {
+				assert.Equal(t, int32(1), atomic.LoadInt32(&numLogins))
+				assert.Equal(t, "new-token", store.Session.AuthToken)
+			} else {
+				assert.Equal(t, int32(0), atomic.LoadInt32(&numLogins))
+				assert.Equal(t, "stored-token", store.Session.AuthToken)
+			}
+		})
+	}
+}
+
+func TestTryAuthorizeKeepsTokenOnFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	store := session.NewSessionStore()
+	store.Session.AuthToken = "stored-token"
+
+	c := &NanitClient{SessionStore: store, apiURL: server.URL}
+
+	assert.Error(t, c.TryAuthorize())
+	assert.Equal(t, "stored-token", store.Session.AuthToken)
+}
+
+// Token is rotated from the request goroutines (admin endpoint, MQTT command) while the websockets read it (run with -race)
+func TestTryAuthorizeConcurrentWithReaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"access_token":"new-token"}`))
+	}))
+	defer server.Close()
+
+	store := session.NewSessionStore()
+	store.SetAuth("stored-token", time.Now())
+
+	c := &NanitClient{
+		SessionStore:       store,
+		TokenRefreshMargin: time.Minute,
+		ClockSkewTolerance: 5 * time.Minute,
+		apiURL:             server.URL,
+	}
+
+	doneC := make(chan struct{})
+	readerDoneC := make(chan struct{})
+	go func() {
+		defer close(readerDoneC)
+		for {
+			select {
+			case <-doneC:
+				return
+			default:
+			}
+
+			c.MaybeAuthorize(false)
+			c.GetTokenExpiry()
+			store.GetAuth()
+		}
+	}()
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, c.TryAuthorize())
+	}
+
+	close(doneC)
+	<-readerDoneC
+
+	token, _ := store.GetAuth()
+	assert.Equal(t, "new-token", token)
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// websocketHandshakeTimeout - max. time for connecting + TLS handshake + websocket upgrade
+const websocketHandshakeTimeout = 30 * time.Second
+
+// TrustOnlyCAFile - only certificates issued by the CAs from given PEM file will be trusted (both REST API and websocket)
+// Note: has to be called before any TLS connection is made, system cert pool is loaded only once.
+// It goes through SSL_CERT_FILE because websocket library does not allow to pass our own TLS config.
+func TrustOnlyCAFile(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	if !x509.NewCertPool().AppendCertsFromPEM(data) {
+		return fmt.Errorf("no PEM certificates found in %v", filename)
+	}
+
+	return os.Setenv("SSL_CERT_FILE", filename)
+}
+
+// ConnectErrorKind - category of connection failure, for diagnostics
+type ConnectErrorKind string
+
+const (
+	// ConnectError_Certificate - server certificate was not accepted (ie. rotated cert, intercepting proxy, missing CA)
+	ConnectError_Certificate ConnectErrorKind = "tls_certificate"
+	// ConnectError_HandshakeTimeout - connection or TLS handshake did not finish in time
+	ConnectError_HandshakeTimeout ConnectErrorKind = "tls_handshake_timeout"
+	// ConnectError_Handshake - TLS handshake failed for other reason (ie. protocol mismatch, alert)
+	ConnectError_Handshake ConnectErrorKind = "tls_handshake"
+	// ConnectError_Rejected - server refused the websocket upgrade (most likely auth)
+	ConnectError_Rejected ConnectErrorKind = "rejected"
+	// ConnectError_Other - anything else (DNS, refused connection, ...)
+	ConnectError_Other ConnectErrorKind = "other"
+)
+
+// classifyConnectError - sorts out connection error so that it can be reported meaningfully
+func classifyConnectError(err error) ConnectErrorKind {
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certInvalidErr x509.CertificateInvalidError
+	if errors.As(err, &unknownAuthorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &certInvalidErr) {
+		return ConnectError_Certificate
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ConnectError_HandshakeTimeout
+	}
+
+	var recordHeaderErr tls.RecordHeaderError
+	if errors.As(err, &recordHeaderErr) || strings.HasPrefix(err.Error(), "tls: ") {
+		return ConnectError_Handshake
+	}
+
+	if err.Error() == "websocket: bad handshake" {
+		return ConnectError_Rejected
+	}
+
+	return ConnectError_Other
+}
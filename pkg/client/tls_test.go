@@ -0,0 +1,41 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyConnectError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected ConnectErrorKind
+	}{
+		{"unknown authority", x509.UnknownAuthorityError{}, ConnectError_Certificate},
+		{"wrapped hostname mismatch", fmt.Errorf("dial: %w", x509.HostnameError{Host: "api.nanit.com", Certificate: &x509.Certificate{}}), ConnectError_Certificate},
+		{"expired certificate", x509.CertificateInvalidError{Reason: x509.Expired}, ConnectError_Certificate},
+		{"handshake timeout", &net.OpError{Op: "dial", Err: timeoutError{}}, ConnectError_HandshakeTimeout},
+		{"not a tls server", tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}, ConnectError_Handshake},
+		{"tls alert", errors.New("tls: handshake failure"), ConnectError_Handshake},
+		{"upgrade refused", errors.New("websocket: bad handshake"), ConnectError_Rejected},
+		{"connection refused", errors.New("dial tcp: connection refused"), ConnectError_Other},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, classifyConnectError(test.err))
+		})
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
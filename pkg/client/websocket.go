@@ -4,9 +4,9 @@ import (
 	"errors"
 	"fmt"
 	sync "sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/rs/zerolog/log"
 	"github.com/sacOO7/gowebsocket"
 	"gitlab.com/adam.stanek/nanit/pkg/baby"
 	"gitlab.com/adam.stanek/nanit/pkg/session"
@@ -17,6 +17,9 @@ import (
 type readyState struct {
 	Context    utils.GracefulContext
 	Connection *WebsocketConnection
+
+	// token - auth token used for the handshake
+	token string
 }
 
 // WebsocketConnectionHandler - handler of ready connection
@@ -26,21 +29,63 @@ type WebsocketConnectionHandler func(*WebsocketConnection, utils.GracefulContext
 type WebsocketConnectionManager struct {
 	BabyUID          string
 	CameraUID        string
-	Session          *session.Session
+	SessionStore     *session.Store
 	API              *NanitClient
 	BabyStateManager *baby.StateManager
 
+	// MaxFailures - RunWithinContext gives up after this many consecutive failed connection attempts (0 = retries forever)
+	MaxFailures int
+
+	// ConnectTimeout - cam is marked as offline if the first connection is not ready within this time,
+	// connection attempts continue in the background (0 = disabled)
+	ConnectTimeout time.Duration
+
 	mu               sync.RWMutex
 	readyState       *readyState
 	readySubscribers []WebsocketConnectionHandler
+
+	statsMu sync.RWMutex
+	stats   WebsocketStats
+
+	// reauthorizeRequested - set when the server refused the session, next attempt re-authorizes even if the tries were reset
+	reauthorizeRequested int32
+
+	// websocketURL - overrides the URL of the remote websocket (tests)
+	websocketURL string
+}
+
+// WebsocketStats - connection lifecycle statistics
+type WebsocketStats struct {
+	// IsConnected - whether the connection is currently established
+	IsConnected bool
+
+	// ConnectedSince - time of the current connection (zero if not connected)
+	ConnectedSince time.Time
+
+	// LastReconnectAt - time of the last successful reconnection (zero if never reconnected)
+	LastReconnectAt time.Time
+
+	// ReconnectCount - number of successful connections after the first one
+	ReconnectCount int
+
+	numConnections int
+}
+
+// Uptime - returns duration of the current connection
+func (stats WebsocketStats) Uptime() time.Duration {
+	if !stats.IsConnected {
+		return 0
+	}
+
+	return time.Since(stats.ConnectedSince)
 }
 
 // NewWebsocketConnectionManager - constructor
-func NewWebsocketConnectionManager(babyUID string, cameraUID string, session *session.Session, api *NanitClient, babyStateManager *baby.StateManager) *WebsocketConnectionManager {
+func NewWebsocketConnectionManager(babyUID string, cameraUID string, sessionStore *session.Store, api *NanitClient, babyStateManager *baby.StateManager) *WebsocketConnectionManager {
 	manager := &WebsocketConnectionManager{
 		BabyUID:          babyUID,
 		CameraUID:        cameraUID,
-		Session:          session,
+		SessionStore:     sessionStore,
 		API:              api,
 		BabyStateManager: babyStateManager,
 	}
@@ -54,9 +99,11 @@ func NewWebsocketConnectionManager(babyUID string, cameraUID string, session *se
 				ticker.Stop()
 				return
 			case <-ticker.C:
-				conn.SendMessage(&Message{
+				if err := conn.SendMessage(&Message{
 					Type: Message_Type(Message_KEEPALIVE).Enum(),
-				})
+				}); err != nil {
+					log.Warn().Err(err).Msg("Unable to send keepalive")
+				}
 			}
 		}
 	})
@@ -77,11 +124,94 @@ func (manager *WebsocketConnectionManager) WithReadyConnection(handler Websocket
 	}
 }
 
+// Reconnect - closes current connection so that a new one is established right away
+// Returns false if there is no active connection
+func (manager *WebsocketConnectionManager) Reconnect() bool {
+	if !manager.GetStats().IsConnected {
+		return false
+	}
+
+	manager.mu.RLock()
+	readyState := manager.readyState
+	manager.mu.RUnlock()
+
+	if readyState == nil {
+		return false
+	}
+
+	log.Info().Str("baby_uid", manager.BabyUID).Msg("Reconnect requested, closing websocket")
+	readyState.Context.Fail(errReconnectRequested)
+	return true
+}
+
+var errReconnectRequested = errors.New("Reconnect requested")
+
+var errTokenRotated = errors.New("Auth token rotated")
+
+// reconnectIfTokenRotated - reconnects the ready connection if it was established with an older token
+// Note: server keeps the established connection after the rotation, but it would be bound to the token we no longer hold
+func (manager *WebsocketConnectionManager) reconnectIfTokenRotated() {
+	manager.mu.RLock()
+	readyState := manager.readyState
+	manager.mu.RUnlock()
+
+	if readyState == nil || !manager.GetStats().IsConnected {
+		return
+	}
+
+	if token, _ := manager.SessionStore.GetAuth(); token == readyState.token {
+		return
+	}
+
+	log.Info().Str("baby_uid", manager.BabyUID).Msg("Auth token was rotated, reconnecting websocket with the new one")
+	readyState.Context.Fail(errTokenRotated)
+}
+
+// GetStats - returns connection lifecycle statistics
+func (manager *WebsocketConnectionManager) GetStats() WebsocketStats {
+	manager.statsMu.RLock()
+	defer manager.statsMu.RUnlock()
+
+	return manager.stats
+}
+
+func (manager *WebsocketConnectionManager) trackConnected() {
+	now := time.Now()
+
+	manager.statsMu.Lock()
+	if manager.stats.numConnections > 0 {
+		manager.stats.ReconnectCount++
+		manager.stats.LastReconnectAt = now
+	}
+
+	manager.stats.numConnections++
+	manager.stats.IsConnected = true
+	manager.stats.ConnectedSince = now
+	manager.statsMu.Unlock()
+}
+
+func (manager *WebsocketConnectionManager) trackDisconnected() {
+	manager.statsMu.Lock()
+	manager.stats.IsConnected = false
+	manager.stats.ConnectedSince = time.Time{}
+	manager.statsMu.Unlock()
+}
+
 // RunWithinContext - starts websocket connection attempt loop
-func (manager *WebsocketConnectionManager) RunWithinContext(ctx utils.GracefulContext) {
-	utils.RunWithPerseverance(manager.run, ctx, utils.PerseverenceOpts{
+// Returns error only if MaxFailures is reached
+func (manager *WebsocketConnectionManager) RunWithinContext(ctx utils.GracefulContext) error {
+	if manager.ConnectTimeout > 0 {
+		go manager.watchConnectTimeout(ctx)
+	}
+
+	// Connections established with the old token are replaced once it is rotated (refresh, reauthorization, ...)
+	unsubscribe := manager.API.OnTokenRotated(manager.reconnectIfTokenRotated)
+	defer unsubscribe()
+
+	return utils.RunWithPerseverance(manager.run, ctx, utils.PerseverenceOpts{
 		RunnerID:       fmt.Sprintf("websocket-%v", manager.CameraUID),
 		ResetThreshold: 2 * time.Second,
+		MaxFailures:    manager.MaxFailures,
 		Cooldown: []time.Duration{
 			// 2 * time.Second,
 			30 * time.Second,
@@ -92,13 +222,38 @@ func (manager *WebsocketConnectionManager) RunWithinContext(ctx utils.GracefulCo
 	})
 }
 
+// watchConnectTimeout - marks the cam as offline if there was no connection within ConnectTimeout
+// Note: until then the websocket state of the baby is unknown, integrations would not reflect permanently offline cam
+func (manager *WebsocketConnectionManager) watchConnectTimeout(ctx utils.GracefulContext) {
+	timer := time.NewTimer(manager.ConnectTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+		manager.statsMu.RLock()
+		numConnections := manager.stats.numConnections
+		manager.statsMu.RUnlock()
+
+		if numConnections == 0 {
+			log.Warn().Str("baby_uid", manager.BabyUID).Msgf("Websocket connection not established within %v, marking cam as offline and retrying in the background", manager.ConnectTimeout)
+			manager.BabyStateManager.Update(manager.BabyUID, *baby.NewState().SetWebsocketAlive(false))
+		}
+	}
+}
+
 func (manager *WebsocketConnectionManager) run(attempt utils.AttemptContext) {
-	// Reauthorize if it is not a first try or we assume we don't have a valid token
-	manager.API.MaybeAuthorize(attempt.GetTry() > 1)
+	// Reauthorize if it is not a first try, the server refused the session or we assume we don't have a valid token
+	reauthorizeRequested := atomic.SwapInt32(&manager.reauthorizeRequested, 0) == 1
+	manager.API.MaybeAuthorize(attempt.GetTry() > 1 || reauthorizeRequested)
 
 	// Remote
 	url := fmt.Sprintf("wss://api.nanit.com/focus/cameras/%v/user_connect", manager.CameraUID)
-	auth := fmt.Sprintf("Bearer %v", manager.Session.AuthToken)
+	if manager.websocketURL != "" {
+		url = manager.websocketURL
+	}
+	token, _ := manager.SessionStore.GetAuth()
+	auth := fmt.Sprintf("Bearer %v", token)
 
 	// Local
 	// url := "wss://192.168.3.195:442"
@@ -110,14 +265,25 @@ func (manager *WebsocketConnectionManager) run(attempt utils.AttemptContext) {
 
 	socket := gowebsocket.New(url)
 	socket.RequestHeader.Set("Authorization", auth)
+	socket.ConnectionOptions.Proxy = manager.API.getProxyFunc()
+	socket.WebsocketDialer.HandshakeTimeout = websocketHandshakeTimeout
+
+	// Note: gowebsocket's UseSSL actually means "skip certificate verification"
+	socket.ConnectionOptions.UseSSL = false
 
 	// Handle new connection
 	socket.OnConnected = func(socket gowebsocket.Socket) {
 		log.Info().Str("url", url).Msg("Connected to websocket")
+		manager.trackConnected()
 
 		go func() {
 			conn := NewWebsocketConnection(&socket)
-			readyState := readyState{attempt, conn}
+			readyState := readyState{attempt, conn, token}
+
+			go func() {
+				<-attempt.Done()
+				conn.close()
+			}()
 
 			manager.mu.Lock()
 			manager.readyState = &readyState
@@ -125,6 +291,9 @@ func (manager *WebsocketConnectionManager) run(attempt utils.AttemptContext) {
 			copy(subscribedHandlers, manager.readySubscribers)
 			manager.mu.Unlock()
 
+			// Token might have been rotated during the handshake
+			manager.reconnectIfTokenRotated()
+
 			manager.BabyStateManager.Update(manager.BabyUID, *baby.NewState().SetWebsocketAlive(true))
 
 			log.Trace().Int("num_handlers", len(subscribedHandlers)).Msg("Notifying websocket ready handlers")
@@ -137,13 +306,44 @@ func (manager *WebsocketConnectionManager) run(attempt utils.AttemptContext) {
 
 	// Handle failed attempts for connection
 	socket.OnConnectError = func(err error, socket gowebsocket.Socket) {
-		log.Error().Str("url", url).Err(err).Msg("Unable to establish websocket connection")
+		kind := classifyConnectError(err)
+		sublog := log.With().Str("url", url).Str("kind", string(kind)).Err(err).Logger()
+
+		switch kind {
+		case ConnectError_Certificate:
+			sublog.Error().Msg("Unable to establish websocket connection, server certificate was not accepted (check proxy / NANIT_TLS_CA_FILE)")
+		case ConnectError_HandshakeTimeout:
+			sublog.Warn().Msg("Unable to establish websocket connection, handshake timed out")
+		case ConnectError_Rejected:
+			// Server rejected the upgrade, most likely because of the token we considered valid.
+			// Next attempt re-authorizes before connecting (see run), so that it does not start with the rejected token.
+			// Note: flagged explicitly, the try count alone is reset once the attempt outlives the reset threshold
+			// Note: gowebsocket drops the response, so the status code is not available
+			sublog.Error().Msg("Unable to establish websocket connection, server rejected it")
+			log.Info().Msg("Token might have been rejected. Will re-authenticate before the next attempt.")
+			atomic.StoreInt32(&manager.reauthorizeRequested, 1)
+		default:
+			sublog.Error().Msg("Unable to establish websocket connection")
+		}
+
 		attempt.Fail(err)
 	}
 
 	// Handle lost connection
 	socket.OnDisconnected = func(err error, socket gowebsocket.Socket) {
+		// Note: gowebsocket reports close frame twice, first only with its reason and then with the code,
+		// so the reaction to the code is not covered by once
+		if closeErr, action, ok := getCloseAction(err); ok {
+			log.Warn().Int("code", closeErr.Code).Str("reason", closeErr.Text).Str("action", string(action)).Msg("Websocket closed by server")
+			if action == CloseAction_Reauthorize {
+				// Only flagged, so that the duplicate report does not cause another login (next attempt re-authorizes)
+				log.Info().Msg("Session was refused. Will re-authenticate before the next attempt.")
+				atomic.StoreInt32(&manager.reauthorizeRequested, 1)
+			}
+		}
+
 		once.Do(func() {
+			manager.trackDisconnected()
 			manager.BabyStateManager.Update(manager.BabyUID, *baby.NewState().SetWebsocketAlive(false))
 
 			if err != nil {
@@ -182,6 +382,8 @@ func (manager *WebsocketConnectionManager) run(attempt utils.AttemptContext) {
 		log.Debug().Msg("Closing websocket")
 		socket.Close()
 	}
+
+	manager.trackDisconnected()
 }
 
 func notifyReadyHandler(handler WebsocketConnectionHandler, state readyState) {
@@ -3,12 +3,12 @@ package client
 import (
 	"errors"
 	"fmt"
+	"regexp"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
 	"github.com/sacOO7/gowebsocket"
 	"gitlab.com/adam.stanek/nanit/pkg/utils"
 	"google.golang.org/protobuf/proto"
@@ -19,7 +19,11 @@ type WebsocketMessageHandler func(*Message, *WebsocketConnection)
 
 // WebsocketConnection - ready websocket connection
 type WebsocketConnection struct {
-	socket *gowebsocket.Socket
+	// write - writes the message to the socket (blocks until written or failed)
+	write func(data []byte)
+
+	// sendTimeout - how long to wait for the message to be written (defaults to sendTimeout)
+	sendTimeout time.Duration
 
 	msgHandlersMu sync.RWMutex
 	msgHandlers   []WebsocketMessageHandler
@@ -28,14 +32,109 @@ type WebsocketConnection struct {
 	resHandlers   map[int32]unhandledRequest
 
 	lastRequestID int32
+
+	sendQueue chan outgoingMessage
+	closeC    chan struct{}
+	closeOnce sync.Once
+}
+
+type outgoingMessage struct {
+	data  []byte
+	doneC chan struct{}
+}
+
+const (
+	// sendQueueSize - max. number of messages waiting to be written to the socket
+	sendQueueSize = 32
+
+	// sendTimeout - how long to wait for the message to be written to the socket
+	sendTimeout = 10 * time.Second
+)
+
+var (
+	// ErrSendQueueFull - outgoing queue is full (connection is likely wedged)
+	ErrSendQueueFull = errors.New("Send queue is full")
+
+	// ErrSendTimeout - message was not written to the socket in time
+	ErrSendTimeout = errors.New("Send timeout")
+
+	// ErrConnectionClosed - connection has been closed already
+	ErrConnectionClosed = errors.New("Connection closed")
+)
+
+// ResponseError - cam responded to the request with other than 200 status code
+type ResponseError struct {
+	StatusCode    int32
+	StatusMessage string
+}
+
+func (err *ResponseError) Error() string {
+	if err.StatusMessage != "" {
+		return err.StatusMessage
+	}
+
+	return fmt.Sprintf("Unexpected status code %v", err.StatusCode)
+}
+
+// alreadyStreamingRX - status message of the cam rejecting the streaming request because it streams for another client
+// Note: the response is neither documented nor captured, both the 409 status code and the message are guesses matched loosely.
+// Rejections which do not match are logged with the raw status (see requestLocalStreaming), so that a wrong guess shows up.
+var alreadyStreamingRX = regexp.MustCompile(`(?i)already\s+(streaming|started|in progress)`)
+
+// IsAlreadyStreamingError - returns true if the streaming request was rejected because the cam is already streaming elsewhere
+func IsAlreadyStreamingError(err error) bool {
+	var resErr *ResponseError
+	if !errors.As(err, &resErr) {
+		return false
+	}
+
+	return resErr.StatusCode == 409 || alreadyStreamingRX.MatchString(resErr.StatusMessage)
 }
 
 // NewWebsocketConnection - constructor
 func NewWebsocketConnection(socket *gowebsocket.Socket) *WebsocketConnection {
-	return &WebsocketConnection{
-		socket:        socket,
+	return newWebsocketConnection(func(data []byte) {
+		// Deadline makes the write fail instead of hanging on a half-dead connection
+		if socket.Conn != nil {
+			socket.Conn.SetWriteDeadline(time.Now().Add(sendTimeout))
+		}
+
+		socket.SendBinary(data)
+	}, sendTimeout)
+}
+
+func newWebsocketConnection(write func(data []byte), sendTimeout time.Duration) *WebsocketConnection {
+	conn := &WebsocketConnection{
+		write:         write,
+		sendTimeout:   sendTimeout,
 		resHandlers:   make(map[int32]unhandledRequest),
 		lastRequestID: 0,
+		sendQueue:     make(chan outgoingMessage, sendQueueSize),
+		closeC:        make(chan struct{}),
+	}
+
+	go conn.runWriter()
+
+	return conn
+}
+
+// close - stops the writer, pending and further sends will fail
+func (conn *WebsocketConnection) close() {
+	conn.closeOnce.Do(func() {
+		close(conn.closeC)
+	})
+}
+
+// runWriter - writes queued messages one by one so that a wedged socket does not block the senders
+func (conn *WebsocketConnection) runWriter() {
+	for {
+		select {
+		case <-conn.closeC:
+			return
+		case m := <-conn.sendQueue:
+			conn.write(m.data)
+			close(m.doneC)
+		}
 	}
 }
 
@@ -46,9 +145,9 @@ func (conn *WebsocketConnection) RegisterMessageHandler(handler WebsocketMessage
 	conn.msgHandlersMu.Unlock()
 }
 
-// SendMessage - low-level helper for sending raw message
+// SendMessage - low-level helper for sending raw message, returns error if the message could not be written in time
 // Note: Use SendRequest() for requests
-func (conn *WebsocketConnection) SendMessage(m *Message) {
+func (conn *WebsocketConnection) SendMessage(m *Message) error {
 	var msg *zerolog.Event
 
 	if *m.Type == Message_KEEPALIVE {
@@ -62,10 +161,38 @@ func (conn *WebsocketConnection) SendMessage(m *Message) {
 	bytes := getMessageBytes(m)
 	log.Trace().Bytes("rawdata", bytes).Msg("Sending data")
 
-	conn.socket.SendBinary(bytes)
+	out := outgoingMessage{data: bytes, doneC: make(chan struct{})}
+
+	// Checked first, select below picks randomly if the queue has space as well
+	select {
+	case <-conn.closeC:
+		return ErrConnectionClosed
+	default:
+	}
+
+	select {
+	case <-conn.closeC:
+		return ErrConnectionClosed
+	case conn.sendQueue <- out:
+	default:
+		return ErrSendQueueFull
+	}
+
+	timer := time.NewTimer(conn.sendTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-out.doneC:
+		return nil
+	case <-conn.closeC:
+		return ErrConnectionClosed
+	case <-timer.C:
+		return ErrSendTimeout
+	}
 }
 
 // SendRequest - sends request to the cam and returns await function. Await function waits for the response and returns it
+// If the request could not be sent, await function returns the send error right away
 func (conn *WebsocketConnection) SendRequest(reqType RequestType, requestData *Request) func(time.Duration) (*Response, error) {
 	// Build request
 	id := atomic.AddInt32(&conn.lastRequestID, 1)
@@ -96,7 +223,17 @@ func (conn *WebsocketConnection) SendRequest(reqType RequestType, requestData *R
 	conn.resHandlersMu.Unlock()
 
 	// Send request
-	conn.SendMessage(m)
+	if err := conn.SendMessage(m); err != nil {
+		log.Warn().Err(err).Stringer("type", reqType).Msg("Unable to send request")
+
+		conn.resHandlersMu.Lock()
+		delete(conn.resHandlers, id)
+		conn.resHandlersMu.Unlock()
+
+		return func(time.Duration) (*Response, error) {
+			return nil, err
+		}
+	}
 
 	// Return awaiter
 	return func(timeout time.Duration) (*Response, error) {
@@ -113,11 +250,7 @@ func (conn *WebsocketConnection) SendRequest(reqType RequestType, requestData *R
 			if res.StatusCode == nil {
 				return res, errors.New("No status code received")
 			} else if *res.StatusCode != 200 {
-				if res.GetStatusMessage() != "" {
-					return res, errors.New(res.GetStatusMessage())
-				}
-
-				return res, fmt.Errorf("Unexpected status code %v", *res.StatusCode)
+				return res, &ResponseError{StatusCode: *res.StatusCode, StatusMessage: res.GetStatusMessage()}
 			}
 
 			return res, nil
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsAlreadyStreamingError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{&ResponseError{StatusCode: 409}, true},
+		{&ResponseError{StatusCode: 400, StatusMessage: "Already streaming"}, true},
+		{fmt.Errorf("wrapped: %w", &ResponseError{StatusCode: 500, StatusMessage: "stream already in progress"}), true},
+		{&ResponseError{StatusCode: 500, StatusMessage: "Internal error"}, false},
+		{&ResponseError{StatusCode: 400}, false},
+		{errors.New("Already streaming"), false},
+		{errors.New("Request timeout"), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.err.Error(), func(t *testing.T) {
+			assert.Equal(t, test.expected, IsAlreadyStreamingError(test.err))
+		})
+	}
+
+	assert.Equal(t, "Unexpected status code 400", (&ResponseError{StatusCode: 400}).Error())
+}
+
+// newStalledWebsocketConnection - returns connection whose writes block until released
+func newStalledWebsocketConnection(t *testing.T, sendTimeout time.Duration) *WebsocketConnection {
+	releaseC := make(chan struct{})
+	conn := newWebsocketConnection(func(data []byte) { <-releaseC }, sendTimeout)
+
+	t.Cleanup(func() {
+		conn.close()
+		close(releaseC)
+	})
+
+	return conn
+}
+
+func keepaliveMessage() *Message {
+	return &Message{Type: Message_KEEPALIVE.Enum()}
+}
+
+func TestSendMessage(t *testing.T) {
+	conn := newWebsocketConnection(func(data []byte) {}, time.Second)
+	defer conn.close()
+
+	assert.NoError(t, conn.SendMessage(keepaliveMessage()))
+}
+
+func TestSendMessageTimeout(t *testing.T) {
+	conn := newStalledWebsocketConnection(t, 20*time.Millisecond)
+	assert.Equal(t, ErrSendTimeout, conn.SendMessage(keepaliveMessage()))
+}
+
+func TestSendMessageQueueFull(t *testing.T) {
+	conn := newStalledWebsocketConnection(t, time.Hour)
+
+	// One message is stuck in the writer, the rest fills the queue
+	var wg sync.WaitGroup
+	errs := make(chan error, sendQueueSize+1)
+	for i := 0; i < sendQueueSize+1; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- conn.SendMessage(keepaliveMessage())
+		}()
+	}
+
+	require.Eventually(t, func() bool { return len(conn.sendQueue) == sendQueueSize }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, ErrSendQueueFull, conn.SendMessage(keepaliveMessage()))
+
+	// Waiting senders are released by close
+	conn.close()
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.Equal(t, ErrConnectionClosed, err)
+	}
+}
+
+func TestSendMessageClosed(t *testing.T) {
+	conn := newWebsocketConnection(func(data []byte) {}, time.Second)
+	conn.close()
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, ErrConnectionClosed, conn.SendMessage(keepaliveMessage()))
+	}
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+	"gitlab.com/adam.stanek/nanit/pkg/session"
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+func TestWatchConnectTimeout(t *testing.T) {
+	run := func(connected bool) *baby.State {
+		manager := &WebsocketConnectionManager{
+			BabyUID:          "baby1",
+			BabyStateManager: baby.NewStateManager(),
+			ConnectTimeout:   10 * time.Millisecond,
+		}
+
+		if connected {
+			manager.trackConnected()
+		}
+
+		runner := utils.RunWithGracefulCancel(manager.watchConnectTimeout)
+		time.Sleep(50 * time.Millisecond)
+		runner.Cancel()
+
+		return manager.BabyStateManager.GetBabyState("baby1")
+	}
+
+	state := run(false)
+	if assert.NotNil(t, state.IsWebsocketAlive) {
+		assert.False(t, *state.IsWebsocketAlive)
+	}
+
+	assert.Nil(t, run(true).IsWebsocketAlive)
+}
+
+func TestTokenRotationReconnects(t *testing.T) {
+	if raceEnabled {
+		t.Skip("Reconnect closes the socket, gowebsocket's Close races with its own read loop")
+	}
+
+	var numLogins int32
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"access_token":"token-%v"}`, atomic.AddInt32(&numLogins, 1))
+	}))
+	defer apiServer.Close()
+
+	var handshakesMu sync.Mutex
+	var handshakes []string
+	upgrader := websocket.Upgrader{}
+	wsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handshakesMu.Lock()
+		handshakes = append(handshakes, r.Header.Get("Authorization"))
+		handshakesMu.Unlock()
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer wsServer.Close()
+
+	getHandshakes := func() []string {
+		handshakesMu.Lock()
+		defer handshakesMu.Unlock()
+		return append([]string{}, handshakes...)
+	}
+
+	store := session.NewSessionStore()
+	api := &NanitClient{SessionStore: store, TokenRefreshMargin: time.Minute, apiURL: apiServer.URL}
+	manager := NewWebsocketConnectionManager("baby1", "cam1", store, api, baby.NewStateManager())
+	manager.websocketURL = "ws" + strings.TrimPrefix(wsServer.URL, "http")
+
+	// Note: the connection is intentionally left open, gowebsocket's Close races with its own read loop (reported by -race)
+	utils.RunWithGracefulCancel(func(ctx utils.GracefulContext) {
+		manager.RunWithinContext(ctx)
+	})
+
+	require.Eventually(t, func() bool { return manager.GetStats().IsConnected }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"Bearer token-1"}, getHandshakes())
+
+	// Connection younger than the reset threshold of the runner would be retried only after the cooldown
+	time.Sleep(2 * time.Second)
+
+	// Token rotated mid-session, connection is replaced by the one using the new token (without another login)
+	require.NoError(t, api.TryAuthorize())
+
+	require.Eventually(t, func() bool {
+		stats := manager.GetStats()
+		return stats.IsConnected && stats.ReconnectCount == 1
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, []string{"Bearer token-1", "Bearer token-2"}, getHandshakes())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&numLogins))
+}
+
+// Rejected handshake flags reauthorization, so that it does not depend on the try count (reset after ResetThreshold)
+func TestRejectedHandshakeRequestsReauthorize(t *testing.T) {
+	wsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer wsServer.Close()
+
+	store := session.NewSessionStore()
+	store.SetAuth("stored-token", time.Now())
+
+	api := &NanitClient{SessionStore: store, TokenRefreshMargin: time.Minute}
+	manager := NewWebsocketConnectionManager("baby1", "cam1", store, api, baby.NewStateManager())
+	manager.websocketURL = "ws" + strings.TrimPrefix(wsServer.URL, "http")
+
+	runner := utils.RunWithGracefulCancel(func(ctx utils.GracefulContext) {
+		manager.RunWithinContext(ctx)
+	})
+	defer runner.Cancel()
+
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&manager.reauthorizeRequested) == 1 }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,28 @@
+package history
+
+import (
+	"errors"
+	"time"
+)
+
+// Opts - options of the history store
+type Opts struct {
+	// Dir - directory for the history files, each baby has its own subdirectory
+	Dir string
+
+	// Retention - records older than this are removed (0 = keep forever)
+	Retention time.Duration
+}
+
+// Validate - checks the options, returns descriptive error if they are not valid
+func (opts Opts) Validate() error {
+	if opts.Dir == "" {
+		return errors.New("directory is required")
+	}
+
+	if opts.Retention < 0 {
+		return errors.New("retention cannot be negative")
+	}
+
+	return nil
+}
@@ -0,0 +1,284 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+// Record - value of a single state key at given time
+type Record struct {
+	Time  time.Time   `json:"time"`
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+// fileVersion - format of the history files
+// Note: increment it whenever the record format changes, files of other versions are skipped when reading
+// and removed by the retention
+const fileVersion = 1
+
+type fileHeader struct {
+	Version int `json:"version"`
+}
+
+// dayLayout - history files are split per UTC day ({dir}/{baby_uid}/{day}.jsonl), so that retention just removes whole files
+const dayLayout = "2006-01-02"
+
+// cleanupInterval - how often are the expired files removed
+const cleanupInterval = time.Hour
+
+// Store - append-only history of the baby states stored as JSON lines
+type Store struct {
+	Opts Opts
+
+	mu    sync.Mutex
+	files map[string]*dayFile
+}
+
+type dayFile struct {
+	day  string
+	file *os.File
+}
+
+// NewStore - constructor
+func NewStore(opts Opts) *Store {
+	return &Store{
+		Opts:  opts,
+		files: make(map[string]*dayFile),
+	}
+}
+
+// Run - records the state updates until the context is done
+func (store *Store) Run(manager *baby.StateManager, ctx utils.GracefulContext) {
+	unsubscribe := manager.Subscribe(func(babyUID string, state baby.State) {
+		if err := store.Append(babyUID, getRecords(time.Now(), state)); err != nil {
+			log.Warn().Str("baby_uid", babyUID).Err(err).Msg("Unable to record history")
+		}
+	})
+
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	store.cleanup(time.Now())
+
+	for {
+		select {
+		case <-ctx.Done():
+			unsubscribe()
+			store.Close()
+			return
+		case now := <-ticker.C:
+			store.cleanup(now)
+		}
+	}
+}
+
+// getRecords - converts state update to records (same keys as published to MQTT)
+func getRecords(now time.Time, state baby.State) []Record {
+	var records []Record
+	for key, value := range state.AsMap(false) {
+		records = append(records, Record{Time: now, Key: key, Value: value})
+	}
+
+	if state.StreamState != nil && *state.StreamState != baby.StreamState_Unknown {
+		records = append(records, Record{Time: now, Key: "is_stream_alive", Value: *state.StreamState == baby.StreamState_Alive})
+	}
+
+	if state.IsWebsocketAlive != nil {
+		records = append(records, Record{Time: now, Key: "is_websocket_alive", Value: *state.IsWebsocketAlive})
+	}
+
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+	return records
+}
+
+// Append - writes records of the baby
+func (store *Store) Append(babyUID string, records []Record) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, record := range records {
+		f, err := store.getFile(babyUID, record.Time.UTC().Format(dayLayout))
+		if err != nil {
+			return err
+		}
+
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+
+		if _, err := f.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getFile - returns file of the day for appending, must be called with mu held
+func (store *Store) getFile(babyUID string, day string) (*os.File, error) {
+	if current, ok := store.files[babyUID]; ok {
+		if current.day == day {
+			return current.file, nil
+		}
+
+		current.file.Close()
+		delete(store.files, babyUID)
+	}
+
+	dir := filepath.Join(store.Opts.Dir, babyUID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, day+".jsonl"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if info, err := f.Stat(); err != nil {
+		f.Close()
+		return nil, err
+	} else if info.Size() == 0 {
+		header, _ := json.Marshal(fileHeader{Version: fileVersion})
+		if _, err := f.Write(append(header, '\n')); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	store.files[babyUID] = &dayFile{day: day, file: f}
+	return f, nil
+}
+
+// Query - returns records of the baby within given time range ordered by time (empty key = all keys)
+func (store *Store) Query(babyUID string, key string, from time.Time, to time.Time) ([]Record, error) {
+	records := make([]Record, 0)
+
+	// Nothing is kept past the retention, no need to look for the files
+	if oldest := time.Now().Add(-store.Opts.Retention - 24*time.Hour); store.Opts.Retention > 0 && from.Before(oldest) {
+		from = oldest
+	}
+
+	for day := from.UTC().Truncate(24 * time.Hour); !day.After(to); day = day.Add(24 * time.Hour) {
+		dayRecords, err := readFile(filepath.Join(store.Opts.Dir, babyUID, day.Format(dayLayout)+".jsonl"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, record := range dayRecords {
+			if (key == "" || record.Key == key) && !record.Time.Before(from) && !record.Time.After(to) {
+				records = append(records, record)
+			}
+		}
+	}
+
+	return records, nil
+}
+
+// readFile - returns records of a single file, missing files and files of other versions yield no records
+// Note: malformed lines (ie. partially written on crash) are skipped
+func readFile(filename string) ([]Record, error) {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return nil, scanner.Err()
+	}
+
+	var header fileHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Version != fileVersion {
+		log.Debug().Str("file", filename).Int("version", header.Version).Msg("Skipping history file of unsupported version")
+		return nil, nil
+	}
+
+	var records []Record
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err == nil {
+			records = append(records, record)
+		}
+	}
+
+	return records, scanner.Err()
+}
+
+// cleanup - removes files of the days which are completely past the retention
+func (store *Store) cleanup(now time.Time) {
+	if store.Opts.Retention <= 0 {
+		return
+	}
+
+	babyDirs, err := ioutil.ReadDir(store.Opts.Dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn().Err(err).Msg("Unable to list history directory")
+		}
+
+		return
+	}
+
+	threshold := now.Add(-store.Opts.Retention)
+	numRemoved := 0
+
+	for _, babyDir := range babyDirs {
+		if !babyDir.IsDir() {
+			continue
+		}
+
+		files, err := ioutil.ReadDir(filepath.Join(store.Opts.Dir, babyDir.Name()))
+		if err != nil {
+			log.Warn().Err(err).Msg("Unable to list history directory")
+			continue
+		}
+
+		for _, file := range files {
+			day, err := time.Parse(dayLayout, strings.TrimSuffix(file.Name(), ".jsonl"))
+			if err != nil || !day.Add(24*time.Hour).Before(threshold) {
+				continue
+			}
+
+			if err := os.Remove(filepath.Join(store.Opts.Dir, babyDir.Name(), file.Name())); err != nil {
+				log.Warn().Err(err).Str("file", file.Name()).Msg("Unable to remove expired history file")
+			} else {
+				numRemoved++
+			}
+		}
+	}
+
+	if numRemoved > 0 {
+		log.Debug().Int("num_files", numRemoved).Msg("Removed expired history files")
+	}
+}
+
+// Close - closes the open files
+func (store *Store) Close() {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for babyUID, current := range store.files {
+		if err := current.file.Close(); err != nil {
+			log.Warn().Err(err).Str("baby_uid", babyUID).Str("day", current.day).Msg("Unable to close history file")
+		}
+	}
+
+	store.files = make(map[string]*dayFile)
+}
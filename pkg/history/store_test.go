@@ -0,0 +1,55 @@
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nanit-history-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := NewStore(Opts{Dir: dir, Retention: 48 * time.Hour})
+	now := time.Now().UTC()
+	yesterday := now.Add(-24 * time.Hour)
+
+	assert.NoError(t, store.Append("baby1", getRecords(yesterday, *baby.NewState().SetTemperatureMilli(21500).SetIsNight(true))))
+	assert.NoError(t, store.Append("baby1", getRecords(now, *baby.NewState().SetTemperatureMilli(22000).SetStreamState(baby.StreamState_Alive))))
+	assert.NoError(t, store.Append("baby2", getRecords(now, *baby.NewState().SetTemperatureMilli(19000))))
+	store.Close()
+
+	records, err := store.Query("baby1", "temperature", now.Add(-48*time.Hour), now)
+	assert.NoError(t, err)
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, 21.5, records[0].Value)
+		assert.Equal(t, 22.0, records[1].Value)
+		assert.True(t, records[1].Time.Equal(now))
+	}
+
+	records, err = store.Query("baby1", "", now.Add(-time.Hour), now)
+	assert.NoError(t, err)
+	assert.Equal(t, []Record{
+		{Time: records[0].Time, Key: "is_stream_alive", Value: true},
+		{Time: records[0].Time, Key: "temperature", Value: 22.0},
+	}, records)
+
+	// Files of unsupported version are skipped
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "baby2", now.Format(dayLayout)+".jsonl"), []byte("{\"version\":99}\n{}\n"), 0644))
+	records, err = store.Query("baby2", "", now.Add(-time.Hour), now)
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+
+	// Retention removes whole days
+	store.cleanup(now.Add(48 * time.Hour))
+	_, err = os.Stat(filepath.Join(dir, "baby1", yesterday.Format(dayLayout)+".jsonl"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "baby1", now.Format(dayLayout)+".jsonl"))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,116 @@
+package homekit
+
+import (
+	"hash/fnv"
+
+	"github.com/brutella/hc"
+	"github.com/brutella/hc/accessory"
+	"github.com/brutella/hc/service"
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+// Bridge - exposes sensors of the babies as HomeKit accessories
+type Bridge struct {
+	Opts Opts
+}
+
+type babyAccessory struct {
+	*accessory.Accessory
+
+	temperature *service.TemperatureSensor
+	humidity    *service.HumiditySensor
+}
+
+// NewBridge - constructor
+func NewBridge(opts Opts) *Bridge {
+	return &Bridge{
+		Opts: opts,
+	}
+}
+
+// Run - starts HAP server and keeps the accessories in sync with the baby state until the context is done
+// Note: failure of the bridge is logged but does not affect the rest of the application
+func (bridge *Bridge) Run(manager *baby.StateManager, babies []baby.Baby, ctx utils.GracefulContext) {
+	hcBridge := accessory.NewBridge(accessory.Info{
+		Name:         "Nanit",
+		Manufacturer: "Nanit",
+		ID:           1,
+	})
+
+	accessories := make(map[string]*babyAccessory)
+	var hcAccessories []*accessory.Accessory
+
+	for _, babyInfo := range babies {
+		acc := newBabyAccessory(babyInfo)
+		accessories[babyInfo.UID] = acc
+		hcAccessories = append(hcAccessories, acc.Accessory)
+	}
+
+	transport, err := hc.NewIPTransport(hc.Config{
+		Pin:         bridge.Opts.Pin,
+		Port:        bridge.Opts.Port,
+		StoragePath: bridge.Opts.StorageDir,
+	}, hcBridge.Accessory, hcAccessories...)
+
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to start HomeKit bridge")
+		return
+	}
+
+	unsubscribe := manager.Subscribe(func(babyUID string, state baby.State) {
+		if acc, ok := accessories[babyUID]; ok {
+			acc.update(state)
+		}
+	})
+
+	go transport.Start()
+	log.Info().Int("num_accessories", len(hcAccessories)).Msg("HomeKit bridge started")
+
+	<-ctx.Done()
+
+	unsubscribe()
+	<-transport.Stop()
+	log.Debug().Msg("HomeKit bridge stopped")
+}
+
+func newBabyAccessory(babyInfo baby.Baby) *babyAccessory {
+	acc := &babyAccessory{
+		Accessory: accessory.New(accessory.Info{
+			Name:         babyInfo.Name,
+			SerialNumber: babyInfo.CameraUID,
+			Manufacturer: "Nanit",
+			ID:           getAccessoryID(babyInfo.UID),
+		}, accessory.TypeSensor),
+		temperature: service.NewTemperatureSensor(),
+		humidity:    service.NewHumiditySensor(),
+	}
+
+	acc.AddService(acc.temperature.Service)
+	acc.AddService(acc.humidity.Service)
+
+	return acc
+}
+
+func (acc *babyAccessory) update(state baby.State) {
+	if state.TemperatureMilli != nil {
+		acc.temperature.CurrentTemperature.SetValue(float64(*state.TemperatureMilli) / 1000)
+	}
+
+	if state.HumidityMilli != nil {
+		acc.humidity.CurrentRelativeHumidity.SetValue(float64(*state.HumidityMilli) / 1000)
+	}
+}
+
+// getAccessoryID - accessory IDs have to be stable across restarts, otherwise HomeKit loses the settings (rooms, names)
+// Note: ID 1 is reserved for the bridge itself
+func getAccessoryID(babyUID string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(babyUID))
+
+	if id := h.Sum64(); id > 1 {
+		return id
+	}
+
+	return 2
+}
@@ -0,0 +1,39 @@
+package homekit
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/brutella/hc"
+)
+
+// Opts - options of the HomeKit bridge
+type Opts struct {
+	// Pin which has to be entered when pairing the bridge (8 digits)
+	Pin string
+
+	// Port of the HAP server (empty = random port)
+	Port string
+
+	// Directory for pairing data (has to be persistent, otherwise bridge has to be paired again after restart)
+	StorageDir string
+}
+
+// Validate - checks the options, returns descriptive error if they are not valid
+func (opts Opts) Validate() error {
+	if _, err := hc.ValidatePin(opts.Pin); err != nil {
+		return fmt.Errorf("invalid pin: %v", err)
+	}
+
+	if opts.Port != "" {
+		if port, err := strconv.Atoi(opts.Port); err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid port %q", opts.Port)
+		}
+	}
+
+	if opts.StorageDir == "" {
+		return fmt.Errorf("storage directory is required")
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// CommandHandler - executes command received for the baby, returns error if it failed
+type CommandHandler func(babyUID string, command string) error
+
+type commandResult struct {
+	Command string    `json:"command"`
+	Success bool      `json:"success"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// getCommandBabyUID - extracts baby UID from {prefix}/babies/{uid}/command
+func getCommandBabyUID(topicPrefix string, topic string) (string, bool) {
+	babyUID := strings.TrimSuffix(strings.TrimPrefix(topic, topicPrefix+"/babies/"), "/command")
+	if babyUID == "" || babyUID == topic || strings.Contains(babyUID, "/") {
+		return "", false
+	}
+
+	return babyUID, true
+}
+
+// subscribeCommands - passes commands of the known babies to the handler and publishes the results to {prefix}/babies/{uid}/command/result
+func (conn *Connection) subscribeCommands(client MQTT.Client, babyUIDs map[string]string) func() {
+	topic := fmt.Sprintf("%v/babies/+/command", conn.Opts.TopicPrefix)
+
+	err := conn.subscribe(client, topic, 1, func(client MQTT.Client, msg MQTT.Message) {
+		babyUID, ok := getCommandBabyUID(conn.Opts.TopicPrefix, msg.Topic())
+		if _, known := babyUIDs[babyUID]; !ok || !known {
+			log.Warn().Str("topic", msg.Topic()).Msg("Received command for unknown baby")
+			return
+		}
+
+		command := strings.TrimSpace(string(msg.Payload()))
+		log.Info().Str("baby_uid", babyUID).Str("command", command).Msg("Received MQTT command")
+
+		// Not blocking the message handler of the client (commands might take a while)
+		go func() {
+			result := commandResult{Command: command, Success: true}
+			if err := conn.CommandHandler(babyUID, command); err != nil {
+				log.Warn().Str("baby_uid", babyUID).Str("command", command).Err(err).Msg("MQTT command failed")
+				result.Success = false
+				result.Error = err.Error()
+			}
+
+			result.Time = time.Now()
+			payload, _ := json.Marshal(result)
+			conn.publish(client, TopicCategory_CommandResult, fmt.Sprintf("%v/babies/%v/command/result", conn.Opts.TopicPrefix, babyUID), payload)
+		}()
+	})
+
+	if err != nil {
+		log.Error().Err(err).Str("topic", topic).Msg("Unable to subscribe to MQTT commands")
+	}
+
+	return func() {
+		conn.unsubscribe(client, topic)
+	}
+}
@@ -0,0 +1,22 @@
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetCommandBabyUID(t *testing.T) {
+	babyUID, ok := getCommandBabyUID("nanit", "nanit/babies/abc123/command")
+	assert.True(t, ok)
+	assert.Equal(t, "abc123", babyUID)
+
+	_, ok = getCommandBabyUID("nanit", "nanit/babies//command")
+	assert.False(t, ok)
+
+	_, ok = getCommandBabyUID("nanit", "other/babies/abc123/command")
+	assert.False(t, ok)
+
+	_, ok = getCommandBabyUID("nanit", "nanit/babies/abc123/extra/command")
+	assert.False(t, ok)
+}
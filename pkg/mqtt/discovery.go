@@ -0,0 +1,171 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+)
+
+const (
+	// TemperatureUnit_Celsius - temperature published in °C (as reported by the cam)
+	TemperatureUnit_Celsius = "C"
+	// TemperatureUnit_Fahrenheit - temperature published in °F
+	TemperatureUnit_Fahrenheit = "F"
+)
+
+// convertTemperature - converts temperature reported by the cam (°C) to the configured unit
+func convertTemperature(celsius float64, unit string) float64 {
+	if unit == TemperatureUnit_Fahrenheit {
+		return celsius*9/5 + 32
+	}
+
+	return celsius
+}
+
+func getTemperatureUnitOfMeasurement(unit string) string {
+	if unit == TemperatureUnit_Fahrenheit {
+		return "°F"
+	}
+
+	return "°C"
+}
+
+type discoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	SWVersion    string   `json:"sw_version,omitempty"`
+}
+
+type discoveryAvailability struct {
+	Topic string `json:"topic"`
+}
+
+type discoveryConfig struct {
+	Name              string                  `json:"name"`
+	UniqueID          string                  `json:"unique_id"`
+	StateTopic        string                  `json:"state_topic"`
+	CommandTopic      string                  `json:"command_topic,omitempty"`
+	Availability      []discoveryAvailability `json:"availability"`
+	AvailabilityMode  string                  `json:"availability_mode"`
+	DeviceClass       string                  `json:"device_class,omitempty"`
+	StateClass        string                  `json:"state_class,omitempty"`
+	UnitOfMeasurement string                  `json:"unit_of_measurement,omitempty"`
+	ValueTemplate     string                  `json:"value_template,omitempty"`
+	PayloadOn         string                  `json:"payload_on,omitempty"`
+	PayloadOff        string                  `json:"payload_off,omitempty"`
+	StateOn           string                  `json:"state_on,omitempty"`
+	StateOff          string                  `json:"state_off,omitempty"`
+	Icon              string                  `json:"icon,omitempty"`
+	Device            discoveryDevice         `json:"device"`
+}
+
+// getDiscoveryConfigs - returns Home Assistant discovery payloads (topic => payload) for the sensors, binary sensors and switches of a baby
+// Note: units have to match the published values, otherwise Home Assistant displays them wrong
+func getDiscoveryConfigs(opts Opts, babyUID string, babyName string, capabilities baby.Capabilities) map[string][]byte {
+	device := discoveryDevice{
+		Identifiers:  []string{"nanit_" + babyUID},
+		Name:         fmt.Sprintf("Nanit %v", babyName),
+		Manufacturer: "Nanit",
+		SWVersion:    opts.SoftwareVersion,
+	}
+
+	topic := func(key string) string {
+		return fmt.Sprintf("%v/babies/%v/%v", opts.TopicPrefix, babyUID, key)
+	}
+
+	// Entities are available only while both the app (covered by the last will) and the baby are online
+	availability := []discoveryAvailability{{Topic: opts.GetAvailabilityTopic()}, {Topic: topic("availability")}}
+	const availabilityMode = "all"
+
+	sensors := map[string]discoveryConfig{
+		"temperature": {
+			Name:              fmt.Sprintf("%v Temperature", device.Name),
+			DeviceClass:       "temperature",
+			UnitOfMeasurement: getTemperatureUnitOfMeasurement(opts.TemperatureUnit),
+			ValueTemplate:     "{{ value | round(1) }}",
+		},
+		"humidity": {
+			Name:              fmt.Sprintf("%v Humidity", device.Name),
+			DeviceClass:       "humidity",
+			UnitOfMeasurement: "%",
+			ValueTemplate:     "{{ value | round(0) }}",
+		},
+	}
+
+	configs := make(map[string][]byte)
+	for key, config := range sensors {
+		config.UniqueID = fmt.Sprintf("nanit_%v_%v", babyUID, key)
+		config.StateTopic = topic(key)
+		config.Availability = availability
+		config.AvailabilityMode = availabilityMode
+		config.StateClass = "measurement"
+		config.Device = device
+
+		payload, _ := json.Marshal(config)
+		configs[fmt.Sprintf("%v/sensor/nanit_%v/%v/config", opts.DiscoveryPrefix, babyUID, key)] = payload
+	}
+
+	// Night mode (cam switched to IR), cams which do not report it leave the entity unknown
+	binarySensors := map[string]discoveryConfig{
+		"is_night": {
+			Name:       fmt.Sprintf("%v Night Mode", device.Name),
+			PayloadOn:  "true",
+			PayloadOff: "false",
+			Icon:       "mdi:weather-night",
+		},
+	}
+
+	for key, config := range binarySensors {
+		config.UniqueID = fmt.Sprintf("nanit_%v_%v", babyUID, key)
+		config.StateTopic = topic(key)
+		config.Availability = availability
+		config.AvailabilityMode = availabilityMode
+		config.Device = device
+
+		payload, _ := json.Marshal(config)
+		configs[fmt.Sprintf("%v/binary_sensor/nanit_%v/%v/config", opts.DiscoveryPrefix, babyUID, key)] = payload
+	}
+
+	// Night light can be switched only through the commands, state is unknown until it is switched by us or other client
+	if opts.Commands && capabilities.HasNightLight() {
+		config := discoveryConfig{
+			Name:             fmt.Sprintf("%v Night Light", device.Name),
+			UniqueID:         fmt.Sprintf("nanit_%v_night_light", babyUID),
+			StateTopic:       topic("night_light"),
+			CommandTopic:     topic("command"),
+			Availability:     availability,
+			AvailabilityMode: availabilityMode,
+			PayloadOn:        "night_light_on",
+			PayloadOff:       "night_light_off",
+			StateOn:          "true",
+			StateOff:         "false",
+			Icon:             "mdi:lightbulb-night",
+			Device:           device,
+		}
+
+		payload, _ := json.Marshal(config)
+		configs[fmt.Sprintf("%v/switch/nanit_%v/night_light/config", opts.DiscoveryPrefix, babyUID)] = payload
+	}
+
+	return configs
+}
+
+// getDiscoveryBabies - returns state keys of all the cameras with a display name
+func getDiscoveryBabies(babies []baby.Baby) map[string]string {
+	names := make(map[string]string)
+	for _, babyInfo := range babies {
+		cameraUIDs := babyInfo.GetCameraUIDs()
+		for i, cameraUID := range cameraUIDs {
+			name := babyInfo.Name
+			if i > 0 {
+				name = fmt.Sprintf("%v (%v)", babyInfo.Name, i+1)
+			}
+
+			names[babyInfo.GetStateKey(cameraUID)] = name
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,58 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+func TestDiscoveryConfigUnits(t *testing.T) {
+	tests := []struct {
+		unit                string
+		expectedTemperature string
+		expectedValue       float64
+	}{
+		{"", "°C", 20},
+		{TemperatureUnit_Celsius, "°C", 20},
+		{TemperatureUnit_Fahrenheit, "°F", 68},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expectedTemperature, func(t *testing.T) {
+			opts := Opts{TopicPrefix: "nanit", TemperatureUnit: test.unit, Discovery: true, Commands: true, DiscoveryPrefix: "homeassistant", SoftwareVersion: "1.0.0 (abc1234)"}
+			configs := getDiscoveryConfigs(opts, "baby1", "Baby", baby.Capabilities{})
+
+			var temperature, humidity discoveryConfig
+			assert.NoError(t, json.Unmarshal(configs["homeassistant/sensor/nanit_baby1/temperature/config"], &temperature))
+			assert.NoError(t, json.Unmarshal(configs["homeassistant/sensor/nanit_baby1/humidity/config"], &humidity))
+
+			assert.Equal(t, "temperature", temperature.DeviceClass)
+			assert.Equal(t, test.expectedTemperature, temperature.UnitOfMeasurement)
+			assert.Equal(t, "nanit/babies/baby1/temperature", temperature.StateTopic)
+			assert.Equal(t, []discoveryAvailability{{"nanit/availability"}, {"nanit/babies/baby1/availability"}}, temperature.Availability)
+			assert.Equal(t, "all", temperature.AvailabilityMode)
+			assert.Equal(t, "humidity", humidity.DeviceClass)
+			assert.Equal(t, "%", humidity.UnitOfMeasurement)
+			assert.Equal(t, "1.0.0 (abc1234)", temperature.Device.SWVersion)
+
+			var night discoveryConfig
+			assert.NoError(t, json.Unmarshal(configs["homeassistant/binary_sensor/nanit_baby1/is_night/config"], &night))
+			assert.Equal(t, "nanit/babies/baby1/is_night", night.StateTopic)
+			assert.Equal(t, "true", night.PayloadOn)
+			assert.Empty(t, night.UnitOfMeasurement)
+
+			var nightLight discoveryConfig
+			assert.NoError(t, json.Unmarshal(configs["homeassistant/switch/nanit_baby1/night_light/config"], &nightLight))
+			assert.Equal(t, "nanit/babies/baby1/command", nightLight.CommandTopic)
+			assert.Equal(t, "night_light_on", nightLight.PayloadOn)
+
+			configs = getDiscoveryConfigs(opts, "baby1", "Baby", baby.Capabilities{NightLight: utils.ConstRefBool(false)})
+			assert.NotContains(t, configs, "homeassistant/switch/nanit_baby1/night_light/config")
+
+			assert.Equal(t, test.expectedValue, convertTemperature(20, test.unit))
+		})
+	}
+}
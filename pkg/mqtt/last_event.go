@@ -0,0 +1,41 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"time"
+
+	"gitlab.com/adam.stanek/nanit/pkg/notify"
+)
+
+type lastEventPayload struct {
+	Type    notify.EventType `json:"type"`
+	Message string           `json:"message"`
+	Time    time.Time        `json:"time"`
+}
+
+// getLastEventPayload - returns payload of the most recent notable event among the events (false if there is none)
+func (conn *Connection) getLastEventPayload(events []notify.Event) ([]byte, bool) {
+	var lastEvent *notify.Event
+	for i, event := range events {
+		if conn.isNotableEvent(event.Type) && (lastEvent == nil || !event.Time.Before(lastEvent.Time)) {
+			lastEvent = &events[i]
+		}
+	}
+
+	if lastEvent == nil {
+		return nil, false
+	}
+
+	payload, _ := json.Marshal(lastEventPayload{lastEvent.Type, lastEvent.Message, lastEvent.Time})
+	return payload, true
+}
+
+func (conn *Connection) isNotableEvent(eventType notify.EventType) bool {
+	for _, notableType := range conn.Opts.LastEventTypes {
+		if notableType == eventType {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,46 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+	"gitlab.com/adam.stanek/nanit/pkg/notify"
+)
+
+func TestLastEventPayload(t *testing.T) {
+	conn := NewConnection(Opts{LastEventTypes: []notify.EventType{notify.EventStreamDown, notify.EventCamOffline}})
+	now := time.Date(2020, 11, 1, 20, 0, 0, 0, time.UTC)
+
+	_, ok := conn.getLastEventPayload([]notify.Event{{Type: notify.EventTemperatureHigh, Time: now}})
+	assert.False(t, ok)
+
+	payload, ok := conn.getLastEventPayload([]notify.Event{
+		{Type: notify.EventStreamDown, Message: "Stream is down", Time: now},
+		{Type: notify.EventCamOffline, Message: "Cam connection has been lost", Time: now.Add(time.Second)},
+		{Type: notify.EventHumidityLow, Time: now.Add(2 * time.Second)},
+	})
+
+	var lastEvent lastEventPayload
+	if assert.True(t, ok) && assert.NoError(t, json.Unmarshal(payload, &lastEvent)) {
+		assert.Equal(t, notify.EventCamOffline, lastEvent.Type)
+		assert.True(t, now.Add(time.Second).Equal(lastEvent.Time))
+	}
+}
+
+func TestLastEventAlerts(t *testing.T) {
+	conn := NewConnection(Opts{LastEventTypes: []notify.EventType{notify.EventSoundAlert, notify.EventMotionAlert}})
+	now := time.Date(2020, 11, 1, 20, 0, 0, 0, time.UTC)
+
+	events := conn.eventDetector.Detect("baby1", *baby.NewState().SetMotionAlertAt(now).SetSoundAlertAt(now.Add(time.Second)))
+
+	payload, ok := conn.getLastEventPayload(events)
+
+	var lastEvent lastEventPayload
+	if assert.True(t, ok) && assert.NoError(t, json.Unmarshal(payload, &lastEvent)) {
+		assert.Equal(t, notify.EventSoundAlert, lastEvent.Type)
+		assert.Equal(t, "Noise detected", lastEvent.Message)
+	}
+}
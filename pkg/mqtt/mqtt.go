@@ -1,12 +1,16 @@
 package mqtt
 
 import (
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	MQTT "github.com/eclipse/paho.mqtt.golang"
-	"github.com/rs/zerolog/log"
+	"github.com/eclipse/paho.mqtt.golang/packets"
 	"gitlab.com/adam.stanek/nanit/pkg/baby"
+	"gitlab.com/adam.stanek/nanit/pkg/notify"
 	"gitlab.com/adam.stanek/nanit/pkg/utils"
 )
 
@@ -14,21 +18,90 @@ import (
 type Connection struct {
 	Opts         Opts
 	StateManager *baby.StateManager
+
+	// CommandHandler - executes received commands (required if Opts.Commands is enabled)
+	CommandHandler CommandHandler
+
+	// CapabilitiesProvider - returns known capabilities of the baby's camera, controls are not offered to the cams known not to support them (optional)
+	CapabilitiesProvider func(babyUID string) baby.Capabilities
+
+	clientMu sync.RWMutex
+	client   MQTT.Client
+
+	// subscriptions - active subscriptions by topic (re-established after reconnect)
+	subscriptionsMu sync.Mutex
+	subscriptions   map[string]subscription
+
+	// eventDetector - detects the events for the last event topic (kept across reconnects, so that the events are not repeated)
+	eventDetector *notify.Detector
+
+	// eventDebouncer - holds back the recovery events for the last event topic
+	eventDebouncer *notify.Debouncer
+
+	// sparkplugBdSeq - birth/death sequence, incremented with every connection attempt
+	sparkplugBdSeq uint64
 }
 
 // NewConnection - constructor
 func NewConnection(opts Opts) *Connection {
-	return &Connection{
-		Opts: opts,
+	conn := &Connection{
+		Opts:          opts,
+		subscriptions: make(map[string]subscription),
+		eventDetector: notify.NewDetector(opts.EventThresholds),
+	}
+
+	conn.eventDebouncer = notify.NewDebouncer(opts.EventRecoveryDebounce, conn.publishLastEvent)
+	return conn
+}
+
+// PublishRaw - publishes binary payload (ie. image) to the baby's topic, dropped if not connected
+func (conn *Connection) PublishRaw(babyUID string, key string, payload []byte) {
+	conn.publishToBaby(babyUID, key, payload, TopicCategory_Media)
+}
+
+// publishLastEvent - publishes the event to the last event topic (if it is notable)
+func (conn *Connection) publishLastEvent(event notify.Event) {
+	if payload, ok := conn.getLastEventPayload([]notify.Event{event}); ok {
+		conn.publishToBaby(event.BabyUID, "last_event", payload, TopicCategory_State)
+	}
+}
+
+// publishToBaby - publishes payload to the baby's topic using current client, dropped if not connected
+func (conn *Connection) publishToBaby(babyUID string, key string, payload []byte, category TopicCategory) {
+	conn.clientMu.RLock()
+	client := conn.client
+	conn.clientMu.RUnlock()
+
+	if client == nil {
+		log.Debug().Str("key", key).Msg("MQTT not connected, dropping publish")
+		return
+	}
+
+	topic := fmt.Sprintf("%v/babies/%v/%v", conn.Opts.TopicPrefix, babyUID, key)
+	log.Trace().Str("topic", topic).Int("size", len(payload)).Msg("MQTT publish")
+
+	conn.publish(client, category, topic, payload)
+}
+
+// publish - publishes the payload with the delivery settings of the topic category
+func (conn *Connection) publish(client MQTT.Client, category TopicCategory, topic string, payload interface{}) {
+	publishOpts := conn.Opts.getPublishOpts(category)
+
+	token := client.Publish(topic, publishOpts.QoS, publishOpts.Retained, payload)
+	if token.Wait(); token.Error() != nil {
+		log.Error().Err(token.Error()).Str("topic", topic).Msg("Unable to publish MQTT message")
 	}
 }
 
 // Run - runs the mqtt connection handler
-func (conn *Connection) Run(manager *baby.StateManager, ctx utils.GracefulContext) {
+// Returns error wrapping ErrCredentialsRejected if the broker refuses the credentials (retrying would not help),
+// network and other errors are retried until the context is done
+func (conn *Connection) Run(manager *baby.StateManager, babies []baby.Baby, ctx utils.GracefulContext) error {
 	conn.StateManager = manager
 
+	var credentialsErr error
 	utils.RunWithPerseverance(func(attempt utils.AttemptContext) {
-		runMqtt(conn, attempt)
+		credentialsErr = runMqtt(conn, babies, attempt)
 	}, ctx, utils.PerseverenceOpts{
 		RunnerID:       "mqtt",
 		ResetThreshold: 2 * time.Second,
@@ -38,49 +111,207 @@ func (conn *Connection) Run(manager *baby.StateManager, ctx utils.GracefulContex
 			1 * time.Minute,
 		},
 	})
+
+	return credentialsErr
+}
+
+// ErrCredentialsRejected - broker refused the connection because of the credentials
+var ErrCredentialsRejected = errors.New("MQTT broker rejected the credentials")
+
+// isCredentialsError - checks whether the connect error comes from CONNACK refusing the credentials
+// Note: paho returns the errors of packets.ConnErrors as they are for the refused connections
+func isCredentialsError(err error) bool {
+	return err == packets.ConnErrors[packets.ErrRefusedBadUsernameOrPassword] || err == packets.ConnErrors[packets.ErrRefusedNotAuthorised]
 }
 
-func runMqtt(conn *Connection, attempt utils.AttemptContext) {
+// runMqtt - returns error only if the broker rejected the credentials, other failures are reported through the attempt
+func runMqtt(conn *Connection, babies []baby.Baby, attempt utils.AttemptContext) error {
 	opts := MQTT.NewClientOptions()
 	opts.AddBroker(conn.Opts.BrokerURL)
-	opts.SetClientID(conn.Opts.TopicPrefix)
+	opts.SetClientID(conn.Opts.GetClientID())
 	opts.SetUsername(conn.Opts.Username)
 	opts.SetPassword(conn.Opts.Password)
-	opts.SetCleanSession(false)
+	opts.SetCleanSession(conn.Opts.CleanSession)
+
+	// Client reconnects on its own, but the broker does not necessarily keep the subscriptions (ie. after its restart)
+	if conn.Opts.MaxReconnectInterval > 0 {
+		opts.SetMaxReconnectInterval(conn.Opts.MaxReconnectInterval)
+	}
+
+	var numConnects int32
+	opts.SetOnConnectHandler(func(client MQTT.Client) {
+		if atomic.AddInt32(&numConnects, 1) > 1 {
+			log.Info().Str("broker_url", utils.RedactURL(conn.Opts.BrokerURL)).Msg("Reconnected to MQTT broker")
+
+			// Broker published the last will when the connection was lost
+			conn.publish(client, TopicCategory_Availability, conn.Opts.GetAvailabilityTopic(), availabilityOnline)
+			conn.resubscribe(client)
+		}
+	})
+
+	opts.SetConnectionLostHandler(func(client MQTT.Client, err error) {
+		// Note: repeated losses right after connecting usually mean another client uses the same client ID
+		log.Warn().Str("broker_url", utils.RedactURL(conn.Opts.BrokerURL)).Str("client_id", conn.Opts.GetClientID()).Err(err).Msg("Lost connection to MQTT broker, reconnecting (if it keeps happening, check that no other client uses the same client ID)")
+	})
+
+	var sparkplug *sparkplugNode
+	if conn.Opts.Sparkplug != nil {
+		sparkplug = newSparkplugNode(*conn.Opts.Sparkplug, conn.sparkplugBdSeq)
+		conn.sparkplugBdSeq++
+
+		opts.SetBinaryWill(sparkplug.topic(sparkplugMessage_NodeDeath, ""), sparkplug.deathCertificate(), 1, false)
+	} else {
+		// Broker marks the app offline if it disappears without the clean shutdown (crash, power loss, ...)
+		// Note: there can be only one will, Sparkplug needs it for its death certificate
+		availabilityOpts := conn.Opts.getPublishOpts(TopicCategory_Availability)
+		opts.SetWill(conn.Opts.GetAvailabilityTopic(), availabilityOffline, availabilityOpts.QoS, availabilityOpts.Retained)
+	}
 
 	client := MQTT.NewClient(opts)
 	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		log.Error().Str("broker_url", conn.Opts.BrokerURL).Err(token.Error()).Msg("Unable to connect to MQTT broker")
+		if isCredentialsError(token.Error()) {
+			log.Error().Str("broker_url", utils.RedactURL(conn.Opts.BrokerURL)).Str("reason", token.Error().Error()).Msg("MQTT broker rejected the credentials, not retrying (check NANIT_MQTT_USERNAME and NANIT_MQTT_PASSWORD)")
+			return fmt.Errorf("%w: %v", ErrCredentialsRejected, token.Error())
+		}
+
+		log.Error().Str("broker_url", utils.RedactURL(conn.Opts.BrokerURL)).Err(token.Error()).Msg("Unable to connect to MQTT broker")
 		attempt.Fail(token.Error())
-		return
+		return nil
 	}
 
-	log.Info().Str("broker_url", conn.Opts.BrokerURL).Msg("Successfully connected to MQTT broker")
+	log.Info().Str("broker_url", utils.RedactURL(conn.Opts.BrokerURL)).Str("client_id", conn.Opts.GetClientID()).Bool("clean_session", conn.Opts.CleanSession).Msg("Successfully connected to MQTT broker")
 
-	unsubscribe := conn.StateManager.Subscribe(func(babyUID string, state baby.State) {
-		publish := func(key string, value interface{}) {
-			topic := fmt.Sprintf("%v/babies/%v/%v", conn.Opts.TopicPrefix, babyUID, key)
-			log.Trace().Str("topic", topic).Interface("value", value).Msg("MQTT publish")
+	conn.clientMu.Lock()
+	conn.client = client
+	conn.clientMu.Unlock()
 
-			token := client.Publish(topic, 0, false, fmt.Sprintf("%v", value))
-			if token.Wait(); token.Error() != nil {
-				log.Error().Err(token.Error()).Msgf("Unable to publish %v update", key)
+	publishTo := func(babyUID string, key string, value interface{}, category TopicCategory) {
+		topic := fmt.Sprintf("%v/babies/%v/%v", conn.Opts.TopicPrefix, babyUID, key)
+		log.Trace().Str("topic", topic).Interface("value", value).Msg("MQTT publish")
+
+		conn.publish(client, category, topic, fmt.Sprintf("%v", value))
+	}
+
+	// Note: published before the per baby availability, the discovered entities require both
+	conn.publish(client, TopicCategory_Availability, conn.Opts.GetAvailabilityTopic(), availabilityOnline)
+
+	// Home Assistant discovery (retained by default, so it is enough to publish it upon connection)
+	if conn.Opts.Discovery {
+		for babyUID, babyName := range getDiscoveryBabies(babies) {
+			capabilities := baby.Capabilities{}
+			if conn.CapabilitiesProvider != nil {
+				capabilities = conn.CapabilitiesProvider(babyUID)
+			}
+
+			for topic, payload := range getDiscoveryConfigs(conn.Opts, babyUID, babyName, capabilities) {
+				log.Trace().Str("topic", topic).Msg("MQTT publish discovery config")
+				conn.publish(client, TopicCategory_Discovery, topic, payload)
 			}
 		}
+	}
+
+	// Per baby availability (published only on change)
+	var availabilityMu sync.Mutex
+	availabilityByUID := make(map[string]string)
+
+	updateAvailability := func(babyUID string, availability string) {
+		availabilityMu.Lock()
+		defer availabilityMu.Unlock()
+
+		if availabilityByUID[babyUID] != availability {
+			availabilityByUID[babyUID] = availability
+			publishTo(babyUID, "availability", availability, TopicCategory_Availability)
+		}
+	}
+
+	unsubscribe := conn.StateManager.Subscribe(func(babyUID string, state baby.State) {
+		publish := func(key string, value interface{}) {
+			publishTo(babyUID, key, value, TopicCategory_State)
+		}
 
-		for key, value := range state.AsMap(false) {
+		for key, value := range conn.getStateValues(&state) {
 			publish(key, value)
 		}
 
-		if state.StreamState != nil && *state.StreamState != baby.StreamState_Unknown {
-			publish("is_stream_alive", *state.StreamState == baby.StreamState_Alive)
+		if state.IsWebsocketAlive != nil || state.StreamState != nil {
+			updateAvailability(babyUID, getAvailability(conn.StateManager.GetBabyState(babyUID)))
+		}
+
+		if len(conn.Opts.LastEventTypes) > 0 {
+			for _, event := range conn.eventDetector.Detect(babyUID, state) {
+				if conn.Opts.EventQuietHours.IsQuiet(event) {
+					log.Debug().Str("baby_uid", event.BabyUID).Str("event", string(event.Type)).Msg("Last event suppressed by quiet hours")
+					continue
+				}
+
+				conn.eventDebouncer.Push(event)
+			}
 		}
 	})
 
+	stopSparkplug := func() {}
+	if sparkplug != nil {
+		stopSparkplug = runSparkplug(conn, client, sparkplug)
+	}
+
+	unsubscribeCommands := func() {}
+	if conn.Opts.Commands && conn.CommandHandler != nil {
+		unsubscribeCommands = conn.subscribeCommands(client, getDiscoveryBabies(babies))
+	}
+
 	// Wait until interrupt signal is received
 	<-attempt.Done()
 
 	log.Debug().Msg("Closing MQTT connection on interrupt")
+	unsubscribeCommands()
 	unsubscribe()
+	stopSparkplug()
+
+	conn.clientMu.Lock()
+	conn.client = nil
+	conn.clientMu.Unlock()
+
+	availabilityMu.Lock()
+	for babyUID := range availabilityByUID {
+		publishTo(babyUID, "availability", availabilityOffline, TopicCategory_Availability)
+	}
+	availabilityMu.Unlock()
+
+	conn.publish(client, TopicCategory_Availability, conn.Opts.GetAvailabilityTopic(), availabilityOffline)
+
 	client.Disconnect(250)
+	return nil
+}
+
+// getStateValues - returns published values of the state update (ie. temperature in the configured unit)
+func (conn *Connection) getStateValues(state *baby.State) map[string]interface{} {
+	values := state.AsMap(false)
+	if temperature, ok := values["temperature"]; ok {
+		values["temperature"] = convertTemperature(temperature.(float64), conn.Opts.TemperatureUnit)
+	}
+
+	if state.StreamState != nil && *state.StreamState != baby.StreamState_Unknown {
+		values["is_stream_alive"] = *state.StreamState == baby.StreamState_Alive
+	}
+
+	return values
+}
+
+const (
+	availabilityOnline  = "online"
+	availabilityOffline = "offline"
+)
+
+// getAvailability - baby is available when its websocket is connected and the stream is alive
+// Note: stream state is unknown if local streaming is disabled, websocket alone decides then
+func getAvailability(state *baby.State) string {
+	if !state.GetIsWebsocketAlive() {
+		return availabilityOffline
+	}
+
+	if state.GetStreamState() != baby.StreamState_Unknown && state.GetStreamState() != baby.StreamState_Alive {
+		return availabilityOffline
+	}
+
+	return availabilityOnline
 }
@@ -0,0 +1,16 @@
+package mqtt
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsCredentialsError(t *testing.T) {
+	assert.True(t, isCredentialsError(packets.ConnErrors[packets.ErrRefusedBadUsernameOrPassword]))
+	assert.True(t, isCredentialsError(packets.ConnErrors[packets.ErrRefusedNotAuthorised]))
+	assert.False(t, isCredentialsError(packets.ConnErrors[packets.ErrRefusedServerUnavailable]))
+	assert.False(t, isCredentialsError(errors.New("network Error : dial tcp: connection refused")))
+}
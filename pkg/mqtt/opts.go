@@ -1,12 +1,204 @@
 package mqtt
 
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gitlab.com/adam.stanek/nanit/pkg/notify"
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
 // Opts - holds configuration needed to establish connection to the broker
 type Opts struct {
 	BrokerURL string
-	ClientID  string
+
+	// ClientID - has to be unique per broker, broker disconnects the older client when another one connects with the same ID (empty = TopicPrefix)
+	ClientID string
+
+	// CleanSession - broker discards the subscriptions and queued messages on disconnect (false keeps them for the client ID)
+	CleanSession bool
 
 	Username string
 	Password string
 
 	TopicPrefix string
+
+	// TemperatureUnit - unit of the published temperature (C or F, empty = C)
+	TemperatureUnit string
+
+	// Discovery - publish Home Assistant discovery configs under DiscoveryPrefix
+	Discovery       bool
+	DiscoveryPrefix string
+
+	// SoftwareVersion - version of the app reported in the discovery device metadata
+	SoftwareVersion string
+
+	// Publish - delivery settings per topic category (DefaultPublishOpts are used for missing categories)
+	Publish map[TopicCategory]PublishOpts
+
+	// Sparkplug - additionally publish the state as Sparkplug B (nil = disabled)
+	Sparkplug *SparkplugOpts
+
+	// Commands - accept commands on {prefix}/babies/{uid}/command (see Connection.CommandHandler)
+	Commands bool
+
+	// LastEventTypes - events published to {prefix}/babies/{uid}/last_event (empty = disabled)
+	LastEventTypes []notify.EventType
+
+	// EventThresholds - sensor thresholds for the threshold events
+	EventThresholds notify.Thresholds
+
+	// EventRecoveryDebounce - recovery events are published only if the recovery lasts this long (0 = right away)
+	EventRecoveryDebounce time.Duration
+
+	// EventQuietHours - events are not published within these windows (the same as for the notifications)
+	EventQuietHours notify.QuietHours
+
+	// MaxReconnectInterval - upper bound of the backoff between reconnection attempts after the connection is lost (0 = client default)
+	MaxReconnectInterval time.Duration
+}
+
+// SparkplugOpts - identification of the Sparkplug edge node, babies are published as its devices
+type SparkplugOpts struct {
+	GroupID    string
+	EdgeNodeID string
+}
+
+// TopicCategory - group of topics sharing the delivery settings
+type TopicCategory string
+
+const (
+	// TopicCategory_State - sensor values and stream liveness ({prefix}/babies/{uid}/{key})
+	TopicCategory_State TopicCategory = "state"
+	// TopicCategory_Availability - online/offline status of the baby and of the app
+	TopicCategory_Availability TopicCategory = "availability"
+	// TopicCategory_Discovery - Home Assistant discovery configs
+	TopicCategory_Discovery TopicCategory = "discovery"
+	// TopicCategory_Media - binary payloads (ie. preview image)
+	TopicCategory_Media TopicCategory = "media"
+	// TopicCategory_CommandResult - results of the received commands
+	TopicCategory_CommandResult TopicCategory = "command_result"
+)
+
+// TopicCategories - all the topic categories
+var TopicCategories = []TopicCategory{TopicCategory_State, TopicCategory_Availability, TopicCategory_Discovery, TopicCategory_Media, TopicCategory_CommandResult}
+
+// PublishOpts - delivery settings of published messages
+type PublishOpts struct {
+	QoS      byte
+	Retained bool
+}
+
+// DefaultPublishOpts - last known values are retained so that consumers get them right after (re)connecting,
+// large and frequently changing media are not, command results only make sense to whoever sent the command
+var DefaultPublishOpts = map[TopicCategory]PublishOpts{
+	TopicCategory_State:         {QoS: 1, Retained: true},
+	TopicCategory_Availability:  {QoS: 1, Retained: true},
+	TopicCategory_Discovery:     {QoS: 1, Retained: true},
+	TopicCategory_Media:         {QoS: 0, Retained: false},
+	TopicCategory_CommandResult: {QoS: 1, Retained: false},
+}
+
+// getPublishOpts - returns delivery settings for given topic category
+func (opts Opts) getPublishOpts(category TopicCategory) PublishOpts {
+	if publishOpts, ok := opts.Publish[category]; ok {
+		return publishOpts
+	}
+
+	return DefaultPublishOpts[category]
+}
+
+var supportedBrokerSchemes = []string{"tcp", "ssl", "tls", "ws", "wss", "mqtt", "mqtts"}
+
+// GetClientID - returns client ID used for the broker connection
+func (opts Opts) GetClientID() string {
+	if opts.ClientID != "" {
+		return opts.ClientID
+	}
+
+	return opts.TopicPrefix
+}
+
+// GetAvailabilityTopic - returns topic of the app availability (online while connected, offline on shutdown or through the last will)
+func (opts Opts) GetAvailabilityTopic() string {
+	return fmt.Sprintf("%v/availability", opts.TopicPrefix)
+}
+
+// Validate - checks the options, returns descriptive error if they are not valid
+func (opts Opts) Validate() error {
+	brokerURL, err := url.Parse(opts.BrokerURL)
+	if err != nil {
+		// Note: URL is not included, it can contain credentials
+		return fmt.Errorf("invalid broker URL: %v", err.(*url.Error).Err)
+	}
+
+	validScheme := false
+	for _, scheme := range supportedBrokerSchemes {
+		if brokerURL.Scheme == scheme {
+			validScheme = true
+		}
+	}
+
+	if !validScheme || brokerURL.Host == "" {
+		return fmt.Errorf("invalid broker URL %q, expected {scheme}://{host}:{port} where scheme is one of %v", utils.RedactURL(opts.BrokerURL), strings.Join(supportedBrokerSchemes, ", "))
+	}
+
+	if opts.TopicPrefix == "" {
+		return errors.New("topic prefix cannot be empty")
+	} else if strings.ContainsAny(opts.TopicPrefix, "#+") {
+		return fmt.Errorf("topic prefix %q cannot contain wildcards", opts.TopicPrefix)
+	}
+
+	if opts.TemperatureUnit != "" && opts.TemperatureUnit != TemperatureUnit_Celsius && opts.TemperatureUnit != TemperatureUnit_Fahrenheit {
+		return fmt.Errorf("invalid temperature unit %q (allowed values %v, %v)", opts.TemperatureUnit, TemperatureUnit_Celsius, TemperatureUnit_Fahrenheit)
+	}
+
+	for category, publishOpts := range opts.Publish {
+		if _, ok := DefaultPublishOpts[category]; !ok {
+			return fmt.Errorf("unknown topic category %q", category)
+		} else if publishOpts.QoS > 2 {
+			return fmt.Errorf("invalid QoS %v for %v topics (allowed values 0, 1, 2)", publishOpts.QoS, category)
+		}
+	}
+
+	if opts.Sparkplug != nil {
+		if opts.Sparkplug.GroupID == "" || opts.Sparkplug.EdgeNodeID == "" {
+			return errors.New("Sparkplug group ID and edge node ID cannot be empty")
+		} else if strings.ContainsAny(opts.Sparkplug.GroupID+opts.Sparkplug.EdgeNodeID, "/#+") {
+			return fmt.Errorf("Sparkplug group ID %q and edge node ID %q cannot contain / or wildcards", opts.Sparkplug.GroupID, opts.Sparkplug.EdgeNodeID)
+		}
+	}
+
+	for _, eventType := range opts.LastEventTypes {
+		if !containsEventType(notify.EventTypes, eventType) {
+			return fmt.Errorf("unknown last event %q (allowed values %v)", eventType, notify.EventTypes)
+		}
+	}
+
+	if opts.MaxReconnectInterval < 0 {
+		return errors.New("max reconnect interval cannot be negative")
+	}
+
+	if opts.Discovery {
+		if opts.DiscoveryPrefix == "" {
+			return errors.New("discovery prefix cannot be empty")
+		} else if strings.ContainsAny(opts.DiscoveryPrefix, "#+") {
+			return fmt.Errorf("discovery prefix %q cannot contain wildcards", opts.DiscoveryPrefix)
+		}
+	}
+
+	return nil
+}
+
+func containsEventType(eventTypes []notify.EventType, eventType notify.EventType) bool {
+	for _, t := range eventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+
+	return false
 }
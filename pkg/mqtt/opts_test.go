@@ -0,0 +1,34 @@
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishOpts(t *testing.T) {
+	opts := Opts{
+		BrokerURL:   "tcp://localhost:1883",
+		TopicPrefix: "nanit",
+		Publish:     map[TopicCategory]PublishOpts{TopicCategory_State: {QoS: 0, Retained: false}},
+	}
+
+	assert.NoError(t, opts.Validate())
+	assert.Equal(t, PublishOpts{QoS: 0, Retained: false}, opts.getPublishOpts(TopicCategory_State))
+	assert.Equal(t, DefaultPublishOpts[TopicCategory_Availability], opts.getPublishOpts(TopicCategory_Availability))
+
+	for _, category := range TopicCategories {
+		assert.Contains(t, DefaultPublishOpts, category)
+	}
+
+	opts.Publish[TopicCategory_Media] = PublishOpts{QoS: 3}
+	assert.EqualError(t, opts.Validate(), "invalid QoS 3 for media topics (allowed values 0, 1, 2)")
+
+	opts.Publish = map[TopicCategory]PublishOpts{"events": {}}
+	assert.EqualError(t, opts.Validate(), `unknown topic category "events"`)
+}
+
+func TestClientID(t *testing.T) {
+	assert.Equal(t, "nanit", Opts{TopicPrefix: "nanit"}.GetClientID())
+	assert.Equal(t, "nanit-nursery", Opts{TopicPrefix: "nanit", ClientID: "nanit-nursery"}.GetClientID())
+}
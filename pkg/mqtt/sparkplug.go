@@ -0,0 +1,405 @@
+package mqtt
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Sparkplug B (https://www.eclipse.org/tahu/spec/Sparkplug%20Topic%20Namespace%20and%20State%20ManagementV2.2-with%20appendix%20B%20format%20-%20Eclipse.pdf)
+// The app is the edge node, babies are its devices.
+// Payloads are encoded by hand, we only need a tiny subset of the sparkplug_b.proto messages.
+
+const sparkplugNamespace = "spBv1.0"
+
+const (
+	sparkplugMessage_NodeBirth   = "NBIRTH"
+	sparkplugMessage_NodeDeath   = "NDEATH"
+	sparkplugMessage_NodeCommand = "NCMD"
+	sparkplugMessage_DeviceBirth = "DBIRTH"
+	sparkplugMessage_DeviceDeath = "DDEATH"
+	sparkplugMessage_DeviceData  = "DDATA"
+)
+
+const sparkplugMetric_Rebirth = "Node Control/Rebirth"
+const sparkplugMetric_BdSeq = "bdSeq"
+
+// Metric data types (subset of the DataType enum)
+const (
+	sparkplugDataType_Int64   uint32 = 4
+	sparkplugDataType_UInt64  uint32 = 8
+	sparkplugDataType_Double  uint32 = 10
+	sparkplugDataType_Boolean uint32 = 11
+	sparkplugDataType_String  uint32 = 12
+)
+
+// Field numbers of the Payload message
+const (
+	sparkplugPayload_Timestamp protowire.Number = 1
+	sparkplugPayload_Metrics   protowire.Number = 2
+	sparkplugPayload_Seq       protowire.Number = 3
+)
+
+// Field numbers of the Payload.Metric message
+const (
+	sparkplugMetric_Name         protowire.Number = 1
+	sparkplugMetric_Timestamp    protowire.Number = 3
+	sparkplugMetric_DataType     protowire.Number = 4
+	sparkplugMetric_LongValue    protowire.Number = 11
+	sparkplugMetric_DoubleValue  protowire.Number = 13
+	sparkplugMetric_BooleanValue protowire.Number = 14
+	sparkplugMetric_StringValue  protowire.Number = 15
+)
+
+type sparkplugMetric struct {
+	Name     string
+	DataType uint32
+	Value    interface{}
+}
+
+type sparkplugPayload struct {
+	Timestamp time.Time
+	Metrics   []sparkplugMetric
+
+	// Seq - sequence number, nil for the death certificate (which does not carry one)
+	Seq *uint64
+}
+
+// encode - serializes the payload to the protobuf wire format
+func (payload sparkplugPayload) encode() []byte {
+	timestamp := uint64(payload.Timestamp.UnixNano() / int64(time.Millisecond))
+
+	var b []byte
+	b = protowire.AppendTag(b, sparkplugPayload_Timestamp, protowire.VarintType)
+	b = protowire.AppendVarint(b, timestamp)
+
+	for _, metric := range payload.Metrics {
+		var m []byte
+		m = protowire.AppendTag(m, sparkplugMetric_Name, protowire.BytesType)
+		m = protowire.AppendString(m, metric.Name)
+		m = protowire.AppendTag(m, sparkplugMetric_Timestamp, protowire.VarintType)
+		m = protowire.AppendVarint(m, timestamp)
+		m = protowire.AppendTag(m, sparkplugMetric_DataType, protowire.VarintType)
+		m = protowire.AppendVarint(m, uint64(metric.DataType))
+
+		switch value := metric.Value.(type) {
+		case int64:
+			m = protowire.AppendTag(m, sparkplugMetric_LongValue, protowire.VarintType)
+			m = protowire.AppendVarint(m, uint64(value))
+		case uint64:
+			m = protowire.AppendTag(m, sparkplugMetric_LongValue, protowire.VarintType)
+			m = protowire.AppendVarint(m, value)
+		case float64:
+			m = protowire.AppendTag(m, sparkplugMetric_DoubleValue, protowire.Fixed64Type)
+			m = protowire.AppendFixed64(m, math.Float64bits(value))
+		case bool:
+			m = protowire.AppendTag(m, sparkplugMetric_BooleanValue, protowire.VarintType)
+			m = protowire.AppendVarint(m, protowire.EncodeBool(value))
+		case string:
+			m = protowire.AppendTag(m, sparkplugMetric_StringValue, protowire.BytesType)
+			m = protowire.AppendString(m, value)
+		}
+
+		b = protowire.AppendTag(b, sparkplugPayload_Metrics, protowire.BytesType)
+		b = protowire.AppendBytes(b, m)
+	}
+
+	if payload.Seq != nil {
+		b = protowire.AppendTag(b, sparkplugPayload_Seq, protowire.VarintType)
+		b = protowire.AppendVarint(b, *payload.Seq)
+	}
+
+	return b
+}
+
+// decodeSparkplugPayload - parses the payload, only the value types produced by encode are recognized
+func decodeSparkplugPayload(b []byte) (*sparkplugPayload, error) {
+	payload := &sparkplugPayload{}
+
+	err := consumeSparkplugFields(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		switch {
+		case num == sparkplugPayload_Timestamp && typ == protowire.VarintType:
+			ms, _ := protowire.ConsumeVarint(value)
+			payload.Timestamp = time.Unix(0, int64(ms)*int64(time.Millisecond))
+		case num == sparkplugPayload_Seq && typ == protowire.VarintType:
+			seq, _ := protowire.ConsumeVarint(value)
+			payload.Seq = &seq
+		case num == sparkplugPayload_Metrics && typ == protowire.BytesType:
+			metric, err := decodeSparkplugMetric(value)
+			if err != nil {
+				return err
+			}
+
+			payload.Metrics = append(payload.Metrics, *metric)
+		}
+
+		return nil
+	})
+
+	return payload, err
+}
+
+func decodeSparkplugMetric(b []byte) (*sparkplugMetric, error) {
+	metric := &sparkplugMetric{}
+
+	err := consumeSparkplugFields(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		switch {
+		case num == sparkplugMetric_Name && typ == protowire.BytesType:
+			metric.Name = string(value)
+		case num == sparkplugMetric_DataType && typ == protowire.VarintType:
+			dataType, _ := protowire.ConsumeVarint(value)
+			metric.DataType = uint32(dataType)
+		case num == sparkplugMetric_LongValue && typ == protowire.VarintType:
+			v, _ := protowire.ConsumeVarint(value)
+			if metric.DataType == sparkplugDataType_UInt64 {
+				metric.Value = v
+			} else {
+				metric.Value = int64(v)
+			}
+		case num == sparkplugMetric_DoubleValue && typ == protowire.Fixed64Type:
+			v, _ := protowire.ConsumeFixed64(value)
+			metric.Value = math.Float64frombits(v)
+		case num == sparkplugMetric_BooleanValue && typ == protowire.VarintType:
+			v, _ := protowire.ConsumeVarint(value)
+			metric.Value = protowire.DecodeBool(v)
+		case num == sparkplugMetric_StringValue && typ == protowire.BytesType:
+			metric.Value = string(value)
+		}
+
+		return nil
+	})
+
+	return metric, err
+}
+
+// consumeSparkplugFields - calls handler for every field of the message with its raw value
+// (varint/fixed values still encoded, length-delimited values without the length prefix)
+func consumeSparkplugFields(b []byte, handler func(num protowire.Number, typ protowire.Type, value []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var value []byte
+		if typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+
+			value, b = v, b[n:]
+		} else {
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+
+			value, b = b[:n], b[n:]
+		}
+
+		if err := handler(num, typ, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getSparkplugMetrics - converts state values to metrics, values of unsupported types are skipped
+func getSparkplugMetrics(values map[string]interface{}) []sparkplugMetric {
+	metrics := make([]sparkplugMetric, 0, len(values))
+	for name, value := range values {
+		metric := sparkplugMetric{Name: name, Value: value}
+
+		switch value.(type) {
+		case int64:
+			metric.DataType = sparkplugDataType_Int64
+		case float64:
+			metric.DataType = sparkplugDataType_Double
+		case bool:
+			metric.DataType = sparkplugDataType_Boolean
+		case string:
+			metric.DataType = sparkplugDataType_String
+		default:
+			continue
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Name < metrics[j].Name })
+	return metrics
+}
+
+// sparkplugNode - Sparkplug session of the edge node, lives for a single MQTT connection
+type sparkplugNode struct {
+	opts  SparkplugOpts
+	bdSeq uint64
+
+	// mu - guards the sequence number so that it matches the order of the published messages
+	mu          sync.Mutex
+	seq         uint64
+	bornDevices map[string]bool
+}
+
+func newSparkplugNode(opts SparkplugOpts, bdSeq uint64) *sparkplugNode {
+	return &sparkplugNode{
+		opts:        opts,
+		bdSeq:       bdSeq % 256,
+		bornDevices: make(map[string]bool),
+	}
+}
+
+// topic - returns topic of the message, device ID is empty for the node messages
+func (node *sparkplugNode) topic(messageType string, deviceID string) string {
+	topic := fmt.Sprintf("%v/%v/%v/%v", sparkplugNamespace, node.opts.GroupID, messageType, node.opts.EdgeNodeID)
+	if deviceID != "" {
+		topic += "/" + deviceID
+	}
+
+	return topic
+}
+
+// deathCertificate - payload of NDEATH, registered as the will upon connection
+func (node *sparkplugNode) deathCertificate() []byte {
+	return sparkplugPayload{
+		Timestamp: time.Now(),
+		Metrics:   []sparkplugMetric{{Name: sparkplugMetric_BdSeq, DataType: sparkplugDataType_UInt64, Value: node.bdSeq}},
+	}.encode()
+}
+
+// nextPayload - builds payload with the next sequence number, must be called with mu held
+func (node *sparkplugNode) nextPayload(metrics []sparkplugMetric) []byte {
+	seq := node.seq
+	node.seq = (node.seq + 1) % 256
+
+	return sparkplugPayload{Timestamp: time.Now(), Metrics: metrics, Seq: &seq}.encode()
+}
+
+// publishBirth - publishes NBIRTH (resets the sequence) followed by DBIRTH of every born device
+func (node *sparkplugNode) publishBirth(conn *Connection, client MQTT.Client) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	node.seq = 0
+	conn.publishSparkplug(client, node.topic(sparkplugMessage_NodeBirth, ""), node.nextPayload([]sparkplugMetric{
+		{Name: sparkplugMetric_BdSeq, DataType: sparkplugDataType_UInt64, Value: node.bdSeq},
+		{Name: sparkplugMetric_Rebirth, DataType: sparkplugDataType_Boolean, Value: false},
+	}))
+
+	for babyUID := range node.bornDevices {
+		node.publishDeviceBirth(conn, client, babyUID)
+	}
+}
+
+// publishDeviceBirth - publishes full state of the baby, must be called with mu held
+func (node *sparkplugNode) publishDeviceBirth(conn *Connection, client MQTT.Client, babyUID string) {
+	node.bornDevices[babyUID] = true
+	values := conn.getStateValues(conn.StateManager.GetBabyState(babyUID))
+	conn.publishSparkplug(client, node.topic(sparkplugMessage_DeviceBirth, babyUID), node.nextPayload(getSparkplugMetrics(values)))
+}
+
+// publishState - publishes the state update of the baby
+// Device is born with its first update and dies when it goes offline (see getAvailability)
+func (node *sparkplugNode) publishState(conn *Connection, client MQTT.Client, babyUID string, state baby.State) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	online := getAvailability(conn.StateManager.GetBabyState(babyUID)) == availabilityOnline
+	born := node.bornDevices[babyUID]
+
+	if !online {
+		if born {
+			node.publishDeviceDeath(conn, client, babyUID)
+		}
+	} else if !born {
+		node.publishDeviceBirth(conn, client, babyUID)
+	} else if metrics := getSparkplugMetrics(conn.getStateValues(&state)); len(metrics) > 0 {
+		conn.publishSparkplug(client, node.topic(sparkplugMessage_DeviceData, babyUID), node.nextPayload(metrics))
+	}
+}
+
+// publishDeviceDeath - must be called with mu held
+func (node *sparkplugNode) publishDeviceDeath(conn *Connection, client MQTT.Client, babyUID string) {
+	delete(node.bornDevices, babyUID)
+	conn.publishSparkplug(client, node.topic(sparkplugMessage_DeviceDeath, babyUID), node.nextPayload(nil))
+}
+
+// publishDeath - publishes DDEATH of every born device followed by NDEATH (will is not delivered on clean disconnect)
+func (node *sparkplugNode) publishDeath(conn *Connection, client MQTT.Client) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	for babyUID := range node.bornDevices {
+		node.publishDeviceDeath(conn, client, babyUID)
+	}
+
+	conn.publishSparkplug(client, node.topic(sparkplugMessage_NodeDeath, ""), node.deathCertificate())
+}
+
+// handleCommand - handles NCMD, host applications use it to request rebirth (ie. after they restart)
+func (node *sparkplugNode) handleCommand(conn *Connection, client MQTT.Client, payload []byte) error {
+	command, err := decodeSparkplugPayload(payload)
+	if err != nil {
+		return err
+	}
+
+	for _, metric := range command.Metrics {
+		if metric.Name == sparkplugMetric_Rebirth {
+			if rebirth, ok := metric.Value.(bool); !ok {
+				return errors.New("rebirth metric is not boolean")
+			} else if rebirth {
+				log.Info().Msg("Sparkplug rebirth requested")
+				// Not blocking the message handler of the client
+				go node.publishBirth(conn, client)
+			}
+		}
+	}
+
+	return nil
+}
+
+// runSparkplug - publishes birth certificates and state updates, returns function which publishes death certificates and stops
+func runSparkplug(conn *Connection, client MQTT.Client, node *sparkplugNode) func() {
+	commandTopic := node.topic(sparkplugMessage_NodeCommand, "")
+	err := conn.subscribe(client, commandTopic, 0, func(client MQTT.Client, msg MQTT.Message) {
+		if err := node.handleCommand(conn, client, msg.Payload()); err != nil {
+			log.Warn().Err(err).Str("topic", msg.Topic()).Msg("Unable to handle Sparkplug command")
+		}
+	})
+
+	if err != nil {
+		log.Error().Err(err).Str("topic", commandTopic).Msg("Unable to subscribe to Sparkplug commands")
+	}
+
+	node.publishBirth(conn, client)
+
+	unsubscribe := conn.StateManager.Subscribe(func(babyUID string, state baby.State) {
+		node.publishState(conn, client, babyUID, state)
+	})
+
+	return func() {
+		unsubscribe()
+		conn.unsubscribe(client, commandTopic)
+		node.publishDeath(conn, client)
+	}
+}
+
+// publishSparkplug - Sparkplug messages are never retained and are sent with QoS 0 (as required by the spec)
+func (conn *Connection) publishSparkplug(client MQTT.Client, topic string, payload []byte) {
+	log.Trace().Str("topic", topic).Int("size", len(payload)).Msg("MQTT publish Sparkplug")
+
+	token := client.Publish(topic, 0, false, payload)
+	if token.Wait(); token.Error() != nil {
+		log.Error().Err(token.Error()).Str("topic", topic).Msg("Unable to publish MQTT message")
+	}
+}
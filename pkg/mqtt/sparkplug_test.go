@@ -0,0 +1,46 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSparkplugPayload(t *testing.T) {
+	seq := uint64(7)
+	payload := sparkplugPayload{
+		Timestamp: time.Unix(1600000000, 123000000),
+		Seq:       &seq,
+		Metrics: getSparkplugMetrics(map[string]interface{}{
+			"temperature":     21.5,
+			"humidity":        int64(45),
+			"is_night":        true,
+			"is_stream_alive": false,
+			"unsupported":     struct{}{},
+		}),
+	}
+
+	decoded, err := decodeSparkplugPayload(payload.encode())
+	assert.NoError(t, err)
+	assert.True(t, payload.Timestamp.Equal(decoded.Timestamp))
+	assert.Equal(t, &seq, decoded.Seq)
+	assert.Equal(t, []sparkplugMetric{
+		{Name: "humidity", DataType: sparkplugDataType_Int64, Value: int64(45)},
+		{Name: "is_night", DataType: sparkplugDataType_Boolean, Value: true},
+		{Name: "is_stream_alive", DataType: sparkplugDataType_Boolean, Value: false},
+		{Name: "temperature", DataType: sparkplugDataType_Double, Value: 21.5},
+	}, decoded.Metrics)
+
+	node := newSparkplugNode(SparkplugOpts{GroupID: "home", EdgeNodeID: "nanit"}, 3)
+	death, err := decodeSparkplugPayload(node.deathCertificate())
+	assert.NoError(t, err)
+	assert.Nil(t, death.Seq)
+	assert.Equal(t, []sparkplugMetric{{Name: "bdSeq", DataType: sparkplugDataType_UInt64, Value: uint64(3)}}, death.Metrics)
+
+	assert.Equal(t, "spBv1.0/home/NDEATH/nanit", node.topic(sparkplugMessage_NodeDeath, ""))
+	assert.Equal(t, "spBv1.0/home/DDATA/nanit/baby1", node.topic(sparkplugMessage_DeviceData, "baby1"))
+
+	_, err = decodeSparkplugPayload([]byte{0x12, 0xff})
+	assert.Error(t, err)
+}
@@ -0,0 +1,50 @@
+package mqtt
+
+import (
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+type subscription struct {
+	qos     byte
+	handler MQTT.MessageHandler
+}
+
+// subscribe - subscribes to the topic and remembers it, so that it can be re-established after reconnect
+// Note: subscription is remembered even if it fails, next reconnect will try it again
+func (conn *Connection) subscribe(client MQTT.Client, topic string, qos byte, handler MQTT.MessageHandler) error {
+	conn.subscriptionsMu.Lock()
+	conn.subscriptions[topic] = subscription{qos, handler}
+	conn.subscriptionsMu.Unlock()
+
+	token := client.Subscribe(topic, qos, handler)
+	token.Wait()
+	return token.Error()
+}
+
+// unsubscribe - unsubscribes from the topic and forgets it
+func (conn *Connection) unsubscribe(client MQTT.Client, topic string) {
+	conn.subscriptionsMu.Lock()
+	delete(conn.subscriptions, topic)
+	conn.subscriptionsMu.Unlock()
+
+	client.Unsubscribe(topic).Wait()
+}
+
+// resubscribe - re-establishes all the subscriptions (broker might not keep the session, ie. after its restart)
+func (conn *Connection) resubscribe(client MQTT.Client) {
+	conn.subscriptionsMu.Lock()
+	subscriptions := make(map[string]subscription, len(conn.subscriptions))
+	for topic, sub := range conn.subscriptions {
+		subscriptions[topic] = sub
+	}
+	conn.subscriptionsMu.Unlock()
+
+	for topic, sub := range subscriptions {
+		token := client.Subscribe(topic, sub.qos, sub.handler)
+		if token.Wait(); token.Error() != nil {
+			log.Error().Err(token.Error()).Str("topic", topic).Msg("Unable to re-subscribe to MQTT topic")
+		} else {
+			log.Debug().Str("topic", topic).Msg("Re-subscribed to MQTT topic")
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package mqtt
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+)
+
+type doneToken struct{}
+
+func (doneToken) Wait() bool                     { return true }
+func (doneToken) WaitTimeout(time.Duration) bool { return true }
+func (doneToken) Error() error                   { return nil }
+func (doneToken) Done() <-chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}
+
+// fakeClient - records subscriptions as the broker would (other methods are not implemented)
+type fakeClient struct {
+	MQTT.Client
+
+	mu            sync.Mutex
+	subscriptions map[string]byte
+}
+
+func (client *fakeClient) Subscribe(topic string, qos byte, callback MQTT.MessageHandler) MQTT.Token {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.subscriptions[topic] = qos
+	return doneToken{}
+}
+
+func (client *fakeClient) Unsubscribe(topics ...string) MQTT.Token {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	for _, topic := range topics {
+		delete(client.subscriptions, topic)
+	}
+	return doneToken{}
+}
+
+func TestResubscribeAfterBrokerRestart(t *testing.T) {
+	conn := NewConnection(Opts{TopicPrefix: "nanit"})
+	client := &fakeClient{subscriptions: make(map[string]byte)}
+	handler := func(MQTT.Client, MQTT.Message) {}
+
+	assert.NoError(t, conn.subscribe(client, "nanit/babies/+/command", 1, handler))
+	assert.NoError(t, conn.subscribe(client, "spBv1.0/nanit/NCMD/nanit", 0, handler))
+	conn.unsubscribe(client, "spBv1.0/nanit/NCMD/nanit")
+
+	// Broker restarted without persisted sessions
+	client.subscriptions = make(map[string]byte)
+	conn.resubscribe(client)
+
+	assert.Equal(t, map[string]byte{"nanit/babies/+/command": 1}, client.subscriptions)
+}
@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"fmt"
+	"time"
+)
+
+// aggregatedEvents - events rate limited within the current interval, delivered as a single summary at its end
+type aggregatedEvents struct {
+	count int
+	last  Event
+	timer *time.Timer
+}
+
+// aggregate - adds rate limited event to the summary of its interval
+func (notifier *Notifier) aggregate(event Event) {
+	key := getRateLimitKey(event)
+
+	notifier.lastSentMu.Lock()
+	defer notifier.lastSentMu.Unlock()
+
+	if entry, ok := notifier.aggregated[key]; ok {
+		entry.count++
+		entry.last = event
+		return
+	}
+
+	entry := &aggregatedEvents{count: 1, last: event}
+	minInterval := notifier.Opts.getMinInterval(event.Type)
+	flushIn := notifier.lastSent[key].Add(minInterval).Sub(event.Time)
+	entry.timer = time.AfterFunc(flushIn, func() {
+		notifier.lastSentMu.Lock()
+		if notifier.aggregated[key] != entry {
+			notifier.lastSentMu.Unlock()
+			return
+		}
+
+		delete(notifier.aggregated, key)
+		summary := getAggregatedSummary(entry.last, entry.count, minInterval)
+		notifier.lastSent[key] = summary.Time
+		notifier.lastSentMu.Unlock()
+
+		notifier.send(summary)
+	})
+
+	notifier.aggregated[key] = entry
+}
+
+// stopAggregation - drops the pending summaries
+func (notifier *Notifier) stopAggregation() {
+	notifier.lastSentMu.Lock()
+	defer notifier.lastSentMu.Unlock()
+
+	for key, entry := range notifier.aggregated {
+		entry.timer.Stop()
+		delete(notifier.aggregated, key)
+	}
+}
+
+// getAggregatedSummary - returns the last of the aggregated events with a note on how many times it occurred
+func getAggregatedSummary(last Event, count int, interval time.Duration) Event {
+	summary := last
+	summary.Time = time.Now()
+
+	if count == 1 {
+		summary.Message = fmt.Sprintf("%v (once more within %v)", last.Message, interval)
+	} else {
+		summary.Message = fmt.Sprintf("%v (%v more times within %v)", last.Message, count, interval)
+	}
+
+	return summary
+}
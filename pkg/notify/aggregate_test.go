@@ -0,0 +1,45 @@
+package notify
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingSink struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (sink *recordingSink) Name() string { return "recording" }
+
+func (sink *recordingSink) Send(event Event) error {
+	sink.mu.Lock()
+	sink.messages = append(sink.messages, event.Message)
+	sink.mu.Unlock()
+	return nil
+}
+
+func (sink *recordingSink) getMessages() []string {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	return append([]string(nil), sink.messages...)
+}
+
+func TestNotifierAggregate(t *testing.T) {
+	sink := &recordingSink{}
+	notifier := NewNotifier(Opts{Events: []EventType{EventTemperatureHigh}, MinInterval: 50 * time.Millisecond, Aggregate: true})
+	notifier.Sinks = []Sink{sink}
+
+	for _, message := range []string{"Temperature is 26.0 °C", "Temperature is 26.5 °C", "Temperature is 27.0 °C"} {
+		notifier.Dispatch(Event{BabyUID: "baby1", Type: EventTemperatureHigh, Message: message, Time: time.Now()})
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, []string{"Temperature is 26.0 °C"}, sink.getMessages())
+
+	time.Sleep(80 * time.Millisecond)
+	assert.Equal(t, []string{"Temperature is 26.0 °C", "Temperature is 27.0 °C (2 more times within 50ms)"}, sink.getMessages())
+}
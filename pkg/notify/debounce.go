@@ -0,0 +1,93 @@
+package notify
+
+import (
+	"sync"
+	"time"
+)
+
+// Debouncer - holds the recovery events (stream_up, cam_online) back until the recovered condition lasts for the delay.
+// If the failure happens again within the delay, both the recovery and the repeated failure are dropped,
+// so that brief flaps do not produce a pair of notifications.
+type Debouncer struct {
+	delay   time.Duration
+	deliver func(Event)
+
+	mu      sync.Mutex
+	pending map[string]*time.Timer
+}
+
+// NewDebouncer - constructor, events which pass are handed over to deliver (0 delay = no debouncing)
+func NewDebouncer(delay time.Duration, deliver func(Event)) *Debouncer {
+	return &Debouncer{
+		delay:   delay,
+		deliver: deliver,
+		pending: make(map[string]*time.Timer),
+	}
+}
+
+// Push - delivers the event right away or after the delay in case of the recovery event
+func (debouncer *Debouncer) Push(event Event) {
+	if debouncer.delay <= 0 {
+		debouncer.deliver(event)
+		return
+	}
+
+	if failureType, ok := getRecoveredEventType(event.Type); ok {
+		key := event.BabyUID + "/" + string(failureType)
+
+		debouncer.mu.Lock()
+		defer debouncer.mu.Unlock()
+
+		var timer *time.Timer
+		timer = time.AfterFunc(debouncer.delay, func() {
+			debouncer.mu.Lock()
+			if debouncer.pending[key] == timer {
+				delete(debouncer.pending, key)
+			}
+			debouncer.mu.Unlock()
+
+			debouncer.deliver(event)
+		})
+
+		debouncer.pending[key] = timer
+		return
+	}
+
+	if _, ok := recoveryEvents[event.Type]; ok {
+		key := event.BabyUID + "/" + string(event.Type)
+
+		debouncer.mu.Lock()
+		timer, isPending := debouncer.pending[key]
+		delete(debouncer.pending, key)
+		debouncer.mu.Unlock()
+
+		if isPending && timer.Stop() {
+			log.Debug().Str("baby_uid", event.BabyUID).Str("event", string(event.Type)).Msg("Recovery did not last, dropping both events")
+			return
+		}
+	}
+
+	debouncer.deliver(event)
+}
+
+// Stop - drops all the pending events
+func (debouncer *Debouncer) Stop() {
+	debouncer.mu.Lock()
+	defer debouncer.mu.Unlock()
+
+	for key, timer := range debouncer.pending {
+		timer.Stop()
+		delete(debouncer.pending, key)
+	}
+}
+
+// getRecoveredEventType - returns the failure event which is recovered by given event (false if it is not a recovery event)
+func getRecoveredEventType(eventType EventType) (EventType, bool) {
+	for failureType, recovery := range recoveryEvents {
+		if recovery.eventType == eventType {
+			return failureType, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,46 @@
+package notify
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebouncer(t *testing.T) {
+	var mu sync.Mutex
+	var delivered []EventType
+
+	debouncer := NewDebouncer(20*time.Millisecond, func(event Event) {
+		mu.Lock()
+		delivered = append(delivered, event.Type)
+		mu.Unlock()
+	})
+
+	getDelivered := func() []EventType {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]EventType(nil), delivered...)
+	}
+
+	// Failure is delivered right away
+	debouncer.Push(Event{BabyUID: "baby1", Type: EventStreamDown})
+	assert.Equal(t, []EventType{EventStreamDown}, getDelivered())
+
+	// Brief flap is dropped entirely
+	debouncer.Push(Event{BabyUID: "baby1", Type: EventStreamUp})
+	debouncer.Push(Event{BabyUID: "baby1", Type: EventStreamDown})
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, []EventType{EventStreamDown}, getDelivered())
+
+	// Lasting recovery is delivered after the delay
+	debouncer.Push(Event{BabyUID: "baby1", Type: EventStreamUp})
+	assert.Equal(t, []EventType{EventStreamDown}, getDelivered())
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, []EventType{EventStreamDown, EventStreamUp}, getDelivered())
+
+	// Other events are not affected
+	debouncer.Push(Event{BabyUID: "baby1", Type: EventTemperatureHigh})
+	assert.Equal(t, []EventType{EventStreamDown, EventStreamUp, EventTemperatureHigh}, getDelivered())
+}
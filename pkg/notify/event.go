@@ -0,0 +1,193 @@
+package notify
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+)
+
+// EventType - type of notable event
+type EventType string
+
+const (
+	// EventStreamDown - local stream stopped working
+	EventStreamDown EventType = "stream_down"
+
+	// EventStreamUp - local stream works again after it was down
+	EventStreamUp EventType = "stream_up"
+
+	// EventCamOffline - websocket connection to the cam has been lost
+	EventCamOffline EventType = "cam_offline"
+
+	// EventCamOnline - websocket connection to the cam has been re-established after it was lost
+	EventCamOnline EventType = "cam_online"
+
+	// EventTemperatureHigh - temperature rose above the threshold
+	EventTemperatureHigh EventType = "temperature_high"
+
+	// EventTemperatureLow - temperature dropped below the threshold
+	EventTemperatureLow EventType = "temperature_low"
+
+	// EventHumidityHigh - humidity rose above the threshold
+	EventHumidityHigh EventType = "humidity_high"
+
+	// EventHumidityLow - humidity dropped below the threshold
+	EventHumidityLow EventType = "humidity_low"
+
+	// EventSoundAlert - cam detected noise (ie. crying)
+	EventSoundAlert EventType = "sound_alert"
+
+	// EventMotionAlert - cam detected motion
+	EventMotionAlert EventType = "motion_alert"
+)
+
+// EventTypes - all known event types
+var EventTypes = []EventType{
+	EventStreamDown,
+	EventStreamUp,
+	EventCamOffline,
+	EventCamOnline,
+	EventTemperatureHigh,
+	EventTemperatureLow,
+	EventHumidityHigh,
+	EventHumidityLow,
+	EventSoundAlert,
+	EventMotionAlert,
+}
+
+// Event - notable event which should be delivered to the user
+type Event struct {
+	BabyUID  string
+	BabyName string
+	Type     EventType
+	Message  string
+	Time     time.Time
+
+	// Snapshot - JPEG image of the stream (nil if not captured)
+	Snapshot []byte
+}
+
+// Title - short human readable summary
+func (event Event) Title() string {
+	return fmt.Sprintf("Nanit (%v)", event.BabyName)
+}
+
+// recoveryEvents - events fired when the condition of the event is over
+var recoveryEvents = map[EventType]struct {
+	eventType EventType
+	message   string
+}{
+	EventStreamDown: {EventStreamUp, "Stream is up again"},
+	EventCamOffline: {EventCamOnline, "Cam connection has been re-established"},
+}
+
+// Detector - turns state updates into events, fires threshold events only when the threshold is crossed
+type Detector struct {
+	thresholds Thresholds
+
+	mu     sync.Mutex
+	active map[string]map[EventType]bool
+
+	// alertTimes - time of the last reported alert, so that the alert replayed to a new subscriber is not reported again
+	alertTimes map[string]map[EventType]time.Time
+}
+
+// NewDetector - constructor
+func NewDetector(thresholds Thresholds) *Detector {
+	return &Detector{
+		thresholds: thresholds,
+		active:     make(map[string]map[EventType]bool),
+		alertTimes: make(map[string]map[EventType]time.Time),
+	}
+}
+
+// Detect - returns events caused by the state update of the baby
+func (detector *Detector) Detect(babyUID string, stateUpdate baby.State) []Event {
+	detector.mu.Lock()
+	defer detector.mu.Unlock()
+
+	if _, ok := detector.active[babyUID]; !ok {
+		detector.active[babyUID] = make(map[EventType]bool)
+	}
+
+	if _, ok := detector.alertTimes[babyUID]; !ok {
+		detector.alertTimes[babyUID] = make(map[EventType]time.Time)
+	}
+
+	active := detector.active[babyUID]
+	alertTimes := detector.alertTimes[babyUID]
+	var events []Event
+
+	// Fires event when condition becomes true (and its recovery event when it becomes false again)
+	check := func(eventType EventType, condition bool, format string, args ...interface{}) {
+		if condition && !active[eventType] {
+			events = append(events, Event{
+				BabyUID: babyUID,
+				Type:    eventType,
+				Message: fmt.Sprintf(format, args...),
+				Time:    time.Now(),
+			})
+		} else if recovery, ok := recoveryEvents[eventType]; ok && !condition && active[eventType] {
+			events = append(events, Event{
+				BabyUID: babyUID,
+				Type:    recovery.eventType,
+				Message: recovery.message,
+				Time:    time.Now(),
+			})
+		}
+
+		active[eventType] = condition
+	}
+
+	if stateUpdate.StreamState != nil {
+		check(EventStreamDown, *stateUpdate.StreamState == baby.StreamState_Unhealthy, "Stream is down")
+	}
+
+	if stateUpdate.IsWebsocketAlive != nil {
+		check(EventCamOffline, !*stateUpdate.IsWebsocketAlive, "Cam connection has been lost")
+	}
+
+	if stateUpdate.TemperatureMilli != nil {
+		temperature := stateUpdate.GetTemperature()
+		if detector.thresholds.TemperatureMax != nil {
+			check(EventTemperatureHigh, temperature > *detector.thresholds.TemperatureMax, "Temperature is %.1f °C", temperature)
+		}
+
+		if detector.thresholds.TemperatureMin != nil {
+			check(EventTemperatureLow, temperature < *detector.thresholds.TemperatureMin, "Temperature is %.1f °C", temperature)
+		}
+	}
+
+	if stateUpdate.HumidityMilli != nil {
+		humidity := stateUpdate.GetHumidity()
+		if detector.thresholds.HumidityMax != nil {
+			check(EventHumidityHigh, humidity > *detector.thresholds.HumidityMax, "Humidity is %.0f %%", humidity)
+		}
+
+		if detector.thresholds.HumidityMin != nil {
+			check(EventHumidityLow, humidity < *detector.thresholds.HumidityMin, "Humidity is %.0f %%", humidity)
+		}
+	}
+
+	// Alerts are momentary, every new alert pushed by the cam is an event (sinks take care of the rate limiting)
+	alert := func(eventType EventType, alertAt *time.Time, message string) {
+		if alertAt == nil || !alertAt.After(alertTimes[eventType]) {
+			return
+		}
+
+		alertTimes[eventType] = *alertAt
+		events = append(events, Event{
+			BabyUID: babyUID,
+			Type:    eventType,
+			Message: message,
+			Time:    *alertAt,
+		})
+	}
+
+	alert(EventSoundAlert, stateUpdate.SoundAlertAt, "Noise detected")
+	alert(EventMotionAlert, stateUpdate.MotionAlertAt, "Motion detected")
+
+	return events
+}
@@ -0,0 +1,62 @@
+package notify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+)
+
+func TestEventDetectorThresholdCrossing(t *testing.T) {
+	max := 25.0
+	detector := NewDetector(Thresholds{TemperatureMax: &max})
+
+	assert.Empty(t, detector.Detect("baby1", *baby.NewState().SetTemperatureMilli(24_000)))
+
+	events := detector.Detect("baby1", *baby.NewState().SetTemperatureMilli(26_000))
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, EventTemperatureHigh, events[0].Type)
+	}
+
+	// Still above, no new event
+	assert.Empty(t, detector.Detect("baby1", *baby.NewState().SetTemperatureMilli(27_000)))
+
+	// Other baby is tracked separately
+	assert.Len(t, detector.Detect("baby2", *baby.NewState().SetTemperatureMilli(26_000)), 1)
+
+	// Back below and above again
+	assert.Empty(t, detector.Detect("baby1", *baby.NewState().SetTemperatureMilli(24_000)))
+	assert.Len(t, detector.Detect("baby1", *baby.NewState().SetTemperatureMilli(26_000)), 1)
+}
+
+func TestEventDetectorStreamDown(t *testing.T) {
+	detector := NewDetector(Thresholds{})
+
+	assert.Empty(t, detector.Detect("baby1", *baby.NewState().SetStreamState(baby.StreamState_Alive)))
+
+	events := detector.Detect("baby1", *baby.NewState().SetStreamState(baby.StreamState_Unhealthy))
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, EventStreamDown, events[0].Type)
+	}
+
+	events = detector.Detect("baby1", *baby.NewState().SetStreamState(baby.StreamState_Alive))
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, EventStreamUp, events[0].Type)
+	}
+}
+
+func TestEventDetectorSoundAlert(t *testing.T) {
+	detector := NewDetector(Thresholds{})
+	alertAt := time.Now()
+
+	events := detector.Detect("baby1", *baby.NewState().SetSoundAlertAt(alertAt))
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, EventSoundAlert, events[0].Type)
+		assert.Equal(t, alertAt, events[0].Time)
+	}
+
+	// Replayed alert is not reported again, every new one is
+	assert.Empty(t, detector.Detect("baby1", *baby.NewState().SetSoundAlertAt(alertAt)))
+	assert.Len(t, detector.Detect("baby1", *baby.NewState().SetSoundAlertAt(alertAt.Add(time.Minute))), 1)
+}
@@ -0,0 +1,212 @@
+package notify
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+// Notifier - detects notable events from baby state changes and delivers them to the sinks
+type Notifier struct {
+	Opts  Opts
+	Sinks []Sink
+
+	// SnapshotProvider - optional, grabs current image of the baby's stream for the snapshot events
+	SnapshotProvider func(babyUID string) ([]byte, error)
+
+	detector *Detector
+
+	lastSentMu sync.Mutex
+	lastSent   map[rateLimitKey]time.Time
+	aggregated map[rateLimitKey]*aggregatedEvents
+}
+
+// NewNotifier - constructor
+func NewNotifier(opts Opts) *Notifier {
+	notifier := &Notifier{
+		Opts:       opts,
+		detector:   NewDetector(opts.Thresholds),
+		lastSent:   make(map[rateLimitKey]time.Time),
+		aggregated: make(map[rateLimitKey]*aggregatedEvents),
+	}
+
+	if opts.Ntfy != nil {
+		notifier.Sinks = append(notifier.Sinks, NewNtfySink(*opts.Ntfy))
+	}
+
+	if opts.Pushover != nil {
+		notifier.Sinks = append(notifier.Sinks, NewPushoverSink(*opts.Pushover))
+	}
+
+	if opts.Telegram != nil {
+		notifier.Sinks = append(notifier.Sinks, NewTelegramSink(*opts.Telegram))
+	}
+
+	if opts.Discord != nil {
+		notifier.Sinks = append(notifier.Sinks, NewDiscordSink(*opts.Discord))
+	}
+
+	return notifier
+}
+
+// Run - watches baby state changes until the context is cancelled
+func (notifier *Notifier) Run(manager *baby.StateManager, babies []baby.Baby, ctx utils.GracefulContext) {
+	babyNames := make(map[string]string)
+	for _, babyInfo := range babies {
+		for _, cameraUID := range babyInfo.GetCameraUIDs() {
+			babyNames[babyInfo.GetStateKey(cameraUID)] = babyInfo.Name
+		}
+	}
+
+	debouncer := NewDebouncer(notifier.Opts.RecoveryDebounce, notifier.Dispatch)
+
+	unsubscribe := manager.Subscribe(func(babyUID string, state baby.State) {
+		for _, event := range notifier.detector.Detect(babyUID, state) {
+			event.BabyName = babyNames[babyUID]
+			if event.BabyName == "" {
+				event.BabyName = babyUID
+			}
+
+			debouncer.Push(event)
+		}
+	})
+
+	<-ctx.Done()
+	unsubscribe()
+	debouncer.Stop()
+	notifier.stopAggregation()
+}
+
+// Dispatch - delivers event to all the sinks (unless the event is disabled, rate limited or aggregated)
+func (notifier *Notifier) Dispatch(event Event) {
+	if !notifier.isEnabled(event.Type) {
+		return
+	}
+
+	if notifier.isQuiet(event) {
+		log.Debug().Str("baby_uid", event.BabyUID).Str("event", string(event.Type)).Msg("Notification suppressed by quiet hours")
+		return
+	}
+
+	if !notifier.allow(event) {
+		if notifier.Opts.Aggregate {
+			notifier.aggregate(event)
+			log.Debug().Str("baby_uid", event.BabyUID).Str("event", string(event.Type)).Msg("Notification rate limited, will be included in the summary")
+		} else {
+			log.Debug().Str("baby_uid", event.BabyUID).Str("event", string(event.Type)).Msg("Notification rate limited")
+		}
+
+		return
+	}
+
+	notifier.send(event)
+}
+
+// send - delivers event to all the sinks in the background
+func (notifier *Notifier) send(event Event) {
+	if len(notifier.Sinks) == 0 {
+		return
+	}
+
+	go func() {
+		if notifier.SnapshotProvider != nil && containsEventType(notifier.Opts.SnapshotEvents, event.Type) {
+			snapshot, err := notifier.SnapshotProvider(event.BabyUID)
+			if err != nil {
+				log.Warn().Err(err).Str("baby_uid", event.BabyUID).Msg("Unable to capture snapshot for notification, sending without it")
+			} else {
+				event.Snapshot = snapshot
+			}
+		}
+
+		for _, sink := range notifier.Sinks {
+			go func(sink Sink) {
+				sublog := log.With().Str("sink", sink.Name()).Str("baby_uid", event.BabyUID).Str("event", string(event.Type)).Logger()
+
+				if err := sink.Send(event); err != nil {
+					sublog.Error().Err(err).Msg("Unable to send notification")
+				} else {
+					sublog.Info().Msg("Notification sent")
+				}
+			}(sink)
+		}
+	}()
+}
+
+func (notifier *Notifier) isEnabled(eventType EventType) bool {
+	return containsEventType(notifier.Opts.Events, eventType)
+}
+
+type rateLimitKey struct {
+	babyUID   string
+	eventType EventType
+}
+
+func getRateLimitKey(event Event) rateLimitKey {
+	return rateLimitKey{event.BabyUID, event.Type}
+}
+
+// allow - rate limiting per baby and event type (shared by all the sinks)
+func (notifier *Notifier) allow(event Event) bool {
+	key := getRateLimitKey(event)
+
+	notifier.lastSentMu.Lock()
+	defer notifier.lastSentMu.Unlock()
+
+	if lastSent, ok := notifier.lastSent[key]; ok && event.Time.Sub(lastSent) < notifier.Opts.getMinInterval(event.Type) {
+		return false
+	}
+
+	notifier.lastSent[key] = event.Time
+	return true
+}
+
+// Cooldown - rate limiting state of the event of the baby
+type Cooldown struct {
+	BabyUID    string
+	Event      EventType
+	LastSentAt time.Time
+	Interval   time.Duration
+
+	// AllowedAt - next notification of the event is delivered only after this time
+	AllowedAt time.Time
+
+	// Aggregated - number of events waiting for the summary (see Opts.Aggregate)
+	Aggregated int
+}
+
+// GetCooldowns - returns rate limiting state of all the events which have been delivered, sorted by baby and event
+func (notifier *Notifier) GetCooldowns() []Cooldown {
+	notifier.lastSentMu.Lock()
+	defer notifier.lastSentMu.Unlock()
+
+	cooldowns := []Cooldown{}
+	for key, lastSent := range notifier.lastSent {
+		interval := notifier.Opts.getMinInterval(key.eventType)
+		cooldown := Cooldown{
+			BabyUID:    key.babyUID,
+			Event:      key.eventType,
+			LastSentAt: lastSent,
+			Interval:   interval,
+			AllowedAt:  lastSent.Add(interval),
+		}
+
+		if entry, ok := notifier.aggregated[key]; ok {
+			cooldown.Aggregated = entry.count
+		}
+
+		cooldowns = append(cooldowns, cooldown)
+	}
+
+	sort.Slice(cooldowns, func(i, j int) bool {
+		if cooldowns[i].BabyUID != cooldowns[j].BabyUID {
+			return cooldowns[i].BabyUID < cooldowns[j].BabyUID
+		}
+
+		return cooldowns[i].Event < cooldowns[j].Event
+	})
+
+	return cooldowns
+}
@@ -0,0 +1,32 @@
+package notify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifierMinIntervalByEvent(t *testing.T) {
+	notifier := NewNotifier(Opts{
+		Events:             []EventType{EventStreamDown, EventTemperatureHigh},
+		MinInterval:        10 * time.Minute,
+		MinIntervalByEvent: map[EventType]time.Duration{EventStreamDown: 0},
+	})
+
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		assert.True(t, notifier.allow(Event{BabyUID: "baby1", Type: EventStreamDown, Time: now}))
+	}
+
+	assert.True(t, notifier.allow(Event{BabyUID: "baby1", Type: EventTemperatureHigh, Time: now}))
+	assert.False(t, notifier.allow(Event{BabyUID: "baby1", Type: EventTemperatureHigh, Time: now.Add(time.Minute)}))
+
+	cooldowns := notifier.GetCooldowns()
+	if assert.Len(t, cooldowns, 2) {
+		assert.Equal(t, EventStreamDown, cooldowns[0].Event)
+		assert.Equal(t, now, cooldowns[0].AllowedAt)
+		assert.Equal(t, EventTemperatureHigh, cooldowns[1].Event)
+		assert.Equal(t, now.Add(10*time.Minute), cooldowns[1].AllowedAt)
+	}
+}
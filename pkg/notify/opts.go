@@ -0,0 +1,149 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Opts - notification options
+type Opts struct {
+	// Events - which events should be delivered
+	Events []EventType
+
+	// MinInterval - minimal interval between notifications of the same event for the same baby
+	MinInterval time.Duration
+
+	// MinIntervalByEvent - per event override of MinInterval (0 = every occurrence is delivered)
+	MinIntervalByEvent map[EventType]time.Duration
+
+	// Aggregate - events rate limited by MinInterval are delivered as a single summary at the end of the interval (instead of being dropped)
+	Aggregate bool
+
+	// Thresholds - sensor thresholds for the threshold events
+	Thresholds Thresholds
+
+	// RecoveryDebounce - recovery events (stream_up, cam_online) are delivered only if the recovery lasts this long (0 = right away)
+	RecoveryDebounce time.Duration
+
+	// SnapshotEvents - events which should carry a snapshot of the stream (on sinks supporting media)
+	SnapshotEvents []EventType
+
+	// QuietHours - windows during which the notifications are suppressed (state is still updated)
+	QuietHours []QuietWindow
+
+	// QuietHoursByBaby - baby specific windows, replace QuietHours for the baby
+	QuietHoursByBaby map[string][]QuietWindow
+
+	// Timezone - timezone of the quiet hours (nil = local)
+	Timezone *time.Location
+
+	Ntfy     *NtfyOpts
+	Pushover *PushoverOpts
+	Telegram *TelegramOpts
+	Discord  *DiscordOpts
+}
+
+// Thresholds - sensor thresholds, nil means not set
+type Thresholds struct {
+	TemperatureMin *float64
+	TemperatureMax *float64
+	HumidityMin    *float64
+	HumidityMax    *float64
+}
+
+// NtfyOpts - options for ntfy.sh (or self-hosted ntfy) sink
+type NtfyOpts struct {
+	ServerURL string
+	Topic     string
+
+	// Optional access token
+	Token string
+}
+
+// PushoverOpts - options for Pushover sink
+type PushoverOpts struct {
+	AppToken string
+	UserKey  string
+}
+
+// TelegramOpts - options for Telegram bot sink
+type TelegramOpts struct {
+	BotToken string
+	ChatID   string
+}
+
+// DiscordOpts - options for Discord webhook sink
+type DiscordOpts struct {
+	WebhookURL string
+}
+
+// HasSinks - returns true if any sink is configured
+func (opts Opts) HasSinks() bool {
+	return opts.Ntfy != nil || opts.Pushover != nil || opts.Telegram != nil || opts.Discord != nil
+}
+
+// Validate - checks the options, returns descriptive error if they are not valid
+func (opts Opts) Validate() error {
+	for _, eventType := range append(opts.Events, opts.SnapshotEvents...) {
+		if !containsEventType(EventTypes, eventType) {
+			return fmt.Errorf("unknown event %q (allowed values %v)", eventType, EventTypes)
+		}
+	}
+
+	if opts.MinInterval < 0 {
+		return errors.New("minimal interval cannot be negative")
+	}
+
+	for eventType, minInterval := range opts.MinIntervalByEvent {
+		if !containsEventType(EventTypes, eventType) {
+			return fmt.Errorf("unknown event %q in minimal intervals (allowed values %v)", eventType, EventTypes)
+		}
+
+		if minInterval < 0 {
+			return fmt.Errorf("minimal interval of %v cannot be negative", eventType)
+		}
+	}
+
+	if opts.RecoveryDebounce < 0 {
+		return errors.New("recovery debounce cannot be negative")
+	}
+
+	if opts.Ntfy != nil && (opts.Ntfy.ServerURL == "" || opts.Ntfy.Topic == "") {
+		return errors.New("ntfy server URL and topic are required")
+	}
+
+	if opts.Pushover != nil && (opts.Pushover.AppToken == "" || opts.Pushover.UserKey == "") {
+		return errors.New("Pushover app token and user key are required")
+	}
+
+	if opts.Telegram != nil && (opts.Telegram.BotToken == "" || opts.Telegram.ChatID == "") {
+		return errors.New("Telegram bot token and chat ID are required")
+	}
+
+	if opts.Discord != nil && !strings.HasPrefix(opts.Discord.WebhookURL, "https://") {
+		return fmt.Errorf("invalid Discord webhook URL %q", opts.Discord.WebhookURL)
+	}
+
+	return nil
+}
+
+// getMinInterval - returns minimal interval between notifications of given event
+func (opts Opts) getMinInterval(eventType EventType) time.Duration {
+	if minInterval, ok := opts.MinIntervalByEvent[eventType]; ok {
+		return minInterval
+	}
+
+	return opts.MinInterval
+}
+
+func containsEventType(eventTypes []EventType, eventType EventType) bool {
+	for _, t := range eventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,152 @@
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// QuietWindow - daily time window during which the notifications are suppressed (might span midnight, ie. 22:00-06:30)
+type QuietWindow struct {
+	// Start, End - minutes since midnight
+	Start int
+	End   int
+}
+
+// String - formats the window as HH:MM-HH:MM
+func (window QuietWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", window.Start/60, window.Start%60, window.End/60, window.End%60)
+}
+
+// contains - returns true if the time of day falls into the window (start inclusive, end exclusive)
+func (window QuietWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if window.Start <= window.End {
+		return minute >= window.Start && minute < window.End
+	}
+
+	return minute >= window.Start || minute < window.End
+}
+
+// ParseQuietWindows - parses comma separated list of windows (ie. 22:00-06:30,13:00-15:00)
+func ParseQuietWindows(spec string) ([]QuietWindow, error) {
+	var windows []QuietWindow
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, "-")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid quiet hours window %q, expected HH:MM-HH:MM", item)
+		}
+
+		start, err := parseTimeOfDay(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid quiet hours window %q: %v", item, err)
+		}
+
+		end, err := parseTimeOfDay(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid quiet hours window %q: %v", item, err)
+		}
+
+		if start == end {
+			return nil, fmt.Errorf("invalid quiet hours window %q, start and end are the same", item)
+		}
+
+		windows = append(windows, QuietWindow{Start: start, End: end})
+	}
+
+	return windows, nil
+}
+
+// ParseQuietWindowsByBaby - parses semicolon separated list of baby windows (ie. abc123:13:00-15:00,22:00-07:00;def456:12:00-14:00)
+func ParseQuietWindowsByBaby(spec string) (map[string][]QuietWindow, error) {
+	windowsByBaby := make(map[string][]QuietWindow)
+	for _, item := range strings.Split(spec, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid quiet hours %q, expected {baby_uid}:{windows}", item)
+		}
+
+		windows, err := ParseQuietWindows(parts[1])
+		if err != nil {
+			return nil, err
+		}
+
+		windowsByBaby[strings.TrimSpace(parts[0])] = windows
+	}
+
+	return windowsByBaby, nil
+}
+
+func parseTimeOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// QuietHours - windows during which the events are suppressed (shared by the notifications and the MQTT last event)
+type QuietHours struct {
+	Windows       []QuietWindow
+	WindowsByBaby map[string][]QuietWindow
+
+	// Timezone - timezone of the windows (nil = local)
+	Timezone *time.Location
+}
+
+// GetQuietHours - returns quiet hours of the options
+func (opts Opts) GetQuietHours() QuietHours {
+	return QuietHours{
+		Windows:       opts.QuietHours,
+		WindowsByBaby: opts.QuietHoursByBaby,
+		Timezone:      opts.Timezone,
+	}
+}
+
+// IsQuiet - returns true if the event of the baby falls into the quiet hours
+// Baby specific windows replace the global ones, secondary cameras inherit the windows of their baby
+func (quietHours QuietHours) IsQuiet(event Event) bool {
+	windows, ok := quietHours.WindowsByBaby[event.BabyUID]
+	if !ok {
+		for babyUID, babyWindows := range quietHours.WindowsByBaby {
+			if strings.HasPrefix(event.BabyUID, babyUID+"-") {
+				windows, ok = babyWindows, true
+				break
+			}
+		}
+	}
+
+	if !ok {
+		windows = quietHours.Windows
+	}
+
+	location := quietHours.Timezone
+	if location == nil {
+		location = time.Local
+	}
+
+	localTime := event.Time.In(location)
+	for _, window := range windows {
+		if window.contains(localTime) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isQuiet - returns true if the event of the baby falls into the quiet hours of the notifier
+func (notifier *Notifier) isQuiet(event Event) bool {
+	return notifier.Opts.GetQuietHours().IsQuiet(event)
+}
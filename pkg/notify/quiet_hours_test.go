@@ -0,0 +1,59 @@
+package notify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQuietWindows(t *testing.T) {
+	windows, err := ParseQuietWindows("22:00-06:30, 13:00-15:00")
+	assert.NoError(t, err)
+	assert.Equal(t, []QuietWindow{{Start: 22 * 60, End: 6*60 + 30}, {Start: 13 * 60, End: 15 * 60}}, windows)
+	assert.Equal(t, "22:00-06:30", windows[0].String())
+
+	for _, spec := range []string{"22:00", "25:00-06:00", "10:00-10:00", "abc-06:00"} {
+		_, err := ParseQuietWindows(spec)
+		assert.Error(t, err, spec)
+	}
+
+	byBaby, err := ParseQuietWindowsByBaby("abc123:13:00-15:00,20:00-07:00; def456:12:00-14:00")
+	assert.NoError(t, err)
+	assert.Len(t, byBaby["abc123"], 2)
+	assert.Len(t, byBaby["def456"], 1)
+
+	_, err = ParseQuietWindowsByBaby("13:00-15:00")
+	assert.Error(t, err)
+}
+
+func TestNotifierIsQuiet(t *testing.T) {
+	location := time.FixedZone("UTC+2", 2*60*60)
+	notifier := NewNotifier(Opts{
+		QuietHours:       []QuietWindow{{Start: 22 * 60, End: 6 * 60}},
+		QuietHoursByBaby: map[string][]QuietWindow{"baby2": {{Start: 13 * 60, End: 15 * 60}}},
+		Timezone:         location,
+	})
+
+	at := func(hour int, minute int) time.Time {
+		return time.Date(2021, 1, 1, hour, minute, 0, 0, location).UTC()
+	}
+
+	tests := []struct {
+		babyUID  string
+		time     time.Time
+		expected bool
+	}{
+		{"baby1", at(23, 0), true},
+		{"baby1", at(5, 59), true},
+		{"baby1", at(6, 0), false},
+		{"baby1", at(14, 0), false},
+		{"baby2", at(14, 0), true},
+		{"baby2", at(23, 0), false},
+		{"baby2-cam2", at(14, 0), true},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, notifier.isQuiet(Event{BabyUID: test.babyUID, Time: test.time}), "%v at %v", test.babyUID, test.time)
+	}
+}
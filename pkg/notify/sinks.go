@@ -0,0 +1,236 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// Sink - notification channel
+type Sink interface {
+	// Name - sink identifier for logging
+	Name() string
+
+	// Send - delivers event to the user
+	Send(event Event) error
+}
+
+// ------------------------------------------
+
+type ntfySink struct {
+	opts NtfyOpts
+}
+
+// NewNtfySink - constructor
+func NewNtfySink(opts NtfyOpts) Sink {
+	return &ntfySink{opts}
+}
+
+func (sink *ntfySink) Name() string { return "ntfy" }
+
+func (sink *ntfySink) Send(event Event) error {
+	req, err := http.NewRequest("POST", strings.TrimSuffix(sink.opts.ServerURL, "/")+"/"+sink.opts.Topic, strings.NewReader(event.Message))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Title", event.Title())
+	req.Header.Set("Tags", string(event.Type))
+	if sink.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+sink.opts.Token)
+	}
+
+	return doRequest(req)
+}
+
+// ------------------------------------------
+
+type pushoverSink struct {
+	opts PushoverOpts
+}
+
+// NewPushoverSink - constructor
+func NewPushoverSink(opts PushoverOpts) Sink {
+	return &pushoverSink{opts}
+}
+
+func (sink *pushoverSink) Name() string { return "pushover" }
+
+func (sink *pushoverSink) Send(event Event) error {
+	form := url.Values{
+		"token":     {sink.opts.AppToken},
+		"user":      {sink.opts.UserKey},
+		"title":     {event.Title()},
+		"message":   {event.Message},
+		"timestamp": {fmt.Sprintf("%v", event.Time.Unix())},
+	}
+
+	req, err := http.NewRequest("POST", "https://api.pushover.net/1/messages.json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doRequest(req)
+}
+
+// ------------------------------------------
+
+type telegramSink struct {
+	opts TelegramOpts
+}
+
+// NewTelegramSink - constructor
+func NewTelegramSink(opts TelegramOpts) Sink {
+	return &telegramSink{opts}
+}
+
+func (sink *telegramSink) Name() string { return "telegram" }
+
+func (sink *telegramSink) Send(event Event) error {
+	// Bot token is part of the URL, keep it out of the logs
+	return redactError(sink.send(event), sink.opts.BotToken)
+}
+
+func (sink *telegramSink) send(event Event) error {
+	text := event.Title() + "\n" + event.Message
+	apiURL := "https://api.telegram.org/bot" + sink.opts.BotToken
+
+	if event.Snapshot != nil {
+		req, err := newMultipartRequest(apiURL+"/sendPhoto", map[string]string{
+			"chat_id": sink.opts.ChatID,
+			"caption": text,
+		}, "photo", event.Snapshot)
+		if err != nil {
+			return err
+		}
+
+		return doRequest(req)
+	}
+
+	form := url.Values{
+		"chat_id": {sink.opts.ChatID},
+		"text":    {text},
+	}
+
+	req, err := http.NewRequest("POST", apiURL+"/sendMessage", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doRequest(req)
+}
+
+// ------------------------------------------
+
+type discordSink struct {
+	opts DiscordOpts
+}
+
+// NewDiscordSink - constructor
+func NewDiscordSink(opts DiscordOpts) Sink {
+	return &discordSink{opts}
+}
+
+func (sink *discordSink) Name() string { return "discord" }
+
+func (sink *discordSink) Send(event Event) error {
+	// Webhook URL works as a credential, keep it out of the logs
+	return redactError(sink.send(event), sink.opts.WebhookURL)
+}
+
+func (sink *discordSink) send(event Event) error {
+	payload, err := json.Marshal(map[string]string{
+		"content": fmt.Sprintf("**%v**\n%v", event.Title(), event.Message),
+	})
+	if err != nil {
+		return err
+	}
+
+	if event.Snapshot != nil {
+		req, err := newMultipartRequest(sink.opts.WebhookURL, map[string]string{
+			"payload_json": string(payload),
+		}, "files[0]", event.Snapshot)
+		if err != nil {
+			return err
+		}
+
+		return doRequest(req)
+	}
+
+	req, err := http.NewRequest("POST", sink.opts.WebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	return doRequest(req)
+}
+
+// ------------------------------------------
+
+// newMultipartRequest - builds POST request with form fields and a single JPEG image attached
+func newMultipartRequest(targetURL string, fields map[string]string, imageField string, image []byte) (*http.Request, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	for key, value := range fields {
+		if err := writer.WriteField(key, value); err != nil {
+			return nil, err
+		}
+	}
+
+	part, err := writer.CreateFormFile(imageField, "snapshot.jpg")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := part.Write(image); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", targetURL, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req, nil
+}
+
+// redactError - replaces secret in the error message (ie. http.Client errors contain the whole URL)
+func redactError(err error, secret string) error {
+	if err == nil || secret == "" {
+		return err
+	}
+
+	return errors.New(strings.ReplaceAll(err.Error(), secret, "***"))
+}
+
+func doRequest(req *http.Request) error {
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("Server responded with unexpected status code %v", res.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package rtmpserver
+
+import (
+	"time"
+
+	"github.com/notedit/rtmp/av"
+)
+
+// ProbeOpts - conditions which published stream needs to meet before it is declared alive (or unhealthy)
+type ProbeOpts struct {
+	// MinFrames - minimal number of received audio/video packets
+	MinFrames int
+
+	// Duration - minimal time for which the stream has to be flowing
+	Duration time.Duration
+
+	// UnhealthyDebounce - how long the stream has to stay down before it is declared unhealthy
+	// Cam reconnecting within this window does not cause the alive/unhealthy flapping (0 = declare right away)
+	UnhealthyDebounce time.Duration
+}
+
+type streamProbe struct {
+	opts      ProbeOpts
+	startTime time.Time
+	numFrames int
+
+	// Tracks announced by the decoder configs (sequence headers)
+	hasVideoTrack bool
+	hasAudioTrack bool
+
+	numVideoFrames int
+	numAudioFrames int
+}
+
+func newStreamProbe(opts ProbeOpts) *streamProbe {
+	return &streamProbe{
+		opts:      opts,
+		startTime: time.Now(),
+	}
+}
+
+// feed - registers received packet, returns true once the stream meets the probe conditions
+// Note: stream announcing a video track has to deliver video frames, audio-only stream is fine with audio frames
+func (probe *streamProbe) feed(pkt av.Packet) bool {
+	switch pkt.Type {
+	case av.H264DecoderConfig, av.H264SPSPPSNALU:
+		probe.hasVideoTrack = true
+	case av.AACDecoderConfig:
+		probe.hasAudioTrack = true
+	case av.H264:
+		probe.numFrames++
+		probe.numVideoFrames++
+	case av.AAC, av.OPUS:
+		probe.numFrames++
+		probe.numAudioFrames++
+	}
+
+	if probe.hasVideoTrack && probe.numVideoFrames == 0 {
+		return false
+	}
+
+	return probe.numFrames >= probe.opts.MinFrames && time.Since(probe.startTime) >= probe.opts.Duration
+}
+
+// hasVideo - returns true if the stream carries video (announced or not)
+func (probe *streamProbe) hasVideo() bool {
+	return probe.hasVideoTrack || probe.numVideoFrames > 0
+}
+
+// hasAudio - returns true if the stream carries audio (announced or not)
+func (probe *streamProbe) hasAudio() bool {
+	return probe.hasAudioTrack || probe.numAudioFrames > 0
+}
@@ -0,0 +1,44 @@
+package rtmpserver
+
+import (
+	"testing"
+
+	"github.com/notedit/rtmp/av"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamProbeTracks(t *testing.T) {
+	feed := func(probe *streamProbe, types ...int) bool {
+		alive := false
+		for _, pktType := range types {
+			alive = probe.feed(av.Packet{Type: pktType})
+		}
+
+		return alive
+	}
+
+	t.Run("audio and video", func(t *testing.T) {
+		probe := newStreamProbe(ProbeOpts{MinFrames: 2})
+		assert.True(t, feed(probe, av.H264DecoderConfig, av.AACDecoderConfig, av.AAC, av.H264))
+		assert.True(t, probe.hasVideo())
+		assert.True(t, probe.hasAudio())
+	})
+
+	t.Run("video announced but only audio flowing", func(t *testing.T) {
+		probe := newStreamProbe(ProbeOpts{MinFrames: 2})
+		assert.False(t, feed(probe, av.H264DecoderConfig, av.AACDecoderConfig, av.AAC, av.AAC, av.AAC))
+		assert.True(t, feed(probe, av.H264))
+	})
+
+	t.Run("audio only", func(t *testing.T) {
+		probe := newStreamProbe(ProbeOpts{MinFrames: 2})
+		assert.True(t, feed(probe, av.AACDecoderConfig, av.AAC, av.AAC))
+		assert.False(t, probe.hasVideo())
+	})
+
+	t.Run("video only", func(t *testing.T) {
+		probe := newStreamProbe(ProbeOpts{MinFrames: 2})
+		assert.True(t, feed(probe, av.H264DecoderConfig, av.H264, av.H264))
+		assert.False(t, probe.hasAudio())
+	})
+}
@@ -4,46 +4,123 @@ import (
 	"net"
 	"regexp"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/notedit/rtmp/format/rtmp"
-	"github.com/rs/zerolog/log"
 	"gitlab.com/adam.stanek/nanit/pkg/baby"
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
 )
 
 type rtmpHandler struct {
+	probeOpts         ProbeOpts
 	babyStateManager  *baby.StateManager
 	broadcastersMu    sync.RWMutex
 	broadcastersByUID map[string]*broadcaster
+
+	pendingUnhealthyMu sync.Mutex
+	pendingUnhealthy   map[string]*time.Timer
+
+	// isKnownBaby - optional, publishers of unknown babies are rejected
+	isKnownBaby func(babyUID string) bool
+}
+
+// Server - RTMP server supervised within graceful context
+type Server struct {
+	Addr string
+
+	handler   *rtmpHandler
+	isRunning int32
+}
+
+// NewServer - constructor
+// isKnownBaby is optional, streams published for other babies are rejected (ie. stale cam config), nil = all accepted
+func NewServer(addr string, probeOpts ProbeOpts, babyStateManager *baby.StateManager, isKnownBaby func(babyUID string) bool) *Server {
+	return &Server{
+		Addr:    addr,
+		handler: newRtmpHandler(probeOpts, babyStateManager, isKnownBaby),
+	}
 }
 
-// StartRTMPServer - Blocking server
-func StartRTMPServer(addr string, babyStateManager *baby.StateManager) {
-	lis, err := net.Listen("tcp", addr)
+// Run - runs the server and restarts it upon failure, blocks until the context is cancelled
+func (server *Server) Run(ctx utils.GracefulContext) {
+	utils.RunWithPerseverance(func(attempt utils.AttemptContext) {
+		if err := server.serve(attempt); err != nil {
+			log.Error().Str("addr", server.Addr).Err(err).Msg("RTMP server failed")
+			attempt.Fail(err)
+		}
+	}, ctx, utils.PerseverenceOpts{
+		RunnerID:       "rtmpserver",
+		ResetThreshold: 2 * time.Second,
+		Cooldown: []time.Duration{
+			2 * time.Second,
+			10 * time.Second,
+			1 * time.Minute,
+		},
+	})
+}
+
+// IsRunning - returns true if the server is accepting connections
+func (server *Server) IsRunning() bool {
+	return atomic.LoadInt32(&server.isRunning) == 1
+}
+
+// serve - accepts connections until the context is cancelled (returns nil) or the listener fails (returns error)
+func (server *Server) serve(ctx utils.GracefulContext) error {
+	lis, err := net.Listen("tcp", server.Addr)
 	if err != nil {
-		log.Fatal().Str("addr", addr).Err(err).Msg("Unable to start RTMP server")
-		panic(err)
+		return err
 	}
 
-	log.Info().Str("addr", addr).Msg("RTMP server started")
+	log.Info().Str("addr", server.Addr).Msg("RTMP server started")
+	atomic.StoreInt32(&server.isRunning, 1)
+	defer atomic.StoreInt32(&server.isRunning, 0)
+
+	// Unblock Accept() when cancelled
+	stopC := make(chan struct{})
+	defer close(stopC)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stopC:
+		}
+
+		lis.Close()
+	}()
 
 	s := rtmp.NewServer()
-	s.HandleConn = newRtmpHandler(babyStateManager).handleConnection
+	s.HandleConn = server.handler.handleConnection
 
 	for {
 		nc, err := lis.Accept()
 		if err != nil {
-			time.Sleep(time.Second)
-			continue
+			select {
+			case <-ctx.Done():
+				log.Debug().Str("addr", server.Addr).Msg("RTMP server stopped")
+				return nil
+			default:
+			}
+
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				time.Sleep(time.Second)
+				continue
+			}
+
+			return err
 		}
+
 		go s.HandleNetConn(nc)
 	}
 }
 
-func newRtmpHandler(babyStateManager *baby.StateManager) *rtmpHandler {
+func newRtmpHandler(probeOpts ProbeOpts, babyStateManager *baby.StateManager, isKnownBaby func(babyUID string) bool) *rtmpHandler {
 	return &rtmpHandler{
+		probeOpts:         probeOpts,
 		broadcastersByUID: make(map[string]*broadcaster),
 		babyStateManager:  babyStateManager,
+		pendingUnhealthy:  make(map[string]*time.Timer),
+		isKnownBaby:       isKnownBaby,
 	}
 }
 
@@ -63,20 +140,60 @@ func (s *rtmpHandler) handleConnection(c *rtmp.Conn, nc net.Conn) {
 	sublog = sublog.With().Str("baby_uid", babyUID).Logger()
 
 	if c.Publishing {
+		// Nothing would consume the stream
+		if s.isKnownBaby != nil && !s.isKnownBaby(babyUID) {
+			sublog.Warn().Msg("Rejecting stream publisher of unknown baby, check where the cam is streaming to")
+			nc.Close()
+			return
+		}
+
 		sublog.Info().Msg("New stream publisher connected")
+		if s.cancelUnhealthy(babyUID) {
+			sublog.Debug().Msg("Publisher reconnected within debounce window")
+		}
+
 		publisher := s.getNewPublisher(babyUID)
 
-		s.babyStateManager.Update(babyUID, *baby.NewState().SetStreamState(baby.StreamState_Alive))
+		// Stream is declared alive only after it passes the probe (prevents false positives on marginal connections)
+		probe := newStreamProbe(s.probeOpts)
+		isAlive := false
+		stats := newStreamStats(time.Now())
 
 		for {
 			pkt, err := c.ReadPacket()
 			if err != nil {
 				sublog.Warn().Err(err).Msg("Publisher stream closed unexpectedly")
-				s.babyStateManager.Update(babyUID, *baby.NewState().SetStreamState(baby.StreamState_Unhealthy))
+				if isAlive {
+					// Nothing is flowing anymore, resolution is kept as the last known one
+					s.babyStateManager.Update(babyUID, *baby.NewState().SetStreamFramerate(0).SetStreamBitrateKbps(0))
+				}
+
+				s.scheduleUnhealthy(babyUID)
 				s.closePublisher(babyUID, publisher)
 				return
 			}
 
+			if !isAlive && probe.feed(pkt) {
+				isAlive = true
+				sublog.Debug().Int("frames", probe.numFrames).Bool("video", probe.hasVideo()).Bool("audio", probe.hasAudio()).Msg("Stream passed the probe")
+				if !probe.hasVideo() {
+					sublog.Warn().Msg("Stream carries no video, snapshots will not be available")
+				}
+
+				// Note: alive time is reset even if the publisher reconnected within the debounce window
+				s.babyStateManager.Update(babyUID, *baby.NewState().
+					SetStreamState(baby.StreamState_Alive).
+					SetStreamAliveSince(time.Now()).
+					SetStreamHasVideo(probe.hasVideo()))
+			}
+
+			// Note: first measurement may include the probing period, it is published only once the stream is alive
+			if measurement := stats.feed(pkt, time.Now()); measurement != nil && isAlive {
+				if stateUpdate := stats.significantChanges(measurement); stateUpdate != nil {
+					s.babyStateManager.Update(babyUID, *stateUpdate)
+				}
+			}
+
 			publisher.broadcast(pkt)
 		}
 
@@ -105,11 +222,62 @@ func (s *rtmpHandler) handleConnection(c *rtmp.Conn, nc net.Conn) {
 			case <-closeC:
 				sublog.Debug().Msg("Stream subscriber disconnected")
 				unsubscribe()
+				return
 			}
 		}
 	}
 }
 
+// scheduleUnhealthy - declares the stream unhealthy unless the publisher reconnects within the debounce window
+func (s *rtmpHandler) scheduleUnhealthy(babyUID string) {
+	markUnhealthy := func() {
+		s.babyStateManager.Update(babyUID, *baby.NewState().SetStreamState(baby.StreamState_Unhealthy))
+	}
+
+	if s.probeOpts.UnhealthyDebounce <= 0 {
+		markUnhealthy()
+		return
+	}
+
+	s.pendingUnhealthyMu.Lock()
+	defer s.pendingUnhealthyMu.Unlock()
+
+	if timer, ok := s.pendingUnhealthy[babyUID]; ok {
+		timer.Stop()
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(s.probeOpts.UnhealthyDebounce, func() {
+		s.pendingUnhealthyMu.Lock()
+		isCurrent := s.pendingUnhealthy[babyUID] == timer
+		if isCurrent {
+			delete(s.pendingUnhealthy, babyUID)
+		}
+		s.pendingUnhealthyMu.Unlock()
+
+		if isCurrent {
+			log.Debug().Str("baby_uid", babyUID).Msg("Publisher did not reconnect within debounce window")
+			markUnhealthy()
+		}
+	})
+
+	s.pendingUnhealthy[babyUID] = timer
+}
+
+// cancelUnhealthy - cancels scheduled unhealthy declaration, returns true if there was any
+func (s *rtmpHandler) cancelUnhealthy(babyUID string) bool {
+	s.pendingUnhealthyMu.Lock()
+	defer s.pendingUnhealthyMu.Unlock()
+
+	timer, ok := s.pendingUnhealthy[babyUID]
+	if ok {
+		timer.Stop()
+		delete(s.pendingUnhealthy, babyUID)
+	}
+
+	return ok
+}
+
 func (s *rtmpHandler) getNewPublisher(babyUID string) *broadcaster {
 	broadcaster := newBroadcaster()
 
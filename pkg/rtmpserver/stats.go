@@ -0,0 +1,124 @@
+package rtmpserver
+
+import (
+	"math"
+	"time"
+
+	"github.com/notedit/rtmp/av"
+	"github.com/notedit/rtmp/codec/h264"
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+)
+
+// streamStatsInterval - window over which the bitrate and framerate are measured
+const streamStatsInterval = 10 * time.Second
+
+// streamStatsBitrateStep - measured bitrate is rounded to this many kbps, so that the state does not change on every measurement
+const streamStatsBitrateStep = 10
+
+// streamStatsMinChange - relative change of the framerate or bitrate needed to report the new value
+// Note: bitrate of the cam stream fluctuates by a few percent between the windows, reporting each of them would
+// update the state (MQTT publish, history record, ...) every streamStatsInterval
+const streamStatsMinChange = 0.1
+
+// streamStats - measures properties of the published stream from the received packets
+type streamStats struct {
+	windowStart    time.Time
+	numBytes       int
+	numVideoFrames int
+
+	// Resolution is known only once the cam sends H264 decoder config (0 until then)
+	width  int
+	height int
+
+	// Last values passed to the state (nil until reported)
+	reported *baby.State
+}
+
+func newStreamStats(now time.Time) *streamStats {
+	return &streamStats{
+		windowStart: now,
+	}
+}
+
+// feed - registers received packet, returns state update once the measurement window is over (nil otherwise)
+func (stats *streamStats) feed(pkt av.Packet, now time.Time) *baby.State {
+	switch pkt.Type {
+	case av.H264DecoderConfig:
+		if codec, err := h264.FromDecoderConfig(pkt.Data); err == nil && codec.W > 0 && codec.H > 0 {
+			stats.width = codec.W
+			stats.height = codec.H
+		}
+	case av.H264:
+		stats.numVideoFrames++
+	}
+
+	stats.numBytes += len(pkt.Data)
+
+	elapsed := now.Sub(stats.windowStart)
+	if elapsed < streamStatsInterval {
+		return nil
+	}
+
+	bitrateKbps := float64(stats.numBytes*8) / 1000 / elapsed.Seconds()
+	framerate := float64(stats.numVideoFrames) / elapsed.Seconds()
+
+	stateUpdate := baby.NewState().
+		SetStreamBitrateKbps(int32(math.Round(bitrateKbps/streamStatsBitrateStep) * streamStatsBitrateStep)).
+		SetStreamFramerate(int32(math.Round(framerate)))
+
+	if stats.width > 0 {
+		stateUpdate.SetStreamResolution(int32(stats.width), int32(stats.height))
+	}
+
+	stats.windowStart = now
+	stats.numBytes = 0
+	stats.numVideoFrames = 0
+
+	return stateUpdate
+}
+
+// significantChanges - filters the measurement down to the values which differ enough from the reported ones (nil if there are none)
+// The returned values are considered reported.
+func (stats *streamStats) significantChanges(measurement *baby.State) *baby.State {
+	if stats.reported == nil {
+		stats.reported = baby.NewState()
+	}
+
+	stateUpdate := baby.NewState()
+	changed := false
+
+	if isSignificantChange(stats.reported.StreamFramerate, *measurement.StreamFramerate) {
+		stateUpdate.SetStreamFramerate(*measurement.StreamFramerate)
+		changed = true
+	}
+
+	if isSignificantChange(stats.reported.StreamBitrateKbps, *measurement.StreamBitrateKbps) {
+		stateUpdate.SetStreamBitrateKbps(*measurement.StreamBitrateKbps)
+		changed = true
+	}
+
+	if measurement.StreamWidth != nil && (stats.reported.StreamWidth == nil ||
+		*stats.reported.StreamWidth != *measurement.StreamWidth || *stats.reported.StreamHeight != *measurement.StreamHeight) {
+		stateUpdate.SetStreamResolution(*measurement.StreamWidth, *measurement.StreamHeight)
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	stats.reported = stats.reported.Merge(stateUpdate)
+	return stateUpdate
+}
+
+func isSignificantChange(reported *int32, value int32) bool {
+	if reported == nil {
+		return true
+	}
+
+	if *reported == 0 {
+		return value != 0
+	}
+
+	return math.Abs(float64(value-*reported)) >= streamStatsMinChange*float64(*reported)
+}
@@ -0,0 +1,90 @@
+package rtmpserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/notedit/rtmp/av"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+)
+
+// avcDecoderConfig - AVCDecoderConfigurationRecord of 320x240 H264 stream (single SPS, single PPS)
+var avcDecoderConfig = []byte{
+	0x01, 0x64, 0x00, 0x0d, 0xff, 0xe1,
+	0x00, 0x18, 0x67, 0x64, 0x00, 0x0d, 0xac, 0xd9, 0x41, 0x41, 0xfa, 0x10, 0x00, 0x00, 0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x03, 0x20, 0xf1, 0x42, 0x99, 0x60,
+	0x01, 0x00, 0x06, 0x68, 0xeb, 0xe3, 0xcb, 0x22, 0xc0,
+}
+
+func TestStreamStats(t *testing.T) {
+	start := time.Now()
+	stats := newStreamStats(start)
+
+	assert.Nil(t, stats.feed(av.Packet{Type: av.H264DecoderConfig, Data: avcDecoderConfig}, start))
+
+	// 15 fps with 1250 bytes per frame = 150 kbps
+	for i := 1; i < 150; i++ {
+		assert.Nil(t, stats.feed(av.Packet{Type: av.H264, Data: make([]byte, 1250)}, start.Add(time.Duration(i)*time.Second/15)))
+	}
+
+	stateUpdate := stats.feed(av.Packet{Type: av.H264, Data: make([]byte, 1250)}, start.Add(streamStatsInterval))
+	if assert.NotNil(t, stateUpdate) {
+		assert.Equal(t, int32(15), *stateUpdate.StreamFramerate)
+		assert.Equal(t, int32(150), *stateUpdate.StreamBitrateKbps)
+		assert.Equal(t, int32(320), *stateUpdate.StreamWidth)
+		assert.Equal(t, int32(240), *stateUpdate.StreamHeight)
+	}
+}
+
+func TestStreamStatsWithoutDecoderConfig(t *testing.T) {
+	start := time.Now()
+	stats := newStreamStats(start)
+
+	stateUpdate := stats.feed(av.Packet{Type: av.AAC, Data: make([]byte, 100)}, start.Add(streamStatsInterval))
+	if assert.NotNil(t, stateUpdate) {
+		assert.Equal(t, int32(0), *stateUpdate.StreamFramerate)
+		assert.Nil(t, stateUpdate.StreamWidth)
+		assert.Nil(t, stateUpdate.StreamHeight)
+	}
+}
+
+// feedWindow - feeds one measurement window of frames with given size at 15 fps, returns the measurement
+func feedWindow(t *testing.T, stats *streamStats, start time.Time, frameSize int) *baby.State {
+	for i := 1; i < 150; i++ {
+		assert.Nil(t, stats.feed(av.Packet{Type: av.H264, Data: make([]byte, frameSize)}, start.Add(time.Duration(i)*time.Second/15)))
+	}
+
+	measurement := stats.feed(av.Packet{Type: av.H264, Data: make([]byte, frameSize)}, start.Add(streamStatsInterval))
+	require.NotNil(t, measurement)
+
+	return measurement
+}
+
+func TestStreamStatsSignificantChanges(t *testing.T) {
+	start := time.Now()
+	stats := newStreamStats(start)
+	stats.feed(av.Packet{Type: av.H264DecoderConfig, Data: avcDecoderConfig}, start)
+
+	// First measurement is always reported
+	stateUpdate := stats.significantChanges(feedWindow(t, stats, start, 1250))
+	if assert.NotNil(t, stateUpdate) {
+		assert.Equal(t, int32(15), *stateUpdate.StreamFramerate)
+		assert.Equal(t, int32(150), *stateUpdate.StreamBitrateKbps)
+		assert.Equal(t, int32(320), *stateUpdate.StreamWidth)
+	}
+
+	// Steady stream with small fluctuations (150 - 160 kbps) produces no further updates
+	for i, frameSize := range []int{1250, 1300, 1200, 1250, 1300} {
+		windowStart := start.Add(time.Duration(i+1) * streamStatsInterval)
+		assert.Nil(t, stats.significantChanges(feedWindow(t, stats, windowStart, frameSize)))
+	}
+
+	// Bitrate drop is reported, unchanged framerate and resolution are not
+	stateUpdate = stats.significantChanges(feedWindow(t, stats, start.Add(6*streamStatsInterval), 625))
+	if assert.NotNil(t, stateUpdate) {
+		assert.Equal(t, int32(80), *stateUpdate.StreamBitrateKbps)
+		assert.Nil(t, stateUpdate.StreamFramerate)
+		assert.Nil(t, stateUpdate.StreamWidth)
+	}
+}
@@ -2,17 +2,24 @@ package session
 
 import (
 	"encoding/json"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
-	"github.com/rs/zerolog/log"
 	"gitlab.com/adam.stanek/nanit/pkg/baby"
 )
 
-// Revision - marks the version of the structure of a session file. Only files with equal revision will be loaded
+// Revision - marks the version of the structure of a session file. Older files are upgraded through Migrations, files which cannot be migrated (or come from a newer version) are discarded
 // Note: you should increment this whenever you change the Session structure
-const Revision = 2
+const Revision = 4
+
+// FilePerm - permissions of the session file, it contains the auth token so that only the owner can read it
+const FilePerm = os.FileMode(0600)
+
+// DirPerm - permissions of the directory created for the session file
+const DirPerm = os.FileMode(0700)
 
 // Session - application session data container
 type Session struct {
@@ -20,12 +27,17 @@ type Session struct {
 	AuthToken string      `json:"authToken"`
 	AuthTime  time.Time   `json:"authTime"`
 	Babies    []baby.Baby `json:"babies"`
+
+	// Capabilities - detected capabilities of the cameras by state key (see Store.UpdateCapabilities)
+	Capabilities map[string]baby.Capabilities `json:"capabilities,omitempty"`
 }
 
 // Store - application session store context
 type Store struct {
 	Filename string
 	Session  *Session
+
+	mu sync.Mutex
 }
 
 // NewSessionStore - constructor
@@ -35,7 +47,24 @@ func NewSessionStore() *Store {
 	}
 }
 
+// Migration - upgrades raw session data from the revision it is registered for to the next one
+type Migration func(data map[string]interface{}) error
+
+// Migrations - upgrade paths for older session files, keyed by the revision they upgrade from
+// Note: register a migration whenever you increment Revision and the old data can be carried over
+var Migrations = map[int]Migration{
+	// Revision 3 added optional capabilities
+	2: func(data map[string]interface{}) error { return nil },
+
+	// Revision 4 added cameras of the babies, stored babies are dropped so that they are fetched again (with cameras)
+	3: func(data map[string]interface{}) error {
+		delete(data, "babies")
+		return nil
+	},
+}
+
 // Load - loads previous state from a file
+// Incompatible or malformed files are discarded so that the app starts with a fresh session (and reauthorizes)
 func (store *Store) Load() {
 	if _, err := os.Stat(store.Filename); os.IsNotExist(err) {
 		log.Info().Str("filename", store.Filename).Msg("No app session file found")
@@ -49,45 +78,179 @@ func (store *Store) Load() {
 
 	defer f.Close()
 
-	session := &Session{}
-	jsonErr := json.NewDecoder(f).Decode(session)
+	store.restrictPermissions(f)
+
+	data := make(map[string]interface{})
+	jsonErr := json.NewDecoder(f).Decode(&data)
 	if jsonErr != nil {
-		log.Fatal().Str("filename", store.Filename).Err(jsonErr).Msg("Unable to decode app session file")
+		log.Warn().Str("filename", store.Filename).Err(jsonErr).Msg("Unable to decode app session file, discarding it")
+		return
+	}
+
+	revision := 0
+	if value, ok := data["revision"].(float64); ok {
+		revision = int(value)
+	}
+
+	if revision > Revision {
+		log.Warn().Str("filename", store.Filename).Int("revision", revision).Int("supported_revision", Revision).Msg("App session file was written by a newer version of the app, discarding it")
+		return
+	}
+
+	for ; revision < Revision; revision++ {
+		migrate, hasMigration := Migrations[revision]
+		if !hasMigration {
+			log.Warn().Str("filename", store.Filename).Int("revision", revision).Int("supported_revision", Revision).Msg("App session file contains incompatible revision of the state, discarding it (will reauthorize)")
+			return
+		}
+
+		log.Info().Str("filename", store.Filename).Int("from", revision).Int("to", revision+1).Msg("Migrating app session file")
+		if err := migrate(data); err != nil {
+			log.Warn().Str("filename", store.Filename).Int("revision", revision).Err(err).Msg("Unable to migrate app session file, discarding it (will reauthorize)")
+			return
+		}
+
+		data["revision"] = revision + 1
+	}
+
+	session, err := decodeSession(data)
+	if err != nil {
+		log.Warn().Str("filename", store.Filename).Err(err).Msg("Unable to decode app session file, discarding it")
+		return
+	}
+
+	store.Session = session
+	log.Info().Str("filename", store.Filename).Msg("Loaded app session from the file")
+}
+
+// restrictPermissions - removes access of the other users to the existing session file (ie. created by an older version)
+func (store *Store) restrictPermissions(f *os.File) {
+	info, err := f.Stat()
+	if err != nil || info.Mode().Perm()&^FilePerm == 0 {
+		return
+	}
+
+	log.Warn().Str("filename", store.Filename).Str("perm", info.Mode().Perm().String()).Msg("App session file is accessible by other users, restricting its permissions")
+	if err := f.Chmod(FilePerm); err != nil {
+		log.Warn().Str("filename", store.Filename).Err(err).Msg("Unable to restrict permissions of app session file")
+	}
+}
+
+func decodeSession(data map[string]interface{}) (*Session, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &Session{}
+	if err := json.Unmarshal(encoded, session); err != nil {
+		return nil, err
 	}
 
-	if session.Revision == Revision {
-		store.Session = session
-		log.Info().Str("filename", store.Filename).Msg("Loaded app session from the file")
-	} else {
-		log.Warn().Str("filename", store.Filename).Msg("App session file contains older revision of the state, ignoring")
+	return session, nil
+}
+
+// GetAuth - returns the stored auth token and the time it was obtained
+// Note: token is rotated from the request goroutines (see reauthorize), always access it through GetAuth / SetAuth
+func (store *Store) GetAuth() (string, time.Time) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return store.Session.AuthToken, store.Session.AuthTime
+}
+
+// SetAuth - replaces the auth token and stores the session
+func (store *Store) SetAuth(token string, authTime time.Time) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.Session.AuthToken = token
+	store.Session.AuthTime = authTime
+	store.save()
+}
+
+// GetCapabilities - returns detected capabilities of the camera (empty if nothing is known)
+func (store *Store) GetCapabilities(stateKey string) baby.Capabilities {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return store.Session.Capabilities[stateKey]
+}
+
+// UpdateCapabilities - merges the detected capabilities of the camera, stores the session if they changed (second value)
+func (store *Store) UpdateCapabilities(stateKey string, update baby.Capabilities) (baby.Capabilities, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	capabilities, changed := store.Session.Capabilities[stateKey].Merge(update)
+	if changed {
+		if store.Session.Capabilities == nil {
+			store.Session.Capabilities = make(map[string]baby.Capabilities)
+		}
+
+		store.Session.Capabilities[stateKey] = capabilities
+		store.save()
 	}
 
+	return capabilities, changed
 }
 
 // Save - stores current data in a file
 func (store *Store) Save() {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.save()
+}
+
+// save - writes the session to a temporary file which then replaces the original one,
+// so that a crash (or a failed write) never leaves truncated session file behind
+func (store *Store) save() {
 	if store.Filename == "" {
 		return
 	}
 
 	log.Trace().Str("filename", store.Filename).Msg("Storing app session to the file")
 
-	f, err := os.OpenFile(store.Filename, os.O_RDWR|os.O_CREATE, 0644)
+	data, jsonErr := json.Marshal(store.Session)
+	if jsonErr != nil {
+		log.Fatal().Str("filename", store.Filename).Err(jsonErr).Msg("Unable to marshal contents of app session file")
+	}
+
+	if err := writeFileAtomic(store.Filename, data, FilePerm); err != nil {
+		log.Fatal().Str("filename", store.Filename).Err(err).Msg("Unable to write app session file")
+	}
+}
+
+// writeFileAtomic - writes data to a temporary file in the same directory and renames it over the target
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".*.tmp")
 	if err != nil {
-		log.Fatal().Str("filename", store.Filename).Err(err).Msg("Unable to open app session file for writing")
+		return err
 	}
 
-	defer f.Close()
+	tmpFilename := f.Name()
+	defer os.Remove(tmpFilename) // No-op after successful rename
 
-	data, jsonErr := json.Marshal(store.Session)
-	if jsonErr != nil {
-		log.Fatal().Str("filename", store.Filename).Err(jsonErr).Msg("Unable to marshal contents of app session file")
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
 	}
 
-	_, writeErr := f.Write(data)
-	if writeErr != nil {
-		log.Fatal().Str("filename", store.Filename).Err(writeErr).Msg("Unable to wrote to app session file")
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
 	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmpFilename, perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFilename, filename)
 }
 
 // InitSessionStore - Initializes new application session store
@@ -102,6 +265,11 @@ func InitSessionStore(sessionFile string) *Store {
 			log.Fatal().Str("path", sessionFile).Err(filePathErr).Msg("Unable to retrieve absolute file path")
 		}
 
+		// Note: existing directory is left as it is, it can be shared with other data
+		if err := os.MkdirAll(filepath.Dir(absFileName), DirPerm); err != nil {
+			log.Fatal().Str("path", filepath.Dir(absFileName)).Err(err).Msg("Unable to create directory for app session file")
+		}
+
 		sessionStore.Filename = absFileName
 		sessionStore.Load()
 	}
@@ -0,0 +1,169 @@
+package session_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/adam.stanek/nanit/pkg/baby"
+	"gitlab.com/adam.stanek/nanit/pkg/session"
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+func writeSessionFile(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "nanit-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	filename := filepath.Join(dir, "session.json")
+	if err := ioutil.WriteFile(filename, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return filename
+}
+
+func TestSessionLoadCurrentRevision(t *testing.T) {
+	filename := writeSessionFile(t, fmt.Sprintf(`{"revision":%v,"authToken":"token","babies":[{"uid":"abc","camera_uid":"cam"}]}`, session.Revision))
+
+	store := session.InitSessionStore(filename)
+	assert.Equal(t, "token", store.Session.AuthToken)
+	assert.Len(t, store.Session.Babies, 1)
+}
+
+func TestSessionLoadBabiesWithoutCamerasRefetched(t *testing.T) {
+	filename := writeSessionFile(t, `{"revision":3,"authToken":"token","babies":[{"uid":"abc","camera_uid":"cam"}]}`)
+
+	store := session.InitSessionStore(filename)
+	assert.Equal(t, session.Revision, store.Session.Revision)
+	assert.Equal(t, "token", store.Session.AuthToken)
+	assert.Empty(t, store.Session.Babies)
+}
+
+func TestSessionLoadOlderRevisionDiscarded(t *testing.T) {
+	filename := writeSessionFile(t, `{"revision":1,"authToken":"token"}`)
+
+	store := session.InitSessionStore(filename)
+	assert.Equal(t, session.Revision, store.Session.Revision)
+	assert.Empty(t, store.Session.AuthToken)
+}
+
+func TestSessionLoadNewerRevisionDiscarded(t *testing.T) {
+	filename := writeSessionFile(t, `{"revision":999,"authToken":"token"}`)
+
+	store := session.InitSessionStore(filename)
+	assert.Equal(t, session.Revision, store.Session.Revision)
+	assert.Empty(t, store.Session.AuthToken)
+}
+
+func TestSessionLoadMalformedDiscarded(t *testing.T) {
+	filename := writeSessionFile(t, `{"revision":2,"authTok`)
+
+	store := session.InitSessionStore(filename)
+	assert.Empty(t, store.Session.AuthToken)
+}
+
+func TestSessionLoadMigrated(t *testing.T) {
+	session.Migrations[1] = func(data map[string]interface{}) error {
+		data["authToken"] = data["token"]
+		return nil
+	}
+
+	defer delete(session.Migrations, 1)
+
+	filename := writeSessionFile(t, `{"revision":1,"token":"token"}`)
+
+	store := session.InitSessionStore(filename)
+	assert.Equal(t, session.Revision, store.Session.Revision)
+	assert.Equal(t, "token", store.Session.AuthToken)
+}
+
+func TestSessionCapabilitiesStored(t *testing.T) {
+	filename := writeSessionFile(t, `{"revision":2,"authToken":"token"}`)
+
+	store := session.InitSessionStore(filename)
+	assert.Equal(t, "token", store.Session.AuthToken)
+
+	_, changed := store.UpdateCapabilities("abc", baby.Capabilities{FirmwareVersion: "1.2.3", NightLight: utils.ConstRefBool(false)})
+	assert.True(t, changed)
+
+	_, changed = store.UpdateCapabilities("abc", baby.Capabilities{FirmwareVersion: "1.2.3"})
+	assert.False(t, changed)
+
+	capabilities := session.InitSessionStore(filename).GetCapabilities("abc")
+	assert.Equal(t, "1.2.3", capabilities.FirmwareVersion)
+	assert.False(t, capabilities.HasNightLight())
+}
+
+func TestSessionFilePermissions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nanit-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	// Missing directory is created, new file is accessible by the owner only
+	filename := filepath.Join(dir, "nested", "session.json")
+	store := session.InitSessionStore(filename)
+	store.Save()
+
+	if info, err := os.Stat(filepath.Dir(filename)); assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+	}
+
+	if info, err := os.Stat(filename); assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	// World readable file is restricted upon load
+	assert.NoError(t, os.Chmod(filename, 0644))
+	session.InitSessionStore(filename)
+
+	if info, err := os.Stat(filename); assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+}
+
+func TestSessionConcurrentSave(t *testing.T) {
+	filename := writeSessionFile(t, `{"revision":3,"authToken":"token","babies":[{"uid":"abc","camera_uid":"cam"}]}`)
+	store := session.InitSessionStore(filename)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			store.Save()
+		}()
+
+		go func(i int) {
+			defer wg.Done()
+			store.UpdateCapabilities(fmt.Sprintf("abc-cam%v", i), baby.Capabilities{NightLight: utils.ConstRefBool(true)})
+		}(i)
+	}
+
+	wg.Wait()
+
+	reloaded := session.InitSessionStore(filename)
+	assert.Equal(t, "token", reloaded.Session.AuthToken)
+	assert.Len(t, reloaded.Session.Capabilities, 20)
+
+	// Temporary files are not left behind
+	files, err := ioutil.ReadDir(filepath.Dir(filename))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	info, err := os.Stat(filename)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+}
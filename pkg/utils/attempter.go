@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"sync/atomic"
 	"time"
@@ -27,12 +28,20 @@ type PerseverenceOpts struct {
 
 	// RunnerID - optional string name for the runner for debugging purposes
 	RunnerID string
+
+	// MaxFailures - gives up after this many consecutive failed attempts (0 = never gives up)
+	// Attempts are consecutive unless ResetThreshold resets them
+	MaxFailures int
 }
 
+// ErrGaveUp - returned by RunWithPerseverance when MaxFailures is reached (wraps the last error)
+var ErrGaveUp = errors.New("gave up after too many failed attempts")
+
 var lastPerseveranceRunnerID int32 = 0
 
 // RunWithPerseverance - runs handler and tries it again if it fails
-func RunWithPerseverance(handler func(AttemptContext), ctx GracefulContext, opts PerseverenceOpts) {
+// Returns nil once the handler succeeds or the context is cancelled, error wrapping ErrGaveUp if MaxFailures is reached
+func RunWithPerseverance(handler func(AttemptContext), ctx GracefulContext, opts PerseverenceOpts) error {
 	try := 1
 	timer := time.NewTimer(0)
 	runnerID := fmt.Sprintf("runner%v", atomic.AddInt32(&lastPerseveranceRunnerID, 1))
@@ -47,7 +56,7 @@ func RunWithPerseverance(handler func(AttemptContext), ctx GracefulContext, opts
 		case <-ctx.Done():
 			sublog.Trace().Msg("Perseverance run cancelled, there will be no further attempts")
 			timer.Stop()
-			return
+			return nil
 		case timeScheduled := <-timer.C:
 			hasBeenCancelled, err := ctx.RunAsChild(func(childGracefulCtx GracefulContext) {
 				sublog.Trace().Int("try", try).Msg("Starting attempt")
@@ -57,14 +66,14 @@ func RunWithPerseverance(handler func(AttemptContext), ctx GracefulContext, opts
 			if hasBeenCancelled {
 				sublog.Trace().Msg("Perseverance run cancelled in the middle of execution, there will be no further attempts")
 				timer.Stop()
-				return
+				return nil
 			}
 
 			timeTaken := time.Since(timeScheduled)
 
 			if err == nil {
 				sublog.Trace().Msg("Attempt finished without an error")
-				return
+				return nil
 			}
 
 			sublog.Trace().Err(err).Msg("Attempt finished with error")
@@ -73,6 +82,9 @@ func RunWithPerseverance(handler func(AttemptContext), ctx GracefulContext, opts
 				sublog.Trace().Msgf("Previous attempt was %v ago, resetting tries", timeTaken)
 				try = 1
 				timer.Reset(0)
+			} else if opts.MaxFailures > 0 && try >= opts.MaxFailures {
+				sublog.Trace().Int("tries", try).Msg("Too many failed attempts, giving up")
+				return fmt.Errorf("%w (%v): %v", ErrGaveUp, try, err)
 			} else {
 				cooldown := opts.Cooldown[MinInt(try, len(opts.Cooldown))-1]
 				try++
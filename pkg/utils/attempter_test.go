@@ -1,6 +1,7 @@
 package utils_test
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"testing"
@@ -8,6 +9,7 @@ import (
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
 	"gitlab.com/adam.stanek/nanit/pkg/utils"
 )
 
@@ -37,3 +39,23 @@ func TestRunWithPerseverance(t *testing.T) {
 		})
 	}).Wait()
 }
+
+func TestRunWithPerseveranceMaxFailures(t *testing.T) {
+	tries := 0
+	var err error
+
+	utils.RunWithGracefulCancel(func(ctx utils.GracefulContext) {
+		err = utils.RunWithPerseverance(func(attempt utils.AttemptContext) {
+			tries++
+			attempt.Fail(fmt.Errorf("simulated failure %v", attempt.GetTry()))
+		}, ctx, utils.PerseverenceOpts{
+			ResetThreshold: 1 * time.Second,
+			Cooldown:       []time.Duration{10 * time.Millisecond},
+			MaxFailures:    3,
+		})
+	}).Wait()
+
+	assert.Equal(t, 3, tries)
+	assert.True(t, errors.Is(err, utils.ErrGaveUp))
+	assert.Contains(t, err.Error(), "simulated failure 3")
+}
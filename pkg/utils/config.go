@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// configEnvPrefix - all the configuration variables share the prefix
+const configEnvPrefix = "NANIT_"
+
+// LoadConfigFile - loads configuration file into environment variables, format is decided by the extension
+// Supported formats: YAML (.yaml, .yml), TOML (.toml), JSON (.json) and dotenv (.env)
+// Keys are mapped onto the environment variable names, nested sections are joined by underscore:
+//
+//	mqtt:
+//	  enabled: true      ->  NANIT_MQTT_ENABLED=true
+//	  broker_url: ...    ->  NANIT_MQTT_BROKER_URL=...
+//
+// Environment variables which are already set take precedence over the file
+func LoadConfigFile(filename string) {
+	values, err := readConfigFile(filename)
+	if err != nil {
+		log.Fatal().Str("path", filename).Err(err).Msg("Unable to read config file")
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	numLoaded := 0
+	for _, key := range keys {
+		if _, isSet := os.LookupEnv(key); isSet {
+			log.Debug().Str("var", key).Msg("Config file value overridden by environment variable")
+			continue
+		}
+
+		os.Setenv(key, values[key])
+		numLoaded++
+	}
+
+	log.Info().Str("path", filename).Int("num_values", numLoaded).Msg("Configuration loaded from file")
+}
+
+func readConfigFile(filename string) (map[string]string, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+
+	if ext == ".env" || filepath.Base(filename) == ".env" {
+		values, err := godotenv.Read(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		return flattenConfig(toConfigMap(values)), nil
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := make(map[string]interface{})
+
+	switch ext {
+	case ".yaml", ".yml", ".json":
+		// Note: JSON is a subset of YAML
+		err = yaml.Unmarshal(data, &parsed)
+	case ".toml":
+		err = toml.Unmarshal(data, &parsed)
+	default:
+		return nil, fmt.Errorf("unsupported config file format %q (allowed .yaml, .yml, .toml, .json, .env)", ext)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return flattenConfig(parsed), nil
+}
+
+func toConfigMap(values map[string]string) map[string]interface{} {
+	out := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		out[key] = value
+	}
+
+	return out
+}
+
+// flattenConfig - turns (possibly nested) config into environment variable names and values
+func flattenConfig(config map[string]interface{}) map[string]string {
+	out := make(map[string]string)
+	flattenConfigInto(out, "", config)
+
+	for key, value := range out {
+		if !strings.HasPrefix(key, configEnvPrefix) {
+			delete(out, key)
+			out[configEnvPrefix+key] = value
+		}
+	}
+
+	return out
+}
+
+func flattenConfigInto(out map[string]string, prefix string, config map[string]interface{}) {
+	for key, value := range config {
+		name := prefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_", " ", "_").Replace(key))
+
+		switch v := value.(type) {
+		case nil:
+			continue
+		case map[string]interface{}:
+			flattenConfigInto(out, name+"_", v)
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprintf("%v", item)
+			}
+
+			out[name] = strings.Join(items, ",")
+		default:
+			out[name] = fmt.Sprintf("%v", v)
+		}
+	}
+}
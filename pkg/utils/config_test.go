@@ -0,0 +1,54 @@
+package utils_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nanit-config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"config.yaml": "mqtt:\n  enabled: true\n  broker_url: tcp://broker:1883\nnotify:\n  events: [stream_down, temperature_high]\n",
+		"config.toml": "[mqtt]\nenabled = true\nbroker_url = \"tcp://broker:1883\"\n[notify]\nevents = [\"stream_down\", \"temperature_high\"]\n",
+		"config.json": `{"mqtt": {"enabled": true, "broker_url": "tcp://broker:1883"}, "notify": {"events": ["stream_down", "temperature_high"]}}`,
+		"config.env":  "NANIT_MQTT_ENABLED=true\nNANIT_MQTT_BROKER_URL=tcp://broker:1883\nNANIT_NOTIFY_EVENTS=stream_down,temperature_high\n",
+	}
+
+	vars := []string{"NANIT_MQTT_ENABLED", "NANIT_MQTT_BROKER_URL", "NANIT_NOTIFY_EVENTS"}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			for _, v := range vars {
+				os.Unsetenv(v)
+			}
+
+			defer func() {
+				for _, v := range vars {
+					os.Unsetenv(v)
+				}
+			}()
+
+			// Environment takes precedence
+			os.Setenv("NANIT_MQTT_BROKER_URL", "tcp://from-env:1883")
+
+			filename := filepath.Join(dir, name)
+			ioutil.WriteFile(filename, []byte(content), 0644)
+			utils.LoadConfigFile(filename)
+
+			assert.Equal(t, "true", os.Getenv("NANIT_MQTT_ENABLED"))
+			assert.Equal(t, "tcp://from-env:1883", os.Getenv("NANIT_MQTT_BROKER_URL"))
+			assert.Equal(t, []string{"stream_down", "temperature_high"}, utils.EnvVarList("NANIT_NOTIFY_EVENTS", nil))
+		})
+	}
+}
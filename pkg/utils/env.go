@@ -3,6 +3,9 @@ package utils
 import (
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/rs/zerolog/log"
@@ -61,3 +64,104 @@ func LoadDotEnvFile() {
 		log.Info().Str("path", absFilepath).Msg("Additional environment variables loaded from .env file")
 	}
 }
+
+// ReloadDotEnvFile - re-reads .env file in the current working directory, its values override current environment variables
+func ReloadDotEnvFile() error {
+	absFilepath, err := filepath.Abs(".env")
+	if err != nil {
+		return err
+	}
+
+	return godotenv.Overload(absFilepath)
+}
+
+// EnvVarInt - retrieves value of integer environment variable, fails if variable contains non-integer value
+func EnvVarInt(varName string, defaultValue int) int {
+	value := EnvVarStr(varName, "")
+	if value == "" {
+		return defaultValue
+	}
+
+	intValue, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatal().Msgf("Unexpected value for integer environment variable %v", varName)
+	}
+
+	return intValue
+}
+
+// EnvVarDuration - retrieves value of duration environment variable (ie. 500ms, 10s, 1m), fails if variable contains invalid value
+func EnvVarDuration(varName string, defaultValue time.Duration) time.Duration {
+	value := EnvVarStr(varName, "")
+	if value == "" {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatal().Msgf("Unexpected value for duration environment variable %v (examples of allowed values 500ms, 10s, 1m)", varName)
+	}
+
+	return duration
+}
+
+// EnvVarFloat - retrieves value of float environment variable, fails if variable contains non-float value
+func EnvVarFloat(varName string, defaultValue float64) float64 {
+	if value := EnvVarOptFloat(varName); value != nil {
+		return *value
+	}
+
+	return defaultValue
+}
+
+// EnvVarOptFloat - retrieves value of optional float environment variable, returns nil if not set, fails if variable contains non-float value
+func EnvVarOptFloat(varName string) *float64 {
+	value := EnvVarStr(varName, "")
+	if value == "" {
+		return nil
+	}
+
+	floatValue, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Fatal().Msgf("Unexpected value for float environment variable %v", varName)
+	}
+
+	return &floatValue
+}
+
+// EnvVarList - retrieves value of comma separated list environment variable, while applying default
+func EnvVarList(varName string, defaultValue []string) []string {
+	value := EnvVarStr(varName, "")
+	if value == "" {
+		return defaultValue
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// EnvVarDurationMap - retrieves value of comma separated key:duration environment variable (ie. abc:30s,def:1m), fails if it contains invalid value
+func EnvVarDurationMap(varName string) map[string]time.Duration {
+	m := make(map[string]time.Duration)
+	for _, item := range EnvVarList(varName, nil) {
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 {
+			log.Fatal().Msgf("Unexpected value for environment variable %v (expected key:duration pairs, ie. abc:30s,def:1m)", varName)
+		}
+
+		duration, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+		if err != nil {
+			log.Fatal().Msgf("Unexpected duration for key %v in environment variable %v (examples of allowed values 500ms, 10s, 1m)", parts[0], varName)
+		}
+
+		m[strings.TrimSpace(parts[0])] = duration
+	}
+
+	return m
+}
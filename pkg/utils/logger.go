@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+)
+
+type logOutputHolder struct {
+	writer io.Writer
+}
+
+// logOutput - output shared by the component loggers (can be replaced after they are created)
+var logOutput atomic.Value
+
+type logOutputWriter struct{}
+
+func (logOutputWriter) Write(p []byte) (int, error) {
+	if holder, ok := logOutput.Load().(logOutputHolder); ok {
+		return holder.writer.Write(p)
+	}
+
+	return os.Stderr.Write(p)
+}
+
+// SetLogOutput - sets output of the component loggers
+func SetLogOutput(writer io.Writer) {
+	logOutput.Store(logOutputHolder{writer})
+}
+
+var (
+	logLevelsMu        sync.RWMutex
+	defaultLogLevel    = zerolog.InfoLevel
+	componentLogLevels = make(map[string]zerolog.Level)
+)
+
+// SetLogLevels - sets the default log level and the levels of the components which should differ from it
+func SetLogLevels(defaultLevel zerolog.Level, componentLevels map[string]zerolog.Level) {
+	logLevelsMu.Lock()
+	defer logLevelsMu.Unlock()
+
+	defaultLogLevel = defaultLevel
+	componentLogLevels = make(map[string]zerolog.Level)
+
+	// Global level filters out the events before they reach the hooks, so it has to allow the most verbose one
+	minLevel := defaultLevel
+	for component, level := range componentLevels {
+		componentLogLevels[component] = level
+		if level < minLevel {
+			minLevel = level
+		}
+	}
+
+	zerolog.SetGlobalLevel(minLevel)
+}
+
+func getLogLevel(component string) zerolog.Level {
+	logLevelsMu.RLock()
+	defer logLevelsMu.RUnlock()
+
+	if level, ok := componentLogLevels[component]; ok {
+		return level
+	}
+
+	return defaultLogLevel
+}
+
+type logLevelHook struct {
+	component string
+}
+
+func (hook logLevelHook) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	if level < getLogLevel(hook.component) {
+		e.Discard()
+	}
+}
+
+// LogLevelHook - drops the events below the level of the component (empty component = default level)
+func LogLevelHook(component string) zerolog.Hook {
+	return logLevelHook{component}
+}
+
+// NewComponentLogger - creates logger of the component, its level can be set separately (see SetLogLevels)
+func NewComponentLogger(component string) zerolog.Logger {
+	return zerolog.New(logOutputWriter{}).With().Timestamp().Str("component", component).Logger().Hook(LogLevelHook(component))
+}
@@ -0,0 +1,38 @@
+package utils_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/adam.stanek/nanit/pkg/utils"
+)
+
+func TestComponentLogLevels(t *testing.T) {
+	var buf bytes.Buffer
+	utils.SetLogOutput(&buf)
+	defer utils.SetLogOutput(os.Stderr)
+
+	utils.SetLogLevels(zerolog.InfoLevel, map[string]zerolog.Level{"client": zerolog.DebugLevel, "mqtt": zerolog.WarnLevel})
+	defer utils.SetLogLevels(zerolog.InfoLevel, nil)
+
+	clientLog := utils.NewComponentLogger("client")
+	mqttLog := utils.NewComponentLogger("mqtt")
+	appLog := utils.NewComponentLogger("app")
+
+	clientLog.Debug().Msg("client debug")
+	mqttLog.Info().Msg("mqtt info")
+	mqttLog.Warn().Msg("mqtt warn")
+	appLog.Debug().Msg("app debug")
+	appLog.Info().Msg("app info")
+
+	output := buf.String()
+	assert.Contains(t, output, "client debug")
+	assert.NotContains(t, output, "mqtt info")
+	assert.Contains(t, output, "mqtt warn")
+	assert.NotContains(t, output, "app debug")
+	assert.Contains(t, output, "app info")
+	assert.Contains(t, output, `"component":"client"`)
+}
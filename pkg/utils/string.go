@@ -1,6 +1,9 @@
 package utils
 
-import "strings"
+import (
+	"net/url"
+	"strings"
+)
 
 // AnonymizeToken - replaces chunk of a string with '*'
 func AnonymizeToken(token string, clearLen int) string {
@@ -11,3 +14,66 @@ func AnonymizeToken(token string, clearLen int) string {
 
 	return strings.Repeat("*", len(token))
 }
+
+// redactedPlaceholder - replaces the secrets in the sanitized strings
+const redactedPlaceholder = "REDACTED"
+
+// secretQueryParams - query parameters which carry secrets (lower case)
+var secretQueryParams = []string{"token", "access_token", "auth", "key", "password", "secret", "signature"}
+
+// RedactURL - replaces password and secret query parameters of the URL with a placeholder, so that it can be logged
+// Note: strings which are not absolute URLs are returned as they are
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		// Typically unescaped special characters in the password (#, %, ?), only the scheme is kept
+		if schemeEnd := strings.Index(rawURL, "://"); schemeEnd > 0 {
+			return rawURL[:schemeEnd+3] + redactedPlaceholder
+		}
+
+		return rawURL
+	}
+
+	if u.Scheme == "" {
+		return rawURL
+	}
+
+	changed := false
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.UserPassword(u.User.Username(), redactedPlaceholder)
+		changed = true
+	}
+
+	if u.RawQuery != "" {
+		query := u.Query()
+		for name := range query {
+			for _, secretName := range secretQueryParams {
+				if strings.ToLower(name) == secretName {
+					query.Set(name, redactedPlaceholder)
+					changed = true
+				}
+			}
+		}
+
+		if changed {
+			u.RawQuery = query.Encode()
+		}
+	}
+
+	if !changed {
+		return rawURL
+	}
+
+	return u.String()
+}
+
+// RedactCommand - returns the command line with the URLs in its arguments redacted (see RedactURL)
+func RedactCommand(name string, args []string) string {
+	redacted := make([]string, 0, len(args)+1)
+	redacted = append(redacted, name)
+	for _, arg := range args {
+		redacted = append(redacted, RedactURL(arg))
+	}
+
+	return strings.Join(redacted, " ")
+}